@@ -2,9 +2,16 @@ package gosocket
 
 import (
 	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
 )
 
 var (
+	// TcpApp is the default app used by the package-level functions like Run and Router
+	// 默认的App，包级别的函数都作用于它
 	TcpApp *App
 )
 
@@ -20,21 +27,188 @@ type AppConfig struct {
 	TlsEnable bool   //Whether to enable tls 是否开启TLS
 	TlsCert   string //The certification used by tls TLS证书
 	TlsKey    string //The key used by tls TLS密钥
+
+	// ActionTimeout is the default deadline of the actions, 0 means no deadline
+	// The context of the request is cancelled once the deadline passes, see IActionTimeout
+	//action的默认超时时间，0表示不超时
+	ActionTimeout time.Duration
+
+	// MaxConcurrentRequests is the number of requests of a connection processed in parallel, 0 or 1 means one by one
+	// Pings and later requests are no longer blocked by a slow action, the responses are matched by MessageId
+	// When it's greater than 1, the actions of a connection share its IUser in parallel, so the auth class,
+	// e.g. the fields read by GetUid and GetRoles and the ones changed by the actions, must be safe for concurrent use
+	//每个连接并行处理的请求数，0或1表示逐个处理，大于1时同一连接的action并行使用其IUser，认证类必须是并发安全的
+	MaxConcurrentRequests int
+	// OrderingKey keeps the requests with the same key in order when they are processed in parallel, nil means no order
+	// See OrderByType and OrderByController
+	//并行处理时，键相同的请求保持顺序，nil表示不限制顺序
+	OrderingKey OrderingKeyFunc
+
+	// Engine is the way the connections are served, EngineEpoll saves the memory of the idle connections on linux
+	//连接的服务方式，EngineEpoll在linux上可节省空闲连接的内存
+	Engine Engine
+	// EngineWorkers is the number of the workers handling the messages of EngineEpoll, 0 means 256
+	// A worker is occupied while an action is running, unless MaxConcurrentRequests is greater than 1.
+	// The same number of writers write the messages queued to the connections
+	//EngineEpoll处理消息的工作协程数，0表示256，除非MaxConcurrentRequests大于1，action运行时会占用工作协程，发出消息的写协程数与之相同
+	EngineWorkers int
+
+	// InboundPolicy is what to do when the queue of the messages from a client is full, see BackpressurePolicy
+	//收到的消息队列满时的策略
+	InboundPolicy BackpressurePolicy
+	// OutboundPolicy is what to do when the queue of the messages to a client is full, see BackpressurePolicy
+	// It can be overridden for a message by MessageHandler.SubmitWithOptions
+	//发出的消息队列满时的策略，可通过MessageHandler.SubmitWithOptions为单条消息指定
+	OutboundPolicy BackpressurePolicy
+	// BackpressureTimeout is the time PolicyBlock waits, 0 means 5 seconds
+	//PolicyBlock的等待时间，0表示5秒
+	BackpressureTimeout time.Duration
+	// OnDrop is called for every message dropped by the queues, including the responses and the ConnAcks
+	//每条被队列丢弃的消息都会调用，包括答复及连接回执
+	OnDrop func(event *DropEvent)
+
+	// ClientPoolShards is the number of the shards of the client pool, rounded up to the power of 2, 0 means 64
+	// More shards make the logins and the lookups wait less for each other
+	//连接池的分片数，向上取2的幂，0表示64，分片越多登陆和查询的相互等待越少
+	ClientPoolShards int
+
+	// SessionPolicy is what to do when a user logs in again on the server, see SessionPolicy, 0 means SessionKickOld
+	//用户在当前服务器上再次登陆时的策略，0表示SessionKickOld
+	SessionPolicy SessionPolicy
+	// MaxSessionsPerUser is the max sessions of a user with SessionAllowMulti, 0 means unlimited
+	//SessionAllowMulti下每个用户的最大会话数，0表示不限
+	MaxSessionsPerUser int
+
+	// ClusterBus connects the app to the other nodes of the cluster, nil means the app runs alone
+	// The presence of the users is shared through it, so that App.PushToUid and the session policy work cluster-wide
+	//连接集群中的其他节点，nil表示单独运行，通过它共享用户的在线状态，使App.PushToUid及会话策略在集群内生效
+	ClusterBus ClusterBus
+
+	// QueueLength is the length of the inbound and the outbound queue of a connection, 0 means 200
+	//每个连接收发队列的长度，0表示200
+	QueueLength int
+	// MaxPayloadLength is the max payload length of the requests in bytes, 0 means 16KB
+	// It can be overridden by IActionPayloadLimit for actions, or by IUserLimits for users
+	//请求的最大长度（字节），0表示16KB，可通过IActionPayloadLimit为action或通过IUserLimits为用户覆盖
+	MaxPayloadLength int
+	// ReadTimeoutFactor closes the connection if nothing arrives in ReadTimeoutFactor times of the keep alive time
+	// sent by the client, 0 means 1.5
+	//超过客户端心跳间隔的ReadTimeoutFactor倍没有收到数据时断开连接，0表示1.5
+	ReadTimeoutFactor float64
+	// RefreshInterval is the interval of calling IUser.Refresh to keep the user online, 0 means 3 minutes
+	//调用IUser.Refresh维持在线状态的间隔，0表示3分钟
+	RefreshInterval time.Duration
+	// TcpKeepAlivePeriod is the period of the keep alive of tcp, 0 means 1 minute, negative means disabled
+	//tcp保活的间隔，0表示1分钟，负数表示关闭
+	TcpKeepAlivePeriod time.Duration
+
+	// ReadBufferSize is the size of the buffer reading a connection, 0 means 4KB
+	//读取连接的缓冲区大小，0表示4KB
+	ReadBufferSize int
+	// WriteBufferSize is the size of the buffer writing a connection, 0 means 4KB
+	// The messages waiting to be sent are written into it and flushed together
+	//写入连接的缓冲区大小，0表示4KB，等待发送的消息写入缓冲区后一起发送
+	WriteBufferSize int
 }
 
 // App is the entry class to start the server
+// Each App owns its routes, auth class, client pool and loggers, so several Apps can run in one process
+// 每个App拥有独立的路由、登陆验证类、连接池和日志，同一进程中可以运行多个App
 type App struct {
 	Server  *Server     //Responsible for listening and serving requests
 	Config  *AppConfig  //Same as appConfig in the Run function
 	Log     ILogger     //Same as log in the Run function
 	FastLog IFastLogger //Same as fastLog in the Run function
+
+	// Go language can't create instance from class name dynamically
+	// therefore I use a map to reflect the string to the class here
+	// Just use the Router function to add a new route with a new controller
+	// 由于Go无法动态创建类型，故使用map将字符串映射到类型
+	controllerMap         map[string]IController
+	controllerRoutes      map[string][]*Route      //The routes of the controllers, see Routes
+	handlerMap            map[string]*TypedHandler //The typed handlers, see Handle
+	errorMapper           ErrorMapper              //Map the errors of the typed handlers, see SetErrorMapper
+	middlewares           []Middleware             //Middlewares for all the requests, see Use
+	controllerMiddlewares map[string][]Middleware  //Middlewares for the controllers, see UseController
+	authUser              IUser                    //The prototype of the users, see SetAuthUser
+	newUser               func() IUser             //Creates the users instead of the prototype if it isn't nil, used by the internal apps such as the mesh
+	clientPool            *ClientPool              //All the online users of this app
+	restartManager        *RestartManager          //nil unless InitGracefulRestart is called
+	backpressureStats     [2]QueueStats            //The dropped messages of Inbound and Outbound, see BackpressureStats
+	payloadLimits         map[string]int           //The max payload length of the routes, see IActionPayloadLimit
+	readerPool            sync.Pool                //The buffered readers of the connections
+	writerPool            sync.Pool                //The buffered writers of the connections
 }
 
 func NewApp() *App {
-	app := &App{}
+	app := &App{
+		controllerMap:         make(map[string]IController),
+		controllerRoutes:      make(map[string][]*Route),
+		handlerMap:            make(map[string]*TypedHandler),
+		controllerMiddlewares: make(map[string][]Middleware),
+		payloadLimits:         make(map[string]int),
+		authUser:              &AuthUser{},
+		clientPool:            newClientPool(kDefaultClientPoolShards),
+	}
 	return app
 }
 
+// Router Register a controller to its corresponding name
+// The controller is checked once here, it panics if any action in GetActionParamMap doesn't have a matching method
+// The name is case-insensitive, the same as the routing of the requests
+// 注册Controller以及对应的名字，注册时检查所有action，有错误时panic
+func (app *App) Router(controllerName string, controller IController) {
+	controllerName = strings.ToLower(controllerName)
+	//Remove the limits of the controller registered before
+	//移除之前注册的同名Controller的限制
+	for _, route := range app.controllerRoutes[controllerName] {
+		delete(app.payloadLimits, route.Type)
+	}
+	routes := buildControllerRoutes(controllerName, controller)
+	for _, route := range routes {
+		//The typed handlers take priority, so do their limits
+		//typed handler优先，其限制也优先
+		if _, ok := app.handlerMap[route.Type]; ok {
+			continue
+		}
+		if route.MaxPayloadLength > 0 {
+			app.payloadLimits[route.Type] = route.MaxPayloadLength
+		}
+	}
+	app.controllerRoutes[controllerName] = routes
+	app.controllerMap[controllerName] = controller
+}
+
+// SetAuthUser set the class used to customize the user identification process
+// A new instance of the class is created for each connection
+// Pass nil to use the default AuthUser
+// 设置登陆验证类，传nil使用默认的AuthUser
+func (app *App) SetAuthUser(user IUser) {
+	if user == nil {
+		app.authUser = &AuthUser{}
+	} else {
+		app.authUser = user
+	}
+}
+
+// ClientPool returns the pool of all the online users of this app
+// 获取连接池
+func (app *App) ClientPool() *ClientPool {
+	return app.clientPool
+}
+
+// RestartManager returns the restart manager, it's nil unless InitGracefulRestart is called
+// 获取重启管理器
+func (app *App) RestartManager() *RestartManager {
+	return app.restartManager
+}
+
+// Whether the app received the restart signal and the connections should be closed once they are idle
+// 是否收到了重启信号，连接空闲后应断开
+func (app *App) isRestarting() bool {
+	return app.restartManager != nil && app.restartManager.IsStop()
+}
+
 // Run start the app server
 // 启动服务器
 // The appConfig is used to configure the server
@@ -44,19 +218,26 @@ func NewApp() *App {
 func (app *App) Run(appConfig *AppConfig, log ILogger, fastLog IFastLogger) {
 	defer func() {
 		if e := recover(); e != nil {
-			TcpApp.Log.Error(e)
+			app.Log.Error(e)
 		}
 	}()
 	if log == nil || fastLog == nil {
 		panic("log or fastLog can't be nil")
 	}
-	app.Config = appConfig
 	app.Log = log
 	app.FastLog = fastLog
+	if err := appConfig.Validate(); err != nil {
+		panic(err)
+	}
+	app.Config = appConfig
+	app.clientPool.reshard(app.clientPoolShards())
+	if err := app.joinCluster(); err != nil {
+		panic(err)
+	}
 	//Initialize graceful restart
-	InitGracefulRestart()
+	app.InitGracefulRestart()
 	//创建一个server
-	app.Server = NewServer(app.Config.TcpAddr)
+	app.Server = newAppServer(app, app.Config.TcpAddr)
 	//Whether to enable tls
 	if app.Config.TlsEnable {
 		//tls certificate
@@ -71,3 +252,28 @@ func (app *App) Run(appConfig *AppConfig, log ILogger, fastLog IFastLogger) {
 		app.Server.ListenAndServe(nil)
 	}
 }
+
+// Serve start serving on the listener, which can be any net.Listener including in-memory ones
+// Unlike Run, it returns errors instead of panicking, and graceful restart isn't initialized
+// The TcpAddr, TcpPort and tls fields of appConfig are ignored, pass nil to use an empty config
+// 在指定的listener上启动服务器，出错时返回错误而不是panic，不支持平滑重启
+func (app *App) Serve(listener net.Listener, appConfig *AppConfig, log ILogger, fastLog IFastLogger) error {
+	if log == nil || fastLog == nil {
+		return errors.New("log or fastLog can't be nil")
+	}
+	if appConfig == nil {
+		appConfig = &AppConfig{}
+	}
+	if err := appConfig.Validate(); err != nil {
+		return err
+	}
+	app.Config = appConfig
+	app.Log = log
+	app.FastLog = fastLog
+	app.clientPool.reshard(app.clientPoolShards())
+	if err := app.joinCluster(); err != nil {
+		return err
+	}
+	app.Server = newAppServer(app, listener.Addr().String())
+	return app.Server.Serve(listener, nil)
+}
@@ -1,10 +1,16 @@
 package gosocket
 
 import (
+	"sync/atomic"
+
 	"github.com/yankawayu/go-socket/packet"
 	"go.uber.org/zap/zapcore"
 )
 
+// IUser is the auth class, a new one is created for each connection, see App.SetAuthUser
+// If AppConfig.MaxConcurrentRequests is greater than 1, it's used by the actions of the connection in parallel,
+// so it must be safe for concurrent use
+// 认证类，每个连接创建一个，AppConfig.MaxConcurrentRequests大于1时会被同一连接的action并行使用，必须是并发安全的
 type IUser interface {
 	// Auth Check whether the login information provided by the client is valid
 	// 获取用户信息
@@ -43,8 +49,20 @@ type IUser interface {
 	HandleNoReplyReq(payloadType string, payload string)
 }
 
+// IRoleUser can be implemented by the auth class to report the roles of the current user
+// The roles are checked against the ones required by the actions, see IActionRole
+// 实现此接口以提供当前用户的角色，用于检查action的权限
+type IRoleUser interface {
+	// GetRoles get the roles or permissions of the current user
+	// 当前用户的角色或权限
+	GetRoles() []string
+}
+
 // AuthUser Default login auth class, should inherit this class to implement concrete auth logic
 // 默认登陆验证父类，继承后实现具体登陆逻辑
+// Uid is accessed atomically by its methods, since the workers read it while the connection logs out
+// On 32-bit platforms, keep AuthUser the first field of the struct embedding it so that Uid is 64-bit aligned
+// Uid由方法原子访问，因为注销时工作协程可能正在读取，32位平台上需将AuthUser放在嵌入结构体的第一个字段以保证对齐
 type AuthUser struct {
 	Uid int64
 }
@@ -62,7 +80,7 @@ func (user *AuthUser) Refresh() {}
 
 // GetUid This function is rarely changed
 func (user *AuthUser) GetUid() int64 {
-	return user.Uid
+	return atomic.LoadInt64(&user.Uid)
 }
 
 // GetConnectInfo Override this function to put extra connect info into the log
@@ -85,7 +103,7 @@ func (user *AuthUser) HandleNoReplyReq(payloadType string, payload string) {}
 
 // IsLogin This function is rarely changed
 func (user *AuthUser) IsLogin() bool {
-	return user.Uid != 0
+	return atomic.LoadInt64(&user.Uid) != 0
 }
 
 // RequireLock Override this function to get a lock before operating the user's data
@@ -121,13 +139,14 @@ func (user *AuthUser) Auth(payload string, ip string) (uid int64, code packet.Re
 // You should return packet.RetCodeAccepted if everything goes well
 // Or else you can return packet.RetCodeBadLoginInfo to block the login process
 func (user *AuthUser) Login(uid int64) packet.ReturnCode {
-	user.Uid = uid
+	atomic.StoreInt64(&user.Uid, uid)
 	return packet.RetCodeAccepted
 }
 
 // Logout Override this function to mark the user is offline
 // The param `isKickOut` is used to mark whether the user is kicked out by the user himself
 // If the user reconnect on the same server, it will cause the old connection to be kicked out (It usually happens under bad network)
+// It's also true if the user still has other sessions on the server, see AppConfig.SessionPolicy
 func (user *AuthUser) Logout(isKickOut bool) {
-	user.Uid = 0
+	atomic.StoreInt64(&user.Uid, 0)
 }
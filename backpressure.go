@@ -0,0 +1,407 @@
+package gosocket
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/yankawayu/go-socket/packet"
+)
+
+// BackpressurePolicy decides what to do with a message when the queue of the connection is full
+// 连接的队列满时如何处理消息
+type BackpressurePolicy uint8
+
+const (
+	// PolicyDropNewest drops the message being queued, it's the default one
+	//丢弃正在加入队列的消息，默认策略
+	PolicyDropNewest BackpressurePolicy = iota
+	// PolicyBlock waits for the room in the queue until the timeout, then drops the message
+	//等待队列空出位置直到超时，超时后丢弃消息
+	PolicyBlock
+	// PolicyDropOldest drops the oldest message in the queue to make room for the new one
+	//丢弃队列中最早的消息，为新消息腾出位置
+	PolicyDropOldest
+	// PolicyCoalesce replaces the queued message with the same key, only the latest one is sent.
+	// The message is dropped if the queue is full and there is no message to replace. It only applies to outbound
+	//替换队列中键相同的消息，只发送最新的一条，队列满且没有可替换的消息时丢弃，仅用于发出队列
+	PolicyCoalesce
+	// PolicyDisconnect closes the connection of the slow consumer
+	//断开处理不过来的连接
+	PolicyDisconnect
+)
+
+func (policy BackpressurePolicy) String() string {
+	switch policy {
+	case PolicyDropNewest:
+		return "drop_newest"
+	case PolicyBlock:
+		return "block"
+	case PolicyDropOldest:
+		return "drop_oldest"
+	case PolicyCoalesce:
+		return "coalesce"
+	case PolicyDisconnect:
+		return "disconnect"
+	}
+	return "unknown"
+}
+
+// Direction is the direction of the queue
+// 队列的方向
+type Direction uint8
+
+const (
+	// Inbound is the queue of the messages from the client waiting to be handled
+	//收到的消息等待处理的队列
+	Inbound Direction = iota
+	// Outbound is the queue of the messages waiting to be sent to the client
+	//等待发给客户端的消息队列
+	Outbound
+)
+
+func (direction Direction) String() string {
+	if direction == Inbound {
+		return "inbound"
+	}
+	return "outbound"
+}
+
+// The reasons of the drop events
+// 丢弃消息的原因
+const (
+	DropReasonQueueFull    = "queue full"    // The queue is full
+	DropReasonTimeout      = "timeout"       // The queue is still full after PolicyBlock waited
+	DropReasonEvicted      = "evicted"       // Dropped by PolicyDropOldest to make room
+	DropReasonCoalesced    = "coalesced"     // Replaced by a later message with the same key
+	DropReasonSlowConsumer = "slow consumer" // The connection is closed by PolicyDisconnect
+	DropReasonClosed       = "closed"        // The connection is closed
+)
+
+// DropEvent describes a message that is dropped, see AppConfig.OnDrop
+// 被丢弃的消息
+type DropEvent struct {
+	Direction Direction
+	Policy    BackpressurePolicy
+	Reason    string          // One of the DropReason constants
+	ConnId    string          // The unique id of the connection
+	Uid       int64           // The uid of the user, 0 if not logged in
+	Message   packet.IMessage // The message dropped
+}
+
+// SubmitOptions overrides the outbound policy of the app for a message, see MessageHandler.SubmitWithOptions
+// 为单条消息指定发出队列的策略
+type SubmitOptions struct {
+	Policy BackpressurePolicy
+	// Timeout is the time PolicyBlock waits, 0 means AppConfig.BackpressureTimeout
+	//PolicyBlock的等待时间，0表示使用AppConfig.BackpressureTimeout
+	Timeout time.Duration
+	// Key is the key of PolicyCoalesce, empty means the type of the push
+	//PolicyCoalesce的键，为空时使用推送的类型
+	Key string
+}
+
+// QueueStats counts the messages dropped by the queues of a direction
+// 某个方向的队列丢弃消息的计数
+type QueueStats struct {
+	Dropped      uint64 // Dropped as the queue is full, including the ones evicted or timed out
+	Coalesced    uint64 // Replaced by later messages with the same key
+	Disconnected uint64 // The connections closed as slow consumers
+}
+
+// BackpressureStats counts the messages dropped by all the connections of the app
+// App所有连接丢弃消息的计数
+type BackpressureStats struct {
+	Inbound  QueueStats
+	Outbound QueueStats
+}
+
+// The time PolicyBlock waits by default
+// PolicyBlock默认的等待时间
+const kDefaultBackpressureTimeout = 5 * time.Second
+
+// BackpressureStats returns the counters of the dropped messages since the app started
+// 获取丢弃消息的计数
+func (app *App) BackpressureStats() BackpressureStats {
+	return BackpressureStats{
+		Inbound:  app.backpressureStats[Inbound].load(),
+		Outbound: app.backpressureStats[Outbound].load(),
+	}
+}
+
+func (stats *QueueStats) load() QueueStats {
+	return QueueStats{
+		Dropped:      atomic.LoadUint64(&stats.Dropped),
+		Coalesced:    atomic.LoadUint64(&stats.Coalesced),
+		Disconnected: atomic.LoadUint64(&stats.Disconnected),
+	}
+}
+
+func (app *App) backpressurePolicy(direction Direction) BackpressurePolicy {
+	if app.Config == nil {
+		return PolicyDropNewest
+	}
+	if direction == Inbound {
+		return app.Config.InboundPolicy
+	}
+	return app.Config.OutboundPolicy
+}
+
+func (app *App) backpressureTimeout(options *SubmitOptions) time.Duration {
+	if options != nil && options.Timeout > 0 {
+		return options.Timeout
+	}
+	if app.Config == nil || app.Config.BackpressureTimeout <= 0 {
+		return kDefaultBackpressureTimeout
+	}
+	return app.Config.BackpressureTimeout
+}
+
+// Count the dropped message and call the OnDrop callback
+// 计数并调用OnDrop回调
+func (handler *MessageHandler) reportDrop(direction Direction, policy BackpressurePolicy, reason string, message packet.IMessage) {
+	stats := &handler.app.backpressureStats[direction]
+	switch reason {
+	case DropReasonCoalesced:
+		atomic.AddUint64(&stats.Coalesced, 1)
+	case DropReasonSlowConsumer:
+		atomic.AddUint64(&stats.Disconnected, 1)
+	default:
+		atomic.AddUint64(&stats.Dropped, 1)
+	}
+	if reason != DropReasonCoalesced {
+		handler.app.Log.Warning(strconv.FormatInt(handler.user.GetUid(), 10) + "'s " + direction.String() + " message dropped: " + reason)
+	}
+	if handler.app.Config == nil || handler.app.Config.OnDrop == nil {
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			handler.app.Log.Error(err)
+		}
+	}()
+	handler.app.Config.OnDrop(&DropEvent{
+		Direction: direction,
+		Policy:    policy,
+		Reason:    reason,
+		ConnId:    handler.connId,
+		Uid:       handler.user.GetUid(),
+		Message:   message,
+	})
+}
+
+// Close the connection of the slow consumer, the handler is stopped once the reading thread notices it
+// 断开处理不过来的连接，读线程发现后会停止handler
+func (handler *MessageHandler) disconnectSlowConsumer(direction Direction, message packet.IMessage) {
+	handler.reportDrop(direction, PolicyDisconnect, DropReasonSlowConsumer, message)
+	if handler.closeConn != nil {
+		handler.closeConn()
+	}
+}
+
+// coalesceSlot holds the latest message of a key and its receipt, the queued job refers to it instead of the message
+// 保存某个键最新的消息及其回执，队列中的任务引用它而不是消息
+type coalesceSlot struct {
+	key     string
+	message packet.IMessage
+	receipt *Receipt
+}
+
+// Queue the job into jobChan by the policy, the receipt of the job is resolved by the writer only if it's queued or coalesced
+// 按策略将任务加入发出队列，仅在加入队列或合并时由写线程完成回执
+func (handler *MessageHandler) enqueueJob(job Job, options *SubmitOptions) SubmitStatus {
+	jobChan := handler.jobChan
+	if jobChan == nil {
+		return SubmitClosed
+	}
+	//Hold the read lock until the job is sent, jobChan can't be closed in the meantime
+	//持有读锁直到发送完成，期间发消息任务队列不会被关闭
+	handler.jobLock.RLock()
+	defer handler.jobLock.RUnlock()
+	if handler.jobClosed {
+		return SubmitClosed
+	}
+	policy := handler.app.backpressurePolicy(Outbound)
+	if options != nil {
+		policy = options.Policy
+	}
+	if policy == PolicyCoalesce {
+		return handler.enqueueCoalesced(jobChan, job, options)
+	}
+	select {
+	case jobChan <- job:
+		return SubmitQueued
+	default:
+	}
+	switch policy {
+	case PolicyBlock:
+		timer := time.NewTimer(handler.app.backpressureTimeout(options))
+		defer timer.Stop()
+		select {
+		case jobChan <- job:
+			return SubmitQueued
+		case <-handler.ctx.Done():
+			handler.reportDrop(Outbound, policy, DropReasonClosed, job.Message)
+			return SubmitClosed
+		case <-timer.C:
+			handler.reportDrop(Outbound, policy, DropReasonTimeout, job.Message)
+		}
+	case PolicyDropOldest:
+		//The writing thread may take the jobs at the same time, so try until there is room
+		//写线程可能同时取出任务，所以重试直到有空位
+		for {
+			select {
+			case jobChan <- job:
+				return SubmitQueued
+			default:
+			}
+			select {
+			case oldJob := <-jobChan:
+				handler.discardJob(oldJob, policy, DropReasonEvicted)
+			default:
+			}
+		}
+	case PolicyDisconnect:
+		handler.disconnectSlowConsumer(Outbound, job.Message)
+	default:
+		handler.reportDrop(Outbound, policy, DropReasonQueueFull, job.Message)
+	}
+	return SubmitDropped
+}
+
+// Replace the queued message with the same key, or queue the job with a new slot
+// The receipt of the message replaced fails with ErrMessageCoalesced, and the slot takes the new one
+// 替换队列中键相同的消息，或者使用新的slot加入队列，被替换消息的回执以ErrMessageCoalesced失败
+func (handler *MessageHandler) enqueueCoalesced(jobChan chan Job, job Job, options *SubmitOptions) SubmitStatus {
+	key := coalesceKey(job.Message, options)
+	if key == "" {
+		select {
+		case jobChan <- job:
+			return SubmitQueued
+		default:
+			handler.reportDrop(Outbound, PolicyCoalesce, DropReasonQueueFull, job.Message)
+			return SubmitDropped
+		}
+	}
+	handler.coalesceLock.Lock()
+	if slot, ok := handler.coalesceSlots[key]; ok {
+		oldMessage, oldReceipt := slot.message, slot.receipt
+		slot.message, slot.receipt = job.Message, job.Receipt
+		handler.coalesceLock.Unlock()
+		if oldReceipt != nil {
+			oldReceipt.resolve(ErrMessageCoalesced)
+		}
+		handler.reportDrop(Outbound, PolicyCoalesce, DropReasonCoalesced, oldMessage)
+		return SubmitCoalesced
+	}
+	//The slot owns the receipt since the message may be replaced
+	//消息可能被替换，回执由slot持有
+	job.slot = &coalesceSlot{
+		key:     key,
+		message: job.Message,
+		receipt: job.Receipt,
+	}
+	job.Receipt = nil
+	select {
+	case jobChan <- job:
+		if handler.coalesceSlots == nil {
+			handler.coalesceSlots = make(map[string]*coalesceSlot)
+		}
+		handler.coalesceSlots[key] = job.slot
+		handler.coalesceLock.Unlock()
+		return SubmitQueued
+	default:
+		handler.coalesceLock.Unlock()
+		handler.reportDrop(Outbound, PolicyCoalesce, DropReasonQueueFull, job.Message)
+		return SubmitDropped
+	}
+}
+
+// The key of PolicyCoalesce, SubmitOptions.Key or the type of the push, empty if the message can't be coalesced
+// PolicyCoalesce的键，取SubmitOptions.Key或推送的类型，为空表示不能合并
+func coalesceKey(message packet.IMessage, options *SubmitOptions) string {
+	if options != nil && options.Key != "" {
+		return options.Key
+	}
+	if prepared, ok := message.(*packet.PreparedMessage); ok {
+		message = prepared.Message()
+	}
+	if sendReq, ok := message.(*packet.SendReq); ok {
+		return sendReq.Type
+	}
+	return ""
+}
+
+// Get the message and the receipt of the job taken from jobChan, the latest ones if it's coalesced
+// 获取从发出队列取出的任务的消息和回执，合并过的任务取最新的
+func (handler *MessageHandler) takeJob(job Job) (packet.IMessage, *Receipt) {
+	if job.slot == nil {
+		return job.Message, job.Receipt
+	}
+	handler.coalesceLock.Lock()
+	defer handler.coalesceLock.Unlock()
+	if handler.coalesceSlots[job.slot.key] == job.slot {
+		delete(handler.coalesceSlots, job.slot.key)
+	}
+	return job.slot.message, job.slot.receipt
+}
+
+// Drop the job taken from jobChan
+// 丢弃从发出队列取出的任务
+func (handler *MessageHandler) discardJob(job Job, policy BackpressurePolicy, reason string) {
+	message, receipt := handler.takeJob(job)
+	//Don't block the one waiting for the receipt
+	//不阻塞等待回执的调用者
+	if receipt != nil {
+		receipt.resolve(ErrMessageDropped)
+	}
+	handler.reportDrop(Outbound, policy, reason, message)
+}
+
+// Queue the message from the client into workChan by the inbound policy, returns false if the connection should be closed
+// 按接收策略将客户端消息加入处理队列，返回false时应断开连接
+func (handler *MessageHandler) enqueueWork(msg packet.IMessage) bool {
+	workChan := handler.workChan
+	if workChan == nil || handler.ctx.Err() != nil {
+		return false
+	}
+	select {
+	case workChan <- msg:
+		return true
+	default:
+	}
+	policy := handler.app.backpressurePolicy(Inbound)
+	switch policy {
+	case PolicyBlock:
+		//Stop reading from the connection, so that the client slows down
+		//暂停读取连接，让客户端放慢速度
+		timer := time.NewTimer(handler.app.backpressureTimeout(nil))
+		defer timer.Stop()
+		select {
+		case workChan <- msg:
+		case <-handler.ctx.Done():
+			handler.reportDrop(Inbound, policy, DropReasonClosed, msg)
+		case <-timer.C:
+			handler.reportDrop(Inbound, policy, DropReasonTimeout, msg)
+		}
+	case PolicyDropOldest:
+		for {
+			select {
+			case workChan <- msg:
+				return true
+			default:
+			}
+			select {
+			case oldMsg := <-workChan:
+				handler.reportDrop(Inbound, policy, DropReasonEvicted, oldMsg)
+			default:
+			}
+		}
+	case PolicyDisconnect:
+		handler.reportDrop(Inbound, policy, DropReasonSlowConsumer, msg)
+		return false
+	default:
+		handler.reportDrop(Inbound, policy, DropReasonQueueFull, msg)
+	}
+	return true
+}
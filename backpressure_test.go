@@ -0,0 +1,164 @@
+package gosocket_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	gosocket "github.com/yankawayu/go-socket"
+	"github.com/yankawayu/go-socket/gosockettest"
+	"github.com/yankawayu/go-socket/packet"
+)
+
+// Connect as the user without ever reading, then push a message that blocks the writer of the server,
+// so that the later pushes stay in the outbound queue. The receipt of the blocking push is returned
+func connectStalled(t *testing.T, server *gosockettest.Server, uid int64) (net.Conn, *gosocket.MessageHandler, *gosocket.Receipt) {
+	conn, err := server.Listener.Dial(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgManager := &packet.MessageManager{
+		ProCommon: packet.ProtocolCommon{
+			ProName:       packet.ProtocolName,
+			ProVersion:    packet.ProtocolVersion,
+			KeepAliveTime: 60,
+		},
+	}
+	if err := msgManager.EncodeMessage(conn, &packet.Connect{Payload: gosockettest.ConnectInfo(uid)}); err != nil {
+		t.Fatal(err)
+	}
+	ack, err := msgManager.DecodeMessage(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if connAck, ok := ack.(*packet.ConnAck); !ok || connAck.ReturnCode != packet.RetCodeAccepted {
+		t.Fatalf("connect failed: %#v", ack)
+	}
+	handler := server.App.ClientPool().GetClientByUid(uid)
+	if handler == nil {
+		t.Fatal("user not in the pool after connecting")
+	}
+	_, receipt := handler.PushNotify("test.Block", "blocks the writer")
+	//Give the writer the time to take the push and block on the pipe
+	time.Sleep(50 * time.Millisecond)
+	return conn, handler, receipt
+}
+
+func TestDropNewestReportsDrops(t *testing.T) {
+	var lock sync.Mutex
+	var events []*gosocket.DropEvent
+	server := gosockettest.NewAppServerWithConfig(gosocket.NewApp(), nil, &gosocket.AppConfig{
+		QueueLength: 2,
+		OnDrop: func(event *gosocket.DropEvent) {
+			lock.Lock()
+			events = append(events, event)
+			lock.Unlock()
+		},
+	})
+	defer server.Close()
+	conn, handler, _ := connectStalled(t, server, 1)
+	defer conn.Close()
+	var statuses []gosocket.SubmitStatus
+	for i := 0; i < 5; i++ {
+		status, receipt := handler.PushNotify("test.Push", i)
+		if (status == gosocket.SubmitDropped) != (receipt == nil) {
+			t.Fatalf("status %v with receipt %v", status, receipt)
+		}
+		statuses = append(statuses, status)
+	}
+	want := []gosocket.SubmitStatus{gosocket.SubmitQueued, gosocket.SubmitQueued, gosocket.SubmitDropped, gosocket.SubmitDropped, gosocket.SubmitDropped}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Fatalf("statuses %v, want %v", statuses, want)
+		}
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(events) != 3 {
+		t.Fatalf("%d drop events, want 3", len(events))
+	}
+	for _, event := range events {
+		if event.Direction != gosocket.Outbound || event.Policy != gosocket.PolicyDropNewest ||
+			event.Reason != gosocket.DropReasonQueueFull || event.Uid != 1 || event.ConnId != handler.ConnId() {
+			t.Fatalf("drop event %+v", event)
+		}
+	}
+	if dropped := server.App.BackpressureStats().Outbound.Dropped; dropped != 3 {
+		t.Fatalf("%d dropped in the stats, want 3", dropped)
+	}
+}
+
+func TestCoalesceReplacesQueuedPush(t *testing.T) {
+	server := gosockettest.NewAppServerWithConfig(gosocket.NewApp(), nil, &gosocket.AppConfig{QueueLength: 2})
+	defer server.Close()
+	conn, handler, _ := connectStalled(t, server, 1)
+	defer conn.Close()
+	options := &gosocket.SubmitOptions{Policy: gosocket.PolicyCoalesce, Key: "badge"}
+	first, firstReceipt := handler.PushNotifyWithOptions("test.Badge", 1, options)
+	second, _ := handler.PushNotifyWithOptions("test.Badge", 2, options)
+	if first != gosocket.SubmitQueued || second != gosocket.SubmitCoalesced {
+		t.Fatalf("statuses %v and %v, want queued and coalesced", first, second)
+	}
+	if err := firstReceipt.WaitTimeout(time.Second); err != gosocket.ErrMessageCoalesced {
+		t.Fatalf("receipt of the replaced push: %v", err)
+	}
+	if coalesced := server.App.BackpressureStats().Outbound.Coalesced; coalesced != 1 {
+		t.Fatalf("%d coalesced in the stats, want 1", coalesced)
+	}
+}
+
+func TestDisconnectSlowConsumer(t *testing.T) {
+	server := gosockettest.NewAppServerWithConfig(gosocket.NewApp(), nil, &gosocket.AppConfig{
+		QueueLength:    1,
+		OutboundPolicy: gosocket.PolicyDisconnect,
+	})
+	defer server.Close()
+	conn, handler, _ := connectStalled(t, server, 1)
+	defer conn.Close()
+	handler.PushNotify("test.Push", 1)
+	if status, _ := handler.PushNotify("test.Push", 2); status != gosocket.SubmitDropped {
+		t.Fatalf("status %v, want dropped", status)
+	}
+	deadline := time.Now().Add(time.Second)
+	for server.App.ClientPool().GetClientByUid(1) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the slow consumer is still online")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if disconnected := server.App.BackpressureStats().Outbound.Disconnected; disconnected != 1 {
+		t.Fatalf("%d disconnected in the stats, want 1", disconnected)
+	}
+}
+
+func TestPushRacingDisconnect(t *testing.T) {
+	server := gosockettest.NewAppServer(gosocket.NewApp(), nil)
+	defer server.Close()
+	for i := 0; i < 10; i++ {
+		client, err := server.Connect(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler := server.App.ClientPool().GetClientByUid(1)
+		if handler == nil {
+			t.Fatal("user not in the pool after connecting")
+		}
+		//Push from other goroutines until the connection is closed, sending to the closed queue panics
+		var group sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			group.Add(1)
+			go func() {
+				defer group.Done()
+				for {
+					if status, _ := handler.PushNotify("test.Push", 1); status == gosocket.SubmitClosed {
+						return
+					}
+				}
+			}()
+		}
+		client.Disconnect()
+		group.Wait()
+	}
+}
@@ -0,0 +1,64 @@
+package gosocket
+
+import (
+	"bufio"
+	"io"
+)
+
+// The default size of the buffers of a connection
+// 连接缓冲区的默认大小
+const kDefaultBufferSize = 4 << 10
+
+func (app *App) readBufferSize() int {
+	if app.Config == nil || app.Config.ReadBufferSize <= 0 {
+		return kDefaultBufferSize
+	}
+	return app.Config.ReadBufferSize
+}
+
+func (app *App) writeBufferSize() int {
+	if app.Config == nil || app.Config.WriteBufferSize <= 0 {
+		return kDefaultBufferSize
+	}
+	return app.Config.WriteBufferSize
+}
+
+// Get a buffered reader from the pool, so that decoding the small fields doesn't read the connection each time
+// 从池中获取带缓冲的reader，解码较小的字段时无需每次都读取连接
+func (app *App) getBufferedReader(reader io.Reader) *bufio.Reader {
+	size := app.readBufferSize()
+	//The ones of another size are dropped in case the config has changed
+	//配置可能已改变，丢弃大小不同的reader
+	if bufReader, ok := app.readerPool.Get().(*bufio.Reader); ok && bufReader.Size() == size {
+		bufReader.Reset(reader)
+		return bufReader
+	}
+	return bufio.NewReaderSize(reader, size)
+}
+
+// Put the reader back to the pool once the connection is closed
+// 连接关闭后将reader放回池中
+func (app *App) putBufferedReader(bufReader *bufio.Reader) {
+	//Release the connection
+	//释放连接
+	bufReader.Reset(nil)
+	app.readerPool.Put(bufReader)
+}
+
+// Get a buffered writer from the pool, the messages written into it are sent by Flush together
+// 从池中获取带缓冲的writer，写入的消息在Flush时一起发送
+func (app *App) getBufferedWriter(writer io.Writer) *bufio.Writer {
+	size := app.writeBufferSize()
+	if bufWriter, ok := app.writerPool.Get().(*bufio.Writer); ok && bufWriter.Size() == size {
+		bufWriter.Reset(writer)
+		return bufWriter
+	}
+	return bufio.NewWriterSize(writer, size)
+}
+
+// Put the writer back to the pool once the connection is closed, the unsent data is dropped
+// 连接关闭后将writer放回池中，未发送的数据被丢弃
+func (app *App) putBufferedWriter(bufWriter *bufio.Writer) {
+	bufWriter.Reset(nil)
+	app.writerPool.Put(bufWriter)
+}
@@ -0,0 +1,307 @@
+package gosocket
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yankawayu/go-socket/packet"
+	"go.uber.org/zap"
+)
+
+// bufferUser accepts the uid as the connect info
+type bufferUser struct {
+	AuthUser
+}
+
+func (user *bufferUser) Auth(payload string, ip string) (uid int64, code packet.ReturnCode) {
+	uid, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil || uid <= 0 {
+		return -1, packet.RetCodeBadLoginInfo
+	}
+	return uid, packet.RetCodeAccepted
+}
+
+type bufferController struct {
+	Controller
+}
+
+type bufferParam struct {
+	Text string `json:"text"`
+}
+
+func (controller *bufferController) GetActionParamMap() map[string]interface{} {
+	return map[string]interface{}{
+		"Echo": &bufferParam{},
+	}
+}
+
+func (controller *bufferController) Echo(param *bufferParam, response *ResponseBody) {
+	response.Status = StatusSuccess
+	response.Data = param.Text
+}
+
+// pipeListener accepts the connections created by dial, each of them is a net.Pipe
+// The writes of a pipe are never merged, so every read of the client returns the bytes of one write of the server
+type pipeListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (listener *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.conns:
+		return conn, nil
+	case <-listener.closed:
+		return nil, errors.New("use of closed network connection")
+	}
+}
+
+func (listener *pipeListener) Close() error {
+	listener.closeOnce.Do(func() {
+		close(listener.closed)
+	})
+	return nil
+}
+
+func (listener *pipeListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: "pipe", Net: "pipe"}
+}
+
+// Serve an app on a pipeListener, the server stops once the test and its connections finish
+func newBufferServer(t *testing.T, config *AppConfig) (*App, *pipeListener) {
+	app := NewApp()
+	app.SetAuthUser(&bufferUser{})
+	app.Router("buffer", &bufferController{})
+	listener := &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+	served := make(chan error, 1)
+	go func() {
+		served <- app.Serve(listener, config, &Log{sugarLogger: zap.NewNop().Sugar()}, &FastLog{logger: zap.NewNop()})
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+		select {
+		case err := <-served:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("the server didn't stop")
+		}
+	})
+	return app, listener
+}
+
+// Dial the server and write the frames, the client side of the pipe is returned
+func (listener *pipeListener) dial(t *testing.T, manager *packet.MessageManager, messages ...packet.IMessage) net.Conn {
+	serverConn, clientConn := net.Pipe()
+	listener.conns <- serverConn
+	buf := &bytes.Buffer{}
+	for _, msg := range messages {
+		if err := manager.EncodeMessage(buf, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//The pipe blocks the writes until they are read, so write in the background
+	go func() {
+		_, _ = clientConn.Write(buf.Bytes())
+	}()
+	return clientConn
+}
+
+func newBufferManager() *packet.MessageManager {
+	return &packet.MessageManager{ProCommon: packet.ProtocolCommon{
+		ProName:       packet.ProtocolName,
+		ProVersion:    packet.ProtocolVersion,
+		KeepAliveTime: 60,
+	}}
+}
+
+func readMessage(t *testing.T, manager *packet.MessageManager, reader *bufio.Reader, conn net.Conn) packet.IMessage {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg, err := manager.DecodeMessage(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestBufferedReaderPool(t *testing.T) {
+	app := NewApp()
+	first := app.getBufferedReader(strings.NewReader("abcdef"))
+	if first.Size() != kDefaultBufferSize {
+		t.Fatalf("size %d without config", first.Size())
+	}
+	if b, _ := first.ReadByte(); b != 'a' {
+		t.Fatalf("read %q", b)
+	}
+	app.putBufferedReader(first)
+	//The pool may drop what's put, especially with the race detector, so the reuse is tried a few times
+	released, reused := first, false
+	for i := 0; i < 100 && !reused; i++ {
+		reader := app.getBufferedReader(strings.NewReader("xyz"))
+		reused = reader == released
+		//The bytes buffered from the former connection are never read again
+		if data, err := ioutil.ReadAll(reader); err != nil || string(data) != "xyz" {
+			t.Fatalf("read %q, %v from the pooled reader", data, err)
+		}
+		app.putBufferedReader(reader)
+		released = reader
+	}
+	if !reused {
+		t.Fatal("the reader isn't reused")
+	}
+	//The readers of the old size are dropped once the config changes
+	app.Config = &AppConfig{ReadBufferSize: 1024}
+	if reader := app.getBufferedReader(strings.NewReader("")); reader.Size() != 1024 {
+		t.Fatalf("size %d with the config", reader.Size())
+	}
+}
+
+func TestBufferedWriterPool(t *testing.T) {
+	app := NewApp()
+	firstConn := &bytes.Buffer{}
+	first := app.getBufferedWriter(firstConn)
+	if first.Size() != kDefaultBufferSize {
+		t.Fatalf("size %d without config", first.Size())
+	}
+	_, _ = first.WriteString("unsent")
+	app.putBufferedWriter(first)
+	released, reused := first, false
+	for i := 0; i < 100 && !reused; i++ {
+		conn := &bytes.Buffer{}
+		writer := app.getBufferedWriter(conn)
+		reused = writer == released
+		//The data left in the former writer is never sent to this connection
+		_, _ = writer.WriteString("x")
+		if err := writer.Flush(); err != nil || conn.String() != "x" {
+			t.Fatalf("flushed %q, %v by the pooled writer", conn.String(), err)
+		}
+		app.putBufferedWriter(writer)
+		released = writer
+	}
+	if !reused {
+		t.Fatal("the writer isn't reused")
+	}
+	//The data left in the released writer is dropped rather than sent to the former connection
+	if firstConn.Len() != 0 {
+		t.Fatalf("%q sent to the former connection", firstConn.String())
+	}
+	app.Config = &AppConfig{WriteBufferSize: 1024}
+	if writer := app.getBufferedWriter(&bytes.Buffer{}); writer.Size() != 1024 {
+		t.Fatalf("size %d with the config", writer.Size())
+	}
+}
+
+func TestPacketsFlushedInOneWrite(t *testing.T) {
+	app, listener := newBufferServer(t, &AppConfig{})
+	manager := newBufferManager()
+	conn := listener.dial(t, manager, &packet.Connect{Payload: "1"})
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	if ack, ok := readMessage(t, manager, reader, conn).(*packet.ConnAck); !ok || ack.ReturnCode != packet.RetCodeAccepted {
+		t.Fatalf("connect failed: %#v", ack)
+	}
+	//The first push blocks the writer until it's read, the others wait in the queue meanwhile
+	handler := app.ClientPool().GetClientByUid(1)
+	const pushes = 5
+	for i := 0; i < pushes; i++ {
+		if status, _ := handler.PushNotify("buffer.Push", i); status != SubmitQueued {
+			t.Fatalf("push status %v", status)
+		}
+	}
+	buf := make([]byte, 64*1024)
+	frames, writes := 0, 0
+	for frames < pushes {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writes++
+		for data := buf[:n]; len(data) > 0; frames++ {
+			length, err := packet.FrameLength(data)
+			if err != nil || length == 0 {
+				t.Fatalf("write %d ends with a partial frame: %v", writes, err)
+			}
+			data = data[length:]
+		}
+	}
+	if frames != pushes || writes > 2 {
+		t.Fatalf("%d frames sent by %d writes", frames, writes)
+	}
+}
+
+func TestFrameAcrossBufferBoundaries(t *testing.T) {
+	//The smallest buffer of bufio, so that the headers and the payloads are cut across its boundaries
+	_, listener := newBufferServer(t, &AppConfig{ReadBufferSize: 16, WriteBufferSize: 16})
+	manager := newBufferManager()
+	text := strings.Repeat("x", 200)
+	conn := listener.dial(t, manager,
+		&packet.Connect{Payload: "1"},
+		&packet.SendReq{ReplyLevel: packet.RLevelReplyLater, MessageId: 1, Type: "buffer.Echo", Payload: JSONEncode(&bufferParam{Text: "a"})},
+		&packet.SendReq{ReplyLevel: packet.RLevelReplyLater, MessageId: 2, Type: "buffer.Echo", Payload: JSONEncode(&bufferParam{Text: text})},
+	)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	if ack, ok := readMessage(t, manager, reader, conn).(*packet.ConnAck); !ok || ack.ReturnCode != packet.RetCodeAccepted {
+		t.Fatalf("connect failed: %#v", ack)
+	}
+	for i, want := range []string{"a", text} {
+		resp, ok := readMessage(t, manager, reader, conn).(*packet.SendResp)
+		if !ok || resp.MessageId != uint16(i+1) || !strings.Contains(resp.Payload, `"data":"`+want+`"`) {
+			t.Fatalf("response %#v", resp)
+		}
+	}
+}
+
+func TestBuffersReleasedOnClose(t *testing.T) {
+	//The sizes tell the buffers of the connections apart
+	app, listener := newBufferServer(t, &AppConfig{ReadBufferSize: 1024, WriteBufferSize: 2048})
+	manager := newBufferManager()
+	//The connections come and go at the same time, the race detector catches a buffer used after it's released
+	var group sync.WaitGroup
+	for i := 1; i <= 8; i++ {
+		group.Add(1)
+		go func(uid int) {
+			defer group.Done()
+			conn := listener.dial(t, manager, &packet.Connect{Payload: strconv.Itoa(uid)})
+			reader := bufio.NewReader(conn)
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if _, err := manager.DecodeMessage(reader); err != nil {
+				t.Error(err)
+			}
+			_ = conn.Close()
+		}(i)
+	}
+	group.Wait()
+	deadline := time.Now().Add(5 * time.Second)
+	for app.ClientPool().Count() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the connections are still open")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	readerReleased, writerReleased := false, false
+	for !readerReleased || !writerReleased {
+		if time.Now().After(deadline) {
+			t.Fatalf("reader released %v, writer released %v", readerReleased, writerReleased)
+		}
+		if reader, ok := app.readerPool.Get().(*bufio.Reader); ok {
+			readerReleased = readerReleased || reader.Size() == 1024 && reader.Buffered() == 0
+		}
+		if writer, ok := app.writerPool.Get().(*bufio.Writer); ok {
+			writerReleased = writerReleased || writer.Size() == 2048 && writer.Buffered() == 0
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,324 @@
+package gosocket
+
+import (
+	"encoding"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema is the JSON Schema of a type, only the keywords used by the catalog are included
+// JSON Schema，仅包含目录用到的关键字
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+}
+
+// CatalogRoute is a route in the catalog
+// 目录中的路由
+type CatalogRoute struct {
+	Type       string   `json:"type"`               // The payload type in the format of `controller.action`
+	Controller string   `json:"controller"`         // The controller name in lower case
+	Action     string   `json:"action"`             // The action name
+	Roles      []string `json:"roles,omitempty"`    // The roles required
+	Params     *Schema  `json:"params"`             // The schema of the payload
+	Response   *Schema  `json:"response,omitempty"` // The schema of the response data, only if it's declared
+}
+
+// Catalog is the machine-readable API catalog of the app
+// The named struct types are put into Definitions and referenced by `#/definitions/Name`
+// API目录，具名结构体放在Definitions中通过`#/definitions/Name`引用
+type Catalog struct {
+	Routes      []*CatalogRoute    `json:"routes"`
+	Definitions map[string]*Schema `json:"definitions,omitempty"`
+}
+
+// Catalog generate the API catalog from the routes of the app
+// The rules in the `validate` tags are converted to the keywords of JSON Schema
+// 根据路由生成API目录，validate tag中的规则会转换为JSON Schema的关键字
+func (app *App) Catalog() *Catalog {
+	builder := &schemaBuilder{
+		definitions: make(map[string]*Schema),
+		names:       make(map[reflect.Type]string),
+	}
+	catalog := &Catalog{
+		Routes: make([]*CatalogRoute, 0),
+	}
+	for _, route := range app.Routes() {
+		catalogRoute := &CatalogRoute{
+			Type:       route.Type,
+			Controller: route.Controller,
+			Action:     route.Action,
+			Roles:      route.Roles,
+			Params:     builder.build(route.ParamType),
+		}
+		if route.ResponseType != nil {
+			catalogRoute.Response = builder.build(route.ResponseType)
+		}
+		catalog.Routes = append(catalog.Routes, catalogRoute)
+	}
+	if len(builder.definitions) > 0 {
+		catalog.Definitions = builder.definitions
+	}
+	return catalog
+}
+
+// WriteFile write the catalog into the file as indented json, the path `-` means stdout
+// 将目录以json格式写入文件，`-`表示标准输出
+func (catalog *Catalog) WriteFile(path string) error {
+	content, err := json.MarshalIndent(catalog, "", "  ")
+	if err != nil {
+		return err
+	}
+	content = append(content, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(content)
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+// RunCatalogCommand write the catalog if the args are `catalog [-o path]`, the default path is catalog.json
+// Call it in main after the routes are registered, so that the server binary can export its own catalog:
+//
+//	if ok, err := gosocket.TcpApp.RunCatalogCommand(os.Args[1:]); ok {
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//		return
+//	}
+//
+// It returns false if the args are not the catalog command
+// 如果参数是`catalog [-o path]`则写入目录，不是目录命令时返回false
+func (app *App) RunCatalogCommand(args []string) (ok bool, err error) {
+	if len(args) == 0 || args[0] != "catalog" {
+		return false, nil
+	}
+	flagSet := flag.NewFlagSet("catalog", flag.ContinueOnError)
+	path := flagSet.String("o", "catalog.json", "the file to write the catalog into, - means stdout")
+	if err = flagSet.Parse(args[1:]); err != nil {
+		return true, err
+	}
+	return true, app.Catalog().WriteFile(*path)
+}
+
+// schemaBuilder builds the schemas and collects the definitions of the named struct types
+// 生成Schema并收集具名结构体的定义
+type schemaBuilder struct {
+	definitions map[string]*Schema
+	names       map[reflect.Type]string
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func (builder *schemaBuilder) build(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == rawMessageType:
+		return &Schema{}
+	case t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType):
+		//The format is unknown
+		//格式未知
+		return &Schema{}
+	case t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType):
+		return &Schema{Type: "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		//[]byte is encoded as base64 string
+		//[]byte编码为base64字符串
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: builder.build(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: builder.build(t.Elem())}
+	case reflect.Struct:
+		//Anonymous structs are inlined
+		//匿名结构体直接展开
+		if t.Name() == "" {
+			return builder.buildStruct(t)
+		}
+		name, ok := builder.names[t]
+		if !ok {
+			name = builder.definitionName(t)
+			builder.names[t] = name
+			//Put a placeholder first, in case the struct refers to itself
+			//先占位，避免结构体引用自身时死循环
+			builder.definitions[name] = &Schema{}
+			builder.definitions[name] = builder.buildStruct(t)
+		}
+		return &Schema{Ref: "#/definitions/" + name}
+	}
+	//interface{} and the others can be anything
+	//interface{}等可以是任意类型
+	return &Schema{}
+}
+
+// The name of the definition is the type name, the package path is added if there is a conflict
+// 定义名为类型名，冲突时加上包路径
+func (builder *schemaBuilder) definitionName(t reflect.Type) string {
+	name := t.Name()
+	if _, ok := builder.definitions[name]; ok {
+		name = strings.NewReplacer("/", ".", "[", "_", "]", "_").Replace(t.PkgPath() + "." + t.Name())
+	}
+	return name
+}
+
+func (builder *schemaBuilder) buildStruct(t reflect.Type) *Schema {
+	schema := &Schema{
+		Type:       "object",
+		Properties: make(map[string]*Schema),
+	}
+	builder.addFields(schema, t)
+	return schema
+}
+
+// Add the fields the same way as encoding/json, the embedded structs are flattened
+// 与encoding/json相同的方式添加字段，匿名嵌入的结构体会被展开
+func (builder *schemaBuilder) addFields(schema *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		jsonTag := field.Tag.Get("json")
+		if jsonTag == "-" {
+			continue
+		}
+		jsonOptions := strings.Split(jsonTag, ",")
+		name := jsonOptions[0]
+		if field.Anonymous && name == "" {
+			embeddedType := field.Type
+			if embeddedType.Kind() == reflect.Ptr {
+				embeddedType = embeddedType.Elem()
+			}
+			if embeddedType.Kind() == reflect.Struct {
+				builder.addFields(schema, embeddedType)
+				continue
+			}
+		}
+		//The unexported embedded structs with a json name are still encoded
+		//带json名的非导出嵌入结构体仍会被编码
+		if field.PkgPath != "" && !(field.Anonymous && indirectType(field.Type).Kind() == reflect.Struct) {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		var fieldSchema *Schema
+		if hasJSONOption(jsonOptions[1:], "string") {
+			fieldSchema = &Schema{Type: "string"}
+		} else {
+			fieldSchema = builder.build(field.Type)
+		}
+		if applyValidateRules(fieldSchema, parseValidateRules(field.Tag.Get(kValidateTag))) {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = fieldSchema
+	}
+}
+
+func hasJSONOption(options []string, option string) bool {
+	for _, o := range options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// Convert the validate rules into the keywords, returns whether the field is required
+// A schema with $ref can't have other keywords, so only required is applied to it
+// 将校验规则转换为关键字，返回是否必填，$ref的Schema只处理required
+func applyValidateRules(schema *Schema, rules []validateRule) (required bool) {
+	for _, rule := range rules {
+		if rule.name == "required" {
+			required = true
+			continue
+		}
+		if schema.Ref != "" {
+			continue
+		}
+		limit := rule.limit
+		switch rule.name {
+		case "min", "max", "len":
+			if math.IsNaN(limit) {
+				continue
+			}
+			switch schema.Type {
+			case "string":
+				if rule.name != "max" {
+					schema.MinLength = intPtr(int(limit))
+				}
+				if rule.name != "min" {
+					schema.MaxLength = intPtr(int(limit))
+				}
+			case "array":
+				if rule.name != "max" {
+					schema.MinItems = intPtr(int(limit))
+				}
+				if rule.name != "min" {
+					schema.MaxItems = intPtr(int(limit))
+				}
+			case "integer", "number":
+				if rule.name != "max" {
+					schema.Minimum = &limit
+				}
+				if rule.name != "min" {
+					schema.Maximum = &limit
+				}
+			}
+		case "oneof":
+			for _, option := range strings.Fields(rule.param) {
+				if schema.Type == "integer" || schema.Type == "number" {
+					if number, err := strconv.ParseFloat(option, 64); err == nil {
+						schema.Enum = append(schema.Enum, number)
+						continue
+					}
+				}
+				schema.Enum = append(schema.Enum, option)
+			}
+		case "email":
+			schema.Format = "email"
+		}
+	}
+	return
+}
+
+func intPtr(i int) *int {
+	return &i
+}
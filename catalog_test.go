@@ -0,0 +1,130 @@
+package gosocket_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gosocket "github.com/yankawayu/go-socket"
+)
+
+// Run `go test -run Golden -update` to rewrite the golden files after an intended change of the output
+var update = flag.Bool("update", false, "update the golden files")
+
+type catalogMeta struct {
+	Version int `json:"version"`
+}
+
+type catalogAuthor struct {
+	Id    int64  `json:"id,string"`
+	Name  string `json:"name" validate:"required,max=20"`
+	Email string `json:"email,omitempty" validate:"omitempty,email"`
+}
+
+type catalogPost struct {
+	catalogMeta
+	Title   string          `json:"title" validate:"required,min=1,max=100"`
+	Code    string          `json:"code" validate:"omitempty,len=6"`
+	Kind    string          `json:"kind" validate:"oneof=text image"`
+	Level   int             `json:"level" validate:"omitempty,oneof=1 2 3"`
+	Score   float64         `json:"score" validate:"min=0,max=5"`
+	Tags    []string        `json:"tags" validate:"max=5"`
+	Author  *catalogAuthor  `json:"author" validate:"required"`
+	Replies []catalogPost   `json:"replies"`
+	Attrs   map[string]int  `json:"attrs"`
+	Cover   []byte          `json:"cover"`
+	Created time.Time       `json:"created"`
+	Extra   json.RawMessage `json:"extra"`
+	Any     interface{}     `json:"any"`
+	Secret  string          `json:"-"`
+	hidden  string
+}
+
+type catalogPostResp struct {
+	Id int64 `json:"id"`
+}
+
+type catalogListParam struct {
+	Page   int `json:"page" validate:"min=1"`
+	Filter struct {
+		Kind string `json:"kind" validate:"omitempty,oneof=text image"`
+	} `json:"filter"`
+}
+
+type catalogController struct {
+	gosocket.Controller
+}
+
+func (controller *catalogController) GetActionParamMap() map[string]interface{} {
+	return map[string]interface{}{
+		"Post":   &catalogPost{},
+		"List":   &catalogListParam{},
+		"Delete": &catalogPostResp{},
+	}
+}
+
+func (controller *catalogController) GetActionRoleMap() map[string][]string {
+	return map[string][]string{
+		"Post":   {"admin", "editor"},
+		"Delete": {"admin"},
+	}
+}
+
+func (controller *catalogController) GetActionResponseMap() map[string]interface{} {
+	return map[string]interface{}{
+		"Post": &catalogPostResp{},
+		"List": &[]catalogPost{},
+	}
+}
+
+func (controller *catalogController) Post(param *catalogPost, response *gosocket.ResponseBody) {
+}
+
+func (controller *catalogController) List(param *catalogListParam, response *gosocket.ResponseBody) {
+}
+
+func (controller *catalogController) Delete(param *catalogPostResp, response *gosocket.ResponseBody) {
+}
+
+// Compare the output with the golden file, or rewrite the golden file with -update
+func checkGolden(t *testing.T, path string, output []byte) {
+	t.Helper()
+	if *update {
+		if err := ioutil.WriteFile(path, output, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	golden, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, golden) {
+		t.Fatalf("the output differs from %s, run with -update if the change is intended:\n%s", path, output)
+	}
+}
+
+func TestCatalogGolden(t *testing.T) {
+	app := gosocket.NewApp()
+	app.Router("catalog", &catalogController{})
+	app.Handle("Typed.Greet", gosocket.NewTypedHandler(typedGreet).WithRoles("user"))
+	dir, err := ioutil.TempDir("", "catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "catalog.json")
+	if err := app.Catalog().WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	output, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, filepath.Join("testdata", "catalog.golden.json"), output)
+}
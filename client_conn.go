@@ -5,29 +5,21 @@ import (
 	"github.com/yankawayu/go-socket/packet"
 	"io"
 	"net"
-	"strconv"
 	"strings"
 	"time"
 )
 
-// kQueueLength is used to set the max amount of jobs waiting to be sent.
+// kQueueLength is the default max amount of jobs waiting to be sent, see AppConfig.QueueLength
 // This limit is to avoid running out of memory in extreme cases
-// 任务队列的长度
+// 默认的任务队列长度
 const kQueueLength = 200
 
-// Receipt is used to get notified when the message is sent
-// 用于任务完成的通知
-type Receipt chan struct{}
-
-func (receipt Receipt) Wait() {
-	// TODO: timeout
-	<-receipt
-}
-
 // Job is used to store the message that is about to be sent out
 type Job struct {
 	Message packet.IMessage // Message is about to be sent
-	Receipt Receipt         // Receipt is used to get notified when the message is sent
+	Receipt *Receipt        // Receipt is used to get notified when the message is sent, nil if it's not needed
+
+	slot *coalesceSlot // Set if the message can be replaced by a later one with the same key, see PolicyCoalesce
 }
 
 // ClientConn is used to handle individual valid connection coming to the server
@@ -36,6 +28,7 @@ type Job struct {
 // Writing thread is used to send all the messages back as output data
 // Handling thread is used to process all the incoming messages from Reading thread and generate response messages
 type ClientConn struct {
+	app        *App //The app which the connection belongs to
 	conn       net.Conn
 	clientIp   string                 //Used to store the ip address of the client
 	jobChan    chan Job               //Used to store all the jobs that are about to be sent
@@ -43,10 +36,15 @@ type ClientConn struct {
 	msgManager *packet.MessageManager //Used to help encoding and decoding messages
 }
 
-func NewClientConn(conn net.Conn) (client *ClientConn) {
+// NewClientConn create a connection of the default TcpApp
+func NewClientConn(conn net.Conn) *ClientConn {
+	return TcpApp.newClientConn(conn)
+}
+
+func (app *App) newClientConn(conn net.Conn) (client *ClientConn) {
 	defer func() {
 		if err := recover(); err != nil {
-			TcpApp.Log.Error(err)
+			app.Log.Error(err)
 			client = nil
 		}
 	}()
@@ -57,14 +55,20 @@ func NewClientConn(conn net.Conn) (client *ClientConn) {
 	if len(ipAndPort) > 0 {
 		clientIp = ipAndPort[0]
 	}
-	jobChan := make(chan Job, kQueueLength)
+	jobChan := make(chan Job, app.queueLength())
 	client = &ClientConn{
+		app:        app,
 		conn:       conn,
 		clientIp:   clientIp,
 		jobChan:    jobChan,
-		handler:    NewMessageHandler(jobChan, clientIp),
+		handler:    app.newMessageHandler(jobChan, clientIp),
 		msgManager: &packet.MessageManager{},
 	}
+	//Closing the connection stops both the reading and the writing thread
+	//关闭连接会使读写线程都退出
+	client.handler.closeConn = func() {
+		_ = conn.Close()
+	}
 	return
 }
 
@@ -84,24 +88,60 @@ func (client *ClientConn) Start() {
 func (client *ClientConn) startWriter() {
 	defer func() {
 		if err := recover(); err != nil {
-			TcpApp.Log.Error(err)
+			client.app.Log.Error(err)
 		}
 	}()
+	//The reason why the messages left in the queue are not sent
+	//队列中剩余消息未发送的原因
+	closeErr := ErrConnClosed
 	//This is a new defer block.
 	//It's done on purpose to make sure that panic in this block is catchable by the first defer block
 	//新开一个defer，确保这个defer中有panic也能被捕捉到
 	defer func() {
 		client.conn.Close()
+		//Fail the receipts of the messages left until the handler stops and closes the queue
+		//Closing the connection stops the reading thread, which stops the handler
+		//让剩余消息的回执失败，直到handler停止并关闭队列，关闭连接会使读线程停止handler
+		for job := range client.jobChan {
+			if _, receipt := client.handler.takeJob(job); receipt != nil {
+				receipt.resolve(closeErr)
+			}
+		}
 	}()
+	writer := client.app.getBufferedWriter(client.conn)
+	defer client.app.putBufferedWriter(writer)
+	//The receipts of the messages in the buffer
+	//缓冲区中消息的回执
+	var receipts []*Receipt
 	for job := range client.jobChan {
-		err := client.msgManager.EncodeMessage(client.conn, job.Message)
-		//Notify the job is done (the message is sent)
-		//通知消息发送完成
-		if job.Receipt != nil {
-			close(job.Receipt)
+		isDisconnect, err := client.writeJob(writer, job, &receipts)
+		//Drain the pending jobs into the buffer before sending, so that they are sent by one write
+		//The amount is limited so that the receipts aren't delayed for too long
+		//发送前将等待中的任务都写入缓冲区，一次性发送，限制数量以免回执延迟过久
+	drain:
+		for i := 0; i < cap(client.jobChan) && err == nil && !isDisconnect; i++ {
+			select {
+			case job, ok := <-client.jobChan:
+				if !ok {
+					break drain
+				}
+				isDisconnect, err = client.writeJob(writer, job, &receipts)
+			default:
+				break drain
+			}
+		}
+		if err == nil {
+			err = writer.Flush()
+		}
+		//Notify the jobs are done (the messages are sent), or failed with the error
+		//通知消息发送完成，或者发送失败的错误
+		for _, receipt := range receipts {
+			receipt.resolve(err)
 		}
+		receipts = receipts[:0]
 
 		if err != nil {
+			closeErr = err
 			//Network error in tls connection
 			//tls中断连接的错误
 			if strings.HasSuffix(err.Error(), "use of closed connection") {
@@ -112,22 +152,34 @@ func (client *ClientConn) startWriter() {
 			if strings.HasSuffix(err.Error(), "use of closed network connection") {
 				return
 			}
-			TcpApp.Log.Error(err)
+			client.app.Log.Error(err)
 			return
 		}
 		//If the job just sent is Disconnect message, stop the Writing Thread immediately
 		//断开连接后确保马上返回
-		if _, ok := job.Message.(*packet.Disconnect); ok {
+		if isDisconnect {
 			return
 		}
 	}
 }
 
+// Write the message of the job into the buffer, returns whether it's a Disconnect message
+// 将任务的消息写入缓冲区，返回是否为Disconnect消息
+func (client *ClientConn) writeJob(writer io.Writer, job Job, receipts *[]*Receipt) (bool, error) {
+	message, receipt := client.handler.takeJob(job)
+	if receipt != nil {
+		*receipts = append(*receipts, receipt)
+	}
+	err := client.msgManager.EncodeMessage(writer, message)
+	_, isDisconnect := message.(*packet.Disconnect)
+	return isDisconnect, err
+}
+
 // 开启读线程
 func (client *ClientConn) startReader() {
 	defer func() {
 		if err := recover(); err != nil {
-			TcpApp.Log.Error(err)
+			client.app.Log.Error(err)
 		}
 	}()
 	//This is a new defer block.
@@ -141,18 +193,22 @@ func (client *ClientConn) startReader() {
 		//如果是被同一账号踢出，之前已经调用过stop，这次调用没什么作用
 		client.handler.Stop(false)
 	}()
+	//Decode from the buffer instead of reading the connection for each field
+	//从缓冲区解码，而不是每个字段都读取连接
+	reader := client.app.getBufferedReader(client.conn)
+	defer client.app.putBufferedReader(reader)
 	for {
-		//The read timeout should be 1.5 times bigger than the interval of the ping pong message
+		//The read timeout should be bigger than the interval of the ping pong message, see AppConfig.ReadTimeoutFactor
 		//To avoid the network being on and off
-		//超时时间，设置为心跳包间隔的1.5倍，避免复杂网络
-		timeoutInterval := time.Duration(float64(client.msgManager.ProCommon.KeepAliveTime) * 1.5)
+		//超时时间，默认为心跳包间隔的1.5倍，避免复杂网络
+		timeoutInterval := client.handler.app.readTimeout(client.msgManager.ProCommon.KeepAliveTime)
 		if timeoutInterval > 0 {
 			//If failed to set deadline, ignore it
-			_ = client.conn.SetReadDeadline(time.Now().Add(timeoutInterval * time.Second))
+			_ = client.conn.SetReadDeadline(time.Now().Add(timeoutInterval))
 		}
 		//Get the message
 		//获取消息
-		msg, err := client.msgManager.DecodeMessage(client.conn)
+		msg, err := client.msgManager.DecodeMessage(reader)
 		if err != nil {
 			if err == io.EOF {
 				//If the client close the connection from the other side
@@ -160,7 +216,7 @@ func (client *ClientConn) startReader() {
 				return
 			} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 				//If the connection is idle without any data including ping pong messages
-				TcpApp.Log.Debugf("user %d client conn timeout", client.handler.user.GetUid())
+				client.app.Log.Debugf("user %d client conn timeout", client.handler.user.GetUid())
 				return
 			}
 			//Network error in tls connection
@@ -196,16 +252,21 @@ func (client *ClientConn) startReader() {
 			//Log all error messages under debug environment
 			//如果是自己定义的消息错误，仅在Debug环境输出
 			if _, ok := err.(packet.MessageErr); ok {
-				TcpApp.Log.Debug(errors.Wrap(err, client.clientIp))
+				client.app.Log.Debug(errors.Wrap(err, client.clientIp))
 			} else {
-				TcpApp.Log.Error(errors.Wrap(err, client.clientIp))
+				client.app.Log.Error(errors.Wrap(err, client.clientIp))
 			}
 			return
 		}
-		select {
-		case client.handler.workChan <- msg:
-		default:
-			TcpApp.Log.Warning(strconv.FormatInt(client.handler.user.GetUid(), 10) + " fail to add message: " + JSONEncode(msg))
+		//The message is handled by InboundPolicy if the queue is full
+		//队列满时按InboundPolicy处理
+		if !client.handler.enqueueWork(msg) {
+			return
+		}
+		//The client won't send anything after Disconnect, stop reading and cancel the requests in process
+		//客户端断开后不会再发送消息，停止读取并取消正在处理的请求
+		if _, ok := msg.(*packet.Disconnect); ok {
+			return
 		}
 	}
 }
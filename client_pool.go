@@ -1,53 +1,331 @@
 package gosocket
 
-import "sync"
+import (
+	"sync"
 
-var clientPool *ClientPool
-var readWriteLock *sync.RWMutex
+	"github.com/yankawayu/go-socket/packet"
+)
+
+// The default number of the shards of ClientPool, see AppConfig.ClientPoolShards
+// 连接池默认的分片数
+const kDefaultClientPoolShards = 64
 
 // ClientPool is used to store all the connections on the server
-// It follows singleton pattern which means there should be only one instance
-// Please use GetClientPool to get the instance
+// Each App owns one instance, please use App.ClientPool or GetClientPool to get it
 //
-// Since the pool will be accessed by different threads, it is required to use sync.RWMutex to avoid conflict
+// The users are spread over the shards by their uids, each shard has its own sync.RWMutex,
+// so the logins, the logouts and the lookups of different shards don't wait for each other.
+// A user can have several sessions on different devices, see AppConfig.SessionPolicy
+// 用户按uid分布到各个分片，每个分片有自己的读写锁，不同分片的登入登出和查询互不等待
+// 一个用户可以在不同设备上有多个会话
 type ClientPool struct {
-	onlineClientMap map[int64]*MessageHandler
+	shards []*clientShard
+	shift  uint // 64 - log2(len(shards)), used to pick the shard by the high bits of the hash
 }
 
-func init() {
-	clientPool = &ClientPool{
-		onlineClientMap: make(map[int64]*MessageHandler),
-	}
-	readWriteLock = new(sync.RWMutex)
+// clientShard is a part of the users, it's allocated separately so that the locks aren't in the same cache line
+// 部分用户，单独分配以免不同分片的锁位于同一缓存行
+type clientShard struct {
+	lock    sync.RWMutex
+	clients map[int64][]*MessageHandler // The sessions of the users in the order of logging in
+
+	presenceLock sync.Mutex // keeps the presence updates of the users in order, see syncPresence
 }
 
-// GetClientPool is the only way to get the single ClientPool instance
-// 获取连接池单例
-func GetClientPool() *ClientPool {
+func newClientPool(shardCount int) *ClientPool {
+	//Round up to the power of 2
+	//向上取2的幂
+	bits := uint(0)
+	for 1<<bits < shardCount {
+		bits++
+	}
+	clientPool := &ClientPool{
+		shards: make([]*clientShard, 1<<bits),
+		shift:  64 - bits,
+	}
+	for i := range clientPool.shards {
+		clientPool.shards[i] = &clientShard{
+			clients: make(map[int64][]*MessageHandler),
+		}
+	}
 	return clientPool
 }
 
+// Pick the shard of the uid, the uid is hashed so that the uids with a pattern, e.g. even ones, are spread too
+// 选择uid所在的分片，uid经过哈希，使有规律的uid（如偶数）也能分散
+func (clientPool *ClientPool) shard(uid int64) *clientShard {
+	//Shifting by 64 gives 0 when there is only one shard
+	//只有一个分片时右移64位结果为0
+	return clientPool.shards[(uint64(uid)*0x9E3779B97F4A7C15)>>clientPool.shift]
+}
+
+// Change the number of the shards, it's only called before serving since the shards are read without lock
+// 修改分片数，分片列表的读取不加锁，所以只在开始服务前调用
+func (clientPool *ClientPool) reshard(shardCount int) {
+	resized := newClientPool(shardCount)
+	if len(resized.shards) == len(clientPool.shards) {
+		return
+	}
+	for _, shard := range clientPool.shards {
+		shard.lock.RLock()
+		for uid, sessions := range shard.clients {
+			resized.shard(uid).clients[uid] = sessions
+		}
+		shard.lock.RUnlock()
+	}
+	clientPool.shards, clientPool.shift = resized.shards, resized.shift
+}
+
 // SetClientByUid is used to put the handler into the map and mark the user is online
-// 将用户连接加入连接池中，标记用户在线
+// The handler replaces all the sessions of the user
+// 将用户连接加入连接池中，标记用户在线，替换该用户所有的会话
 func (clientPool *ClientPool) SetClientByUid(handler *MessageHandler, uid int64) {
-	readWriteLock.Lock()
-	clientPool.onlineClientMap[uid] = handler
-	readWriteLock.Unlock()
+	shard := clientPool.shard(uid)
+	shard.lock.Lock()
+	shard.clients[uid] = []*MessageHandler{handler}
+	shard.lock.Unlock()
 }
 
-// RemoveClientByUid is used to remove the corresponding handler and mark the user is offline
-// 将用户从连接池中删除，标记用户下线
+// RemoveClientByUid is used to remove all the sessions of the user and mark the user is offline
+// 将用户的所有会话从连接池中删除，标记用户下线
 func (clientPool *ClientPool) RemoveClientByUid(uid int64) {
-	readWriteLock.Lock()
-	delete(clientPool.onlineClientMap, uid)
-	readWriteLock.Unlock()
+	shard := clientPool.shard(uid)
+	shard.lock.Lock()
+	delete(shard.clients, uid)
+	shard.lock.Unlock()
 }
 
-// GetClientByUid is used to get the corresponding handler and check whether the user is online
-// 获取用户对应的连接
+// GetClientByUid is used to get the latest session of the user and check whether the user is online
+// 获取用户最新的会话
 func (clientPool *ClientPool) GetClientByUid(uid int64) *MessageHandler {
-	readWriteLock.RLock()
-	handler := clientPool.onlineClientMap[uid]
-	readWriteLock.RUnlock()
-	return handler
+	shard := clientPool.shard(uid)
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+	sessions := shard.clients[uid]
+	if len(sessions) == 0 {
+		return nil
+	}
+	return sessions[len(sessions)-1]
+}
+
+// GetClientsByUid is used to get all the sessions of the user in the order of logging in
+// 获取用户所有的会话，按登陆顺序排列
+func (clientPool *ClientPool) GetClientsByUid(uid int64) []*MessageHandler {
+	shard := clientPool.shard(uid)
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+	return append([]*MessageHandler(nil), shard.clients[uid]...)
+}
+
+// GetClientByDevice is used to get the session of the user on the device, see IDeviceUser
+// 获取用户在指定设备上的会话
+func (clientPool *ClientPool) GetClientByDevice(uid int64, deviceId string) *MessageHandler {
+	shard := clientPool.shard(uid)
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+	for _, session := range shard.clients[uid] {
+		if session.device.Id == deviceId {
+			return session
+		}
+	}
+	return nil
+}
+
+// Add the session of the user, returns the old sessions kicked out by it according to the policy
+// 加入用户的会话，返回按策略被踢出的旧会话
+func (clientPool *ClientPool) addSession(handler *MessageHandler, uid int64, policy SessionPolicy, maxSessions int) []*MessageHandler {
+	shard := clientPool.shard(uid)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	sessions := shard.clients[uid]
+	kicked := sessionsToKick(policy, maxSessions, sessions, handler.device)
+	remained := make([]*MessageHandler, 0, len(sessions)-len(kicked)+1)
+	for _, session := range sessions {
+		if !containsHandler(kicked, session) {
+			remained = append(remained, session)
+		}
+	}
+	shard.clients[uid] = append(remained, handler)
+	return kicked
+}
+
+// Remove the session of the user, the other sessions aren't affected, returns whether the user has other sessions
+// 删除用户的某个会话，不影响其他会话，返回用户是否还有其他会话
+func (clientPool *ClientPool) removeSession(handler *MessageHandler, uid int64) bool {
+	shard := clientPool.shard(uid)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	sessions := shard.clients[uid]
+	for i, session := range sessions {
+		if session != handler {
+			continue
+		}
+		if len(sessions) == 1 {
+			delete(shard.clients, uid)
+			return false
+		}
+		//Copy instead of modifying in place, the slices returned to the callers may share the array
+		//复制而不是原地修改，以免影响共享底层数组的切片
+		remained := make([]*MessageHandler, 0, len(sessions)-1)
+		remained = append(remained, sessions[:i]...)
+		shard.clients[uid] = append(remained, sessions[i+1:]...)
+		return true
+	}
+	return len(sessions) > 0
+}
+
+// Report whether the user is online by the update, the updates of the same user are called one by one in order,
+// so that the last one always reports the latest state
+// 通过update报告用户是否在线，同一用户的update逐个按顺序调用，最后一次总是最新状态
+func (clientPool *ClientPool) syncPresence(uid int64, update func(online bool)) {
+	shard := clientPool.shard(uid)
+	shard.presenceLock.Lock()
+	defer shard.presenceLock.Unlock()
+	shard.lock.RLock()
+	online := len(shard.clients[uid]) > 0
+	shard.lock.RUnlock()
+	update(online)
+}
+
+func containsHandler(handlers []*MessageHandler, handler *MessageHandler) bool {
+	for _, h := range handlers {
+		if h == handler {
+			return true
+		}
+	}
+	return false
+}
+
+// Count returns the number of the online users, a user with several sessions is counted once
+// 在线用户数，有多个会话的用户只计一次
+func (clientPool *ClientPool) Count() int {
+	count := 0
+	for _, shard := range clientPool.shards {
+		shard.lock.RLock()
+		count += len(shard.clients)
+		shard.lock.RUnlock()
+	}
+	return count
+}
+
+// PushResult is the outcome of pushing to a session of a user, see PushToUids
+// 推送给用户某个会话的结果
+type PushResult struct {
+	Uid     int64
+	Node    string // The node of the session, empty unless it's returned by App.PushToUid or App.KickUid
+	ConnId  string // The unique id of the connection of the session, empty if the user is offline
+	Device  Device // The device of the session
+	Status  SubmitStatus
+	Receipt *Receipt // The receipt resolved once the push is sent, nil unless the status is SubmitQueued or SubmitCoalesced, or the session is on another node
+}
+
+// PushToUids pushes to all the sessions of the users with the uids, the results are in the same order as the uids
+// The body is encoded and compressed once for all the users, an offline user gets one result of SubmitOffline
+// 推送给指定用户的所有会话，结果与uids的顺序相同，消息只编码压缩一次，不在线的用户有一条SubmitOffline的结果
+func (clientPool *ClientPool) PushToUids(uids []int64, notifyType string, body interface{}) []PushResult {
+	pushUids := make([]int64, 0, len(uids))
+	handlers := make([]*MessageHandler, 0, len(uids))
+	for _, uid := range uids {
+		sessions := clientPool.GetClientsByUid(uid)
+		if len(sessions) == 0 {
+			pushUids = append(pushUids, uid)
+			handlers = append(handlers, nil)
+			continue
+		}
+		for _, session := range sessions {
+			pushUids = append(pushUids, uid)
+			handlers = append(handlers, session)
+		}
+	}
+	return pushPrepared(pushUids, handlers, newPushMessage(notifyType, body))
+}
+
+// PushToDevice pushes to the session of the user on the device, the status is SubmitOffline if there is no such session
+// 推送给用户在指定设备上的会话，没有该会话时结果为SubmitOffline
+func (clientPool *ClientPool) PushToDevice(uid int64, deviceId string, notifyType string, body interface{}) PushResult {
+	handler := clientPool.GetClientByDevice(uid, deviceId)
+	if handler == nil {
+		return PushResult{
+			Uid:    uid,
+			Device: Device{Id: deviceId},
+			Status: SubmitOffline,
+		}
+	}
+	return pushPrepared([]int64{uid}, []*MessageHandler{handler}, newPushMessage(notifyType, body))[0]
+}
+
+// PushToAll pushes to all the sessions accepted by the filter, nil means all of them
+// The sessions are the ones online when it's called, the filter is called without holding the lock of the pool
+//
+//	results := pool.PushToAll("system.Announce", announcement, func(uid int64, handler *gosocket.MessageHandler) bool {
+//		return !muted[uid] && handler.Device().Class != "web"
+//	})
+//
+// 推送给filter接受的所有会话，nil表示全部，会话为调用时在线的会话，调用filter时不持有连接池的锁
+func (clientPool *ClientPool) PushToAll(notifyType string, body interface{}, filter func(uid int64, handler *MessageHandler) bool) []PushResult {
+	//Take a snapshot shard by shard, so that the pushes don't block the users logging in or out
+	//逐个分片获取快照，推送时不阻塞用户登入登出
+	var uids []int64
+	var handlers []*MessageHandler
+	for _, shard := range clientPool.shards {
+		shard.lock.RLock()
+		for uid, sessions := range shard.clients {
+			for _, session := range sessions {
+				uids = append(uids, uid)
+				handlers = append(handlers, session)
+			}
+		}
+		shard.lock.RUnlock()
+	}
+	if filter != nil {
+		accepted := 0
+		for i, uid := range uids {
+			if filter(uid, handlers[i]) {
+				uids[accepted], handlers[accepted] = uid, handlers[i]
+				accepted++
+			}
+		}
+		uids, handlers = uids[:accepted], handlers[:accepted]
+	}
+	return pushPrepared(uids, handlers, newPushMessage(notifyType, body))
+}
+
+// Create the push message encoded once for all the users
+// 创建所有用户共用、只编码一次的推送消息
+func newPushMessage(notifyType string, body interface{}) *packet.PreparedMessage {
+	return newPayloadPushMessage(notifyType, JSONEncode(body))
+}
+
+// Create the push message with the encoded json payload
+// 使用已编码的json创建推送消息
+func newPayloadPushMessage(notifyType string, payload string) *packet.PreparedMessage {
+	return packet.NewPreparedMessage(&packet.SendReq{
+		Type:       notifyType,
+		Payload:    payload,
+		ReplyLevel: packet.RLevelNoReply,
+	})
+}
+
+// Submit the message to each handler, nil means the user is offline
+// 将消息提交给每个handler，nil表示用户不在线
+func pushPrepared(uids []int64, handlers []*MessageHandler, message *packet.PreparedMessage) []PushResult {
+	results := make([]PushResult, len(uids))
+	for i, uid := range uids {
+		results[i].Uid = uid
+		if handlers[i] == nil {
+			results[i].Status = SubmitOffline
+			continue
+		}
+		results[i].ConnId = handlers[i].connId
+		results[i].Device = handlers[i].device
+		results[i].Status, results[i].Receipt = handlers[i].Submit(message)
+	}
+	return results
+}
+
+func (app *App) clientPoolShards() int {
+	if app.Config == nil || app.Config.ClientPoolShards <= 0 {
+		return kDefaultClientPoolShards
+	}
+	return app.Config.ClientPoolShards
 }
@@ -0,0 +1,106 @@
+package gosocket
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/yankawayu/go-socket/packet"
+)
+
+// The number of the users online before each benchmark
+const kBenchmarkUsers = 10000
+
+var benchmarkShards = []int{1, 16, 64, 256}
+
+// Create a pool with kBenchmarkUsers users logged in, the handlers send the messages without a connection
+func newBenchmarkPool(b *testing.B, shards int) (*ClientPool, *App) {
+	app := NewApp()
+	pool := newClientPool(shards)
+	for uid := int64(0); uid < kBenchmarkUsers; uid++ {
+		pool.addSession(newBenchmarkHandler(app), uid, SessionKickOld, 0)
+	}
+	b.ResetTimer()
+	return pool, app
+}
+
+func newBenchmarkHandler(app *App) *MessageHandler {
+	handler := app.newMessageHandler(nil, "127.0.0.1")
+	handler.queueMessage = func(message packet.IMessage, receipt *Receipt, options *SubmitOptions) SubmitStatus {
+		if receipt != nil {
+			receipt.resolve(nil)
+		}
+		return SubmitQueued
+	}
+	return handler
+}
+
+// Each login is followed by a logout so that the size of the pool stays the same
+func BenchmarkClientPoolLogin(b *testing.B) {
+	for _, shards := range benchmarkShards {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			pool, app := newBenchmarkPool(b, shards)
+			var next int64
+			b.RunParallel(func(pb *testing.PB) {
+				handler := newBenchmarkHandler(app)
+				for pb.Next() {
+					uid := kBenchmarkUsers + atomic.AddInt64(&next, 1)
+					pool.addSession(handler, uid, SessionKickOld, 0)
+					pool.removeSession(handler, uid)
+				}
+			})
+		})
+	}
+}
+
+// Log the users in from an empty pool, the pool grows with the users
+func BenchmarkClientPoolLoginEmpty(b *testing.B) {
+	for _, shards := range benchmarkShards {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			app := NewApp()
+			pool := newClientPool(shards)
+			var next int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				handler := newBenchmarkHandler(app)
+				for pb.Next() {
+					pool.addSession(handler, atomic.AddInt64(&next, 1), SessionKickOld, 0)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkClientPoolLookup(b *testing.B) {
+	for _, shards := range benchmarkShards {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			pool, _ := newBenchmarkPool(b, shards)
+			var next int64
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					uid := atomic.AddInt64(&next, 1) % kBenchmarkUsers
+					if pool.GetClientByUid(uid) == nil {
+						b.Fatal("user offline")
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkClientPoolPush(b *testing.B) {
+	for _, shards := range benchmarkShards {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			pool, _ := newBenchmarkPool(b, shards)
+			var next int64
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					uid := atomic.AddInt64(&next, 1) % kBenchmarkUsers
+					if results := pool.PushToUids([]int64{uid}, "bench.Push", nil); results[0].Status != SubmitQueued {
+						b.Fatal(results[0].Status)
+					}
+				}
+			})
+		})
+	}
+}
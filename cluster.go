@@ -0,0 +1,288 @@
+package gosocket
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/yankawayu/go-socket/packet"
+)
+
+// kClusterTimeout is the deadline of kicking the sessions on the other nodes out when a user logs in
+// 用户登陆时踢出其他节点上会话的超时时间
+const kClusterTimeout = 5 * time.Second
+
+// ErrNodeUnreachable is returned by ClusterBus.Send if the node hasn't joined the cluster or its link is down
+var ErrNodeUnreachable = errors.New("cluster node unreachable")
+
+// ClusterBus connects the servers running behind the load balancer, so that a user connected to one node
+// can be reached from the others. It keeps a directory of the nodes where each user is online, and routes
+// the pushes and the kicks to them. Set it by AppConfig.ClusterBus, see MemoryCluster and MeshBus
+// 连接负载均衡后的各个服务器，记录每个用户所在的节点，并将推送和踢出路由到对应节点
+type ClusterBus interface {
+	// Node returns the id of the current node, it's unique in the cluster
+	// 当前节点的id，集群内唯一
+	Node() string
+	// Join registers the current node, the messages sent to it are handled by the handler
+	// It's called by Run and Serve
+	// 注册当前节点，发给它的消息由handler处理
+	Join(handler ClusterHandler) error
+	// Leave unregisters the current node and removes the presence of its users
+	// It's called once the server has stopped and all the connections have finished
+	// 注销当前节点，并移除其用户的在线状态
+	Leave() error
+	// SetPresence marks whether the user is online on the current node
+	// The calls of the same user are in order, and they shouldn't block for long since the logins wait for them
+	// 标记用户在当前节点是否在线，同一用户的调用是有序的，登陆会等待调用，不应长时间阻塞
+	SetPresence(uid int64, online bool) error
+	// Lookup returns the nodes where the user is online, including the current one
+	// 用户在线的节点，包括当前节点
+	Lookup(uid int64) ([]string, error)
+	// Send the message to the node and return the results of its handler
+	// 将消息发送给节点，返回其handler的结果
+	Send(ctx context.Context, node string, message *ClusterMessage) ([]PushResult, error)
+}
+
+// ClusterHandler handles the message sent from another node, see ClusterBus.Join
+// 处理其他节点发来的消息
+type ClusterHandler func(from string, message *ClusterMessage) []PushResult
+
+// ClusterMessageType is the type of the messages routed between the nodes
+// 节点间路由的消息类型
+type ClusterMessageType uint8
+
+const (
+	// ClusterPush pushes to all the sessions of the user on the node
+	//推送给用户在该节点上的所有会话
+	ClusterPush ClusterMessageType = iota + 1
+	// ClusterKick kicks the sessions of the user on the node out
+	//踢出用户在该节点上的会话
+	ClusterKick
+)
+
+// ClusterMessage is the message routed to the node where the user is online
+// 路由到用户所在节点的消息
+type ClusterMessage struct {
+	Type ClusterMessageType `json:"type"`
+	Uid  int64              `json:"uid"`
+	// The device of the new session for ClusterKick, the old sessions are kicked out according to AppConfig.SessionPolicy
+	// nil means kicking all the sessions out, see App.KickUid
+	//ClusterKick中新会话的设备，按AppConfig.SessionPolicy踢出旧会话，nil表示踢出所有会话
+	Device *Device `json:"device,omitempty"`
+	// The type and the json payload of ClusterPush
+	//ClusterPush的类型及json内容
+	PushType string `json:"push_type,omitempty"`
+	Payload  string `json:"payload,omitempty"`
+}
+
+func (app *App) clusterBus() ClusterBus {
+	if app.Config == nil {
+		return nil
+	}
+	return app.Config.ClusterBus
+}
+
+// Register the current node in the cluster, it does nothing without AppConfig.ClusterBus
+// 在集群中注册当前节点，未设置AppConfig.ClusterBus时不做任何事
+func (app *App) joinCluster() error {
+	bus := app.clusterBus()
+	if bus == nil {
+		return nil
+	}
+	return bus.Join(app.handleClusterMessage)
+}
+
+// Unregister the current node from the cluster
+// 从集群中注销当前节点
+func (app *App) leaveCluster() {
+	bus := app.clusterBus()
+	if bus == nil {
+		return
+	}
+	if err := bus.Leave(); err != nil {
+		app.Log.Error(err)
+	}
+}
+
+// Report whether the user is online on the current node to the cluster
+// 向集群报告用户是否在当前节点在线
+func (app *App) syncPresence(uid int64) {
+	bus := app.clusterBus()
+	if bus == nil {
+		return
+	}
+	app.clientPool.syncPresence(uid, func(online bool) {
+		if err := bus.SetPresence(uid, online); err != nil {
+			app.Log.Error(err)
+		}
+	})
+}
+
+// The other nodes where the user is online
+// 用户在线的其他节点
+func (app *App) remoteNodes(uid int64) []string {
+	bus := app.clusterBus()
+	if bus == nil {
+		return nil
+	}
+	nodes, err := bus.Lookup(uid)
+	if err != nil {
+		app.Log.Error(err)
+		return nil
+	}
+	remoteNodes := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if node != bus.Node() {
+			remoteNodes = append(remoteNodes, node)
+		}
+	}
+	return remoteNodes
+}
+
+// Send the message to the other nodes where the user is online, the nodes failed are reported as SubmitUnreachable
+// 将消息发给用户在线的其他节点，失败的节点结果为SubmitUnreachable
+func (app *App) sendToRemoteNodes(ctx context.Context, uid int64, message *ClusterMessage) []PushResult {
+	var results []PushResult
+	for _, node := range app.remoteNodes(uid) {
+		nodeResults, err := app.clusterBus().Send(ctx, node, message)
+		if err != nil {
+			app.Log.Debugf("send to node %s failed: %v", node, err)
+			results = append(results, PushResult{
+				Uid:    uid,
+				Node:   node,
+				Status: SubmitUnreachable,
+			})
+			continue
+		}
+		for _, result := range nodeResults {
+			result.Node = node
+			result.Receipt = nil
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// PushToUid pushes to all the sessions of the user on all the nodes of the cluster
+// The ctx limits the time waiting for the other nodes, the sessions on them have no receipts.
+// An offline user gets one result of SubmitOffline. Without AppConfig.ClusterBus, only the current node is pushed to
+//
+//	for _, result := range app.PushToUid(ctx, uid, "chat.NewMessage", message) {
+//		if result.Status == gosocket.SubmitOffline {
+//			storeOffline(uid, message)
+//		}
+//	}
+//
+// 推送给用户在集群所有节点上的会话，ctx限制等待其他节点的时间，其他节点上的会话没有回执
+// 不在线的用户有一条SubmitOffline的结果，未设置AppConfig.ClusterBus时只推送当前节点
+func (app *App) PushToUid(ctx context.Context, uid int64, notifyType string, body interface{}) []PushResult {
+	payload := JSONEncode(body)
+	results := app.pushLocalSessions(uid, notifyType, payload)
+	results = append(results, app.sendToRemoteNodes(ctx, uid, &ClusterMessage{
+		Type:     ClusterPush,
+		Uid:      uid,
+		PushType: notifyType,
+		Payload:  payload,
+	})...)
+	if len(results) == 0 {
+		return []PushResult{{Uid: uid, Status: SubmitOffline}}
+	}
+	return results
+}
+
+// KickUid kicks all the sessions of the user out on all the nodes of the cluster, the results are the Disconnect messages
+// 踢出用户在集群所有节点上的会话，结果为发出的Disconnect消息
+func (app *App) KickUid(ctx context.Context, uid int64) []PushResult {
+	results := app.kickLocalSessions(uid, nil)
+	return append(results, app.sendToRemoteNodes(ctx, uid, &ClusterMessage{
+		Type: ClusterKick,
+		Uid:  uid,
+	})...)
+}
+
+// Kick the sessions on the other nodes out for the new session of the device
+// 为设备的新会话踢出其他节点上的会话
+func (app *App) kickRemoteSessions(ctx context.Context, uid int64, device Device) {
+	ctx, cancel := context.WithTimeout(ctx, kClusterTimeout)
+	defer cancel()
+	app.sendToRemoteNodes(ctx, uid, &ClusterMessage{
+		Type:   ClusterKick,
+		Uid:    uid,
+		Device: &device,
+	})
+}
+
+// Handle the messages sent from the other nodes
+// 处理其他节点发来的消息
+func (app *App) handleClusterMessage(from string, message *ClusterMessage) []PushResult {
+	switch message.Type {
+	case ClusterPush:
+		return app.pushLocalSessions(message.Uid, message.PushType, message.Payload)
+	case ClusterKick:
+		return app.kickLocalSessions(message.Uid, message.Device)
+	}
+	app.Log.Errorf("unknown cluster message type %d from node %s", message.Type, from)
+	return nil
+}
+
+// Push to the sessions of the user on the current node, the result is empty if the user is offline
+// 推送给用户在当前节点上的会话，用户不在线时结果为空
+func (app *App) pushLocalSessions(uid int64, notifyType string, payload string) []PushResult {
+	sessions := app.clientPool.GetClientsByUid(uid)
+	if len(sessions) == 0 {
+		return nil
+	}
+	uids := make([]int64, len(sessions))
+	for i := range uids {
+		uids[i] = uid
+	}
+	results := pushPrepared(uids, sessions, newPayloadPushMessage(notifyType, payload))
+	if bus := app.clusterBus(); bus != nil {
+		for i := range results {
+			results[i].Node = bus.Node()
+		}
+	}
+	return results
+}
+
+// Kick the sessions of the user on the current node out for the new session of the device, nil means all of them
+// 为设备的新会话踢出用户在当前节点上的会话，nil表示全部踢出
+func (app *App) kickLocalSessions(uid int64, device *Device) []PushResult {
+	sessions := app.clientPool.GetClientsByUid(uid)
+	if device != nil {
+		sessions = sessionsToKick(app.sessionPolicy(), 0, sessions, *device)
+	}
+	results := make([]PushResult, len(sessions))
+	for i, session := range sessions {
+		results[i] = PushResult{
+			Uid:    uid,
+			ConnId: session.connId,
+			Device: session.device,
+			//Kicked out by a new session of the same user unless it's kicked by KickUid
+			//除KickUid外，都是被同一用户的新会话踢出
+			Status: app.kickOut(session, device != nil),
+		}
+		if bus := app.clusterBus(); bus != nil {
+			results[i].Node = bus.Node()
+		}
+	}
+	return results
+}
+
+// Ask the client to disconnect and stop the handler
+// 通知客户端断开连接并停止处理消息
+func (app *App) kickOut(handler *MessageHandler, isKickOut bool) SubmitStatus {
+	uid := handler.user.GetUid()
+	//Send KickOut message to remove the old connection
+	//通知客户端连接断开
+	status, _ := handler.Submit(&packet.Disconnect{
+		Type: packet.DiscTypeKickout,
+	})
+	//Remove it from the pool at once, the handler logs out later once the requests in process respond
+	//立即从连接池移除，handler在正在处理的请求答复后再注销
+	app.ClientPool().removeSession(handler, uid)
+	//停止处理消息
+	handler.Stop(isKickOut)
+	app.Log.Debugf("kick out account %d, conn %s", uid, handler.connId)
+	return status
+}
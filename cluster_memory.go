@@ -0,0 +1,124 @@
+package gosocket
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// MemoryCluster connects the Apps in the same process, e.g. several nodes in tests
+// Create a ClusterBus for each node by NewNode
+//
+//	cluster := gosocket.NewMemoryCluster()
+//	go appA.Serve(listenerA, &gosocket.AppConfig{ClusterBus: cluster.NewNode("a")}, log, fastLog)
+//	go appB.Serve(listenerB, &gosocket.AppConfig{ClusterBus: cluster.NewNode("b")}, log, fastLog)
+//
+// 连接同一进程中的App，如测试中的多个节点，通过NewNode为每个节点创建ClusterBus
+type MemoryCluster struct {
+	lock     sync.RWMutex
+	handlers map[string]ClusterHandler // The nodes joined
+	presence map[int64]map[string]bool // The nodes where the users are online
+}
+
+// NewMemoryCluster create a cluster without any node
+// 创建空的集群
+func NewMemoryCluster() *MemoryCluster {
+	return &MemoryCluster{
+		handlers: make(map[string]ClusterHandler),
+		presence: make(map[int64]map[string]bool),
+	}
+}
+
+// NewNode create the ClusterBus of the node in the cluster
+// 创建集群中节点的ClusterBus
+func (cluster *MemoryCluster) NewNode(node string) ClusterBus {
+	return &memoryNode{
+		cluster: cluster,
+		node:    node,
+	}
+}
+
+// memoryNode is a node of MemoryCluster
+// MemoryCluster中的节点
+type memoryNode struct {
+	cluster *MemoryCluster
+	node    string
+}
+
+func (node *memoryNode) Node() string {
+	return node.node
+}
+
+func (node *memoryNode) Join(handler ClusterHandler) error {
+	cluster := node.cluster
+	cluster.lock.Lock()
+	defer cluster.lock.Unlock()
+	if _, ok := cluster.handlers[node.node]; ok {
+		return errors.New("node " + node.node + " has joined the cluster already")
+	}
+	cluster.handlers[node.node] = handler
+	return nil
+}
+
+func (node *memoryNode) Leave() error {
+	cluster := node.cluster
+	cluster.lock.Lock()
+	defer cluster.lock.Unlock()
+	delete(cluster.handlers, node.node)
+	for uid, nodes := range cluster.presence {
+		delete(nodes, node.node)
+		if len(nodes) == 0 {
+			delete(cluster.presence, uid)
+		}
+	}
+	return nil
+}
+
+func (node *memoryNode) SetPresence(uid int64, online bool) error {
+	cluster := node.cluster
+	cluster.lock.Lock()
+	defer cluster.lock.Unlock()
+	nodes := cluster.presence[uid]
+	if online {
+		if nodes == nil {
+			nodes = make(map[string]bool)
+			cluster.presence[uid] = nodes
+		}
+		nodes[node.node] = true
+		return nil
+	}
+	delete(nodes, node.node)
+	if len(nodes) == 0 {
+		delete(cluster.presence, uid)
+	}
+	return nil
+}
+
+func (node *memoryNode) Lookup(uid int64) ([]string, error) {
+	cluster := node.cluster
+	cluster.lock.RLock()
+	defer cluster.lock.RUnlock()
+	nodes := make([]string, 0, len(cluster.presence[uid]))
+	for onlineNode := range cluster.presence[uid] {
+		nodes = append(nodes, onlineNode)
+	}
+	sort.Strings(nodes)
+	return nodes, nil
+}
+
+func (node *memoryNode) Send(ctx context.Context, target string, message *ClusterMessage) ([]PushResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	node.cluster.lock.RLock()
+	handler := node.cluster.handlers[target]
+	node.cluster.lock.RUnlock()
+	if handler == nil {
+		return nil, ErrNodeUnreachable
+	}
+	//Copy the message as if it's sent through the network
+	//复制消息，与通过网络发送时一致
+	copied := *message
+	return handler(node.node, &copied), nil
+}
@@ -0,0 +1,606 @@
+package gosocket
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yankawayu/go-socket/packet"
+)
+
+// kMeshKeepAliveTime is the interval of the ping pong messages of the links between the nodes in seconds
+// A node is regarded as down if nothing arrives in 1.5 times of it
+// 节点间连接的心跳间隔（秒），超过1.5倍没有收到数据时视为节点下线
+const kMeshKeepAliveTime = 10
+
+// kMeshSyncChunk is the max number of the uids in a mesh.Sync message, the users online are sent in several of them
+// 每条mesh.Sync消息最多包含的uid数，在线用户分多条消息发送
+const kMeshSyncChunk = 1000
+
+// The max payload lengths of the messages between the nodes, a chunk of uids fits in kMeshSyncPayloadLength,
+// and the pushes delivered to the other nodes can be as large as the ones to the users
+// 节点间消息的最大长度，一批uid不超过kMeshSyncPayloadLength，转发给其他节点的推送可能与推送给用户的一样大
+const (
+	kMeshSyncPayloadLength    = 64 * 1024
+	kMeshDeliverPayloadLength = 16 * 1024 * 1024
+)
+
+// MeshOptions is used to configure MeshBus
+// MeshBus配置
+type MeshOptions struct {
+	Addr    string      // The address in the format of "ip:port" listened for the links of the other nodes, it's also the id of the node
+	Peers   []string    // The addresses of the other nodes, the current one is ignored so that all the nodes can share the list
+	Secret  string      // Shared by all the nodes to authenticate the links, it can't be empty
+	Log     ILogger     // Used to log the errors of the links
+	FastLog IFastLogger // Used to log the messages received from the other nodes
+	// TlsConfig enables tls on the links if it isn't nil, it's used by both the listener and the links,
+	// so it needs the certificate of the current node and the CAs verifying the other nodes
+	//不为nil时节点间连接使用tls，同时用于监听和连接，需要包含当前节点的证书以及验证其他节点的CA
+	TlsConfig *tls.Config
+}
+
+// MeshBus is a ClusterBus linking every node to all the others through GOSOC connections, no other service is needed
+// Each node keeps the presence of the users of the other nodes, which is sent through the links once it changes,
+// so Lookup doesn't go through the network. The presence of a node is removed once its link is down
+//
+//	bus := gosocket.NewMeshBus(&gosocket.MeshOptions{
+//		Addr:    "10.0.0.1:7000",
+//		Peers:   []string{"10.0.0.1:7000", "10.0.0.2:7000", "10.0.0.3:7000"},
+//		Secret:  os.Getenv("MESH_SECRET"),
+//		Log:     log,
+//		FastLog: fastLog,
+//	})
+//	appConfig.ClusterBus = bus
+//
+// 每个节点通过GOSOC连接与其他所有节点相连，不需要其他服务
+// 每个节点保存其他节点用户的在线状态，变化时通过连接发送，Lookup不经过网络，连接断开时移除该节点的在线状态
+type MeshBus struct {
+	options *MeshOptions
+	handler ClusterHandler
+
+	app      *App         // Serves the links from the other nodes
+	listener net.Listener // Listens for the links from the other nodes
+	links    map[string]*meshLink
+	stopChan chan struct{} // Closed by Leave
+
+	presenceLock sync.Mutex                // Keeps the presence queued into the links in order
+	lock         sync.RWMutex              // Protects local, peers and inbound
+	local        map[int64]bool            // The users online on the current node
+	peers        map[string]*meshPeerState // The users online on the other nodes
+	inbound      map[*meshPeer]bool        // The links from the other nodes
+	peerUids     int64                     // The uid of the last link accepted
+}
+
+// meshLink is the link to another node, the presence is queued into it and sent in order by its own goroutine,
+// so that a slow node doesn't block the users logging in or out, see MeshBus.sendUpdates
+// 到其他节点的连接，在线状态加入队列后由单独的协程按顺序发送，以免慢节点阻塞用户登入登出
+type meshLink struct {
+	client  *Client
+	lock    sync.Mutex
+	updates []meshUpdate  // The messages waiting to be sent in order
+	signal  chan struct{} // Notifies the sending goroutine, buffered by 1
+}
+
+// A message waiting to be sent through the link
+// 等待通过连接发送的消息
+type meshUpdate struct {
+	payloadType string
+	param       interface{}
+}
+
+// Queue the updates after the ones queued before, or replace them if they are all sent again by the updates
+// 将消息加入队列，replace为true时替换之前未发送的消息
+func (link *meshLink) queue(updates []meshUpdate, replace bool) {
+	link.lock.Lock()
+	if replace {
+		link.updates = nil
+	}
+	link.updates = append(link.updates, updates...)
+	link.lock.Unlock()
+	select {
+	case link.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Take all the updates queued
+// 取出队列中所有消息
+func (link *meshLink) take() []meshUpdate {
+	link.lock.Lock()
+	defer link.lock.Unlock()
+	updates := link.updates
+	link.updates = nil
+	return updates
+}
+
+// The presence of the users of another node
+// 其他节点用户的在线状态
+type meshPeerState struct {
+	owner *meshPeer      // The link the presence comes from, the messages from the older links are ignored
+	uids  map[int64]bool // The users online on the node
+}
+
+// NewMeshBus create the bus of the current node, the links are set up once it joins
+// It can't join again after leaving, create a new one instead
+// 创建当前节点的MeshBus，加入集群时建立连接，离开后不能再次加入
+func NewMeshBus(options *MeshOptions) *MeshBus {
+	return &MeshBus{
+		options: options,
+		links:   make(map[string]*meshLink),
+		local:   make(map[int64]bool),
+		peers:   make(map[string]*meshPeerState),
+		inbound: make(map[*meshPeer]bool),
+	}
+}
+
+func (bus *MeshBus) Node() string {
+	return bus.options.Addr
+}
+
+// Join listens for the links from the other nodes and connects to them
+// The nodes not started yet are connected in background until they are up
+// 监听其他节点的连接，并连接其他节点，尚未启动的节点会在后台持续连接
+func (bus *MeshBus) Join(handler ClusterHandler) error {
+	if bus.options.Log == nil || bus.options.FastLog == nil {
+		return errors.New("log or fastLog of the mesh can't be nil")
+	}
+	if bus.options.Secret == "" {
+		return errors.New("secret of the mesh can't be empty")
+	}
+	if bus.stopChan != nil {
+		return errors.New("node " + bus.Node() + " has joined the cluster already")
+	}
+	//Create all the links before serving, they are read without lock
+	//服务前创建所有连接，读取时不加锁
+	for _, addr := range bus.options.Peers {
+		if addr == bus.Node() || bus.links[addr] != nil {
+			continue
+		}
+		link, err := bus.newLink(addr)
+		if err != nil {
+			return err
+		}
+		bus.links[addr] = link
+	}
+	listener, err := net.Listen("tcp", bus.options.Addr)
+	if err != nil {
+		return err
+	}
+	if bus.options.TlsConfig != nil {
+		listener = tls.NewListener(listener, bus.options.TlsConfig)
+	}
+	bus.handler = handler
+	bus.listener = listener
+	bus.stopChan = make(chan struct{})
+	bus.app = NewApp()
+	bus.app.Router("mesh", &meshController{})
+	bus.app.SetAuthUser(&meshPeer{})
+	//The users of the links need the bus, which isn't copied from the prototype by reflection
+	//连接的用户需要MeshBus，反射创建时不会从原型复制
+	bus.app.newUser = func() IUser {
+		return &meshPeer{bus: bus}
+	}
+	go func() {
+		if err := bus.app.Serve(listener, &AppConfig{}, bus.options.Log, bus.options.FastLog); err != nil {
+			bus.options.Log.Error(err)
+		}
+	}()
+	for addr, link := range bus.links {
+		go bus.sendUpdates(addr, link)
+		go bus.connectLink(link.client)
+	}
+	return nil
+}
+
+// Create the link to the node, the presence of the current node is sent every time it's connected
+// 创建到节点的连接，每次连接成功后发送当前节点的在线状态
+func (bus *MeshBus) newLink(addr string) (*meshLink, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+	options := DefaultClientOptions()
+	options.Reconnect = true
+	options.KeepAliveTime = kMeshKeepAliveTime
+	options.OnStateChange = func(state ClientState, err error) {
+		if state == ClientStateConnected {
+			bus.syncLink(addr)
+		}
+	}
+	if tlsConfig := bus.options.TlsConfig; tlsConfig != nil {
+		options.Dialer = func(ctx context.Context, addr string) (net.Conn, error) {
+			dialer := &tls.Dialer{Config: tlsConfig}
+			return dialer.DialContext(ctx, "tcp", addr)
+		}
+	}
+	provider := &meshConnectProvider{
+		node:   bus.Node(),
+		target: addr,
+		secret: bus.options.Secret,
+	}
+	return &meshLink{
+		client: NewClientWithOptions(host, port, false, bus.options.Log, provider, options),
+		signal: make(chan struct{}, 1),
+	}, nil
+}
+
+// Keep connecting until it succeeds, then the client reconnects by itself once the link drops
+// 持续连接直到成功，之后断开时由客户端自动重连
+func (bus *MeshBus) connectLink(link *Client) {
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-bus.stopChan:
+			return
+		default:
+		}
+		if err := link.Connect(); err == nil {
+			//Leave was called while connecting
+			//连接过程中离开了集群
+			select {
+			case <-bus.stopChan:
+				link.Disconnect()
+			default:
+			}
+			return
+		}
+		select {
+		case <-bus.stopChan:
+			return
+		case <-time.After(link.options.backoff(attempt)):
+		}
+	}
+}
+
+// Send all the users online on the current node through the link in chunks, replacing the ones sent before
+// The updates queued before are dropped, since the users include them
+// 通过连接分批发送当前节点所有在线用户，替换之前发送的，队列中未发送的消息已包含在内，直接丢弃
+func (bus *MeshBus) syncLink(addr string) {
+	bus.presenceLock.Lock()
+	defer bus.presenceLock.Unlock()
+	bus.lock.RLock()
+	uids := make([]int64, 0, len(bus.local))
+	for uid := range bus.local {
+		uids = append(uids, uid)
+	}
+	bus.lock.RUnlock()
+	updates := make([]meshUpdate, 0, len(uids)/kMeshSyncChunk+1)
+	for start := 0; start == 0 || start < len(uids); start += kMeshSyncChunk {
+		end := start + kMeshSyncChunk
+		if end > len(uids) {
+			end = len(uids)
+		}
+		updates = append(updates, meshUpdate{
+			payloadType: "mesh.Sync",
+			param:       &meshSyncParam{Uids: uids[start:end], Replace: start == 0},
+		})
+	}
+	bus.links[addr].queue(updates, true)
+}
+
+// Send the updates queued into the link one by one until the bus leaves
+// 逐条发送连接队列中的消息，直到离开集群
+func (bus *MeshBus) sendUpdates(addr string, link *meshLink) {
+	for {
+		select {
+		case <-bus.stopChan:
+			return
+		case <-link.signal:
+		}
+		for _, update := range link.take() {
+			link.client.GetData(update.payloadType, update.param, bus.logLinkError(addr), nil)
+		}
+	}
+}
+
+func (bus *MeshBus) logLinkError(addr string) GetDataCallback {
+	return func(err error, data string) {
+		if err == nil {
+			return
+		}
+		//The requests fail once the bus leaves, they aren't errors
+		//离开集群后请求失败不是错误
+		select {
+		case <-bus.stopChan:
+		default:
+			bus.options.Log.Errorf("mesh link to %s: %v", addr, err)
+		}
+	}
+}
+
+// Leave closes all the links, the other nodes remove the presence of the current node once the links are down
+// 关闭所有连接，其他节点在连接断开后移除当前节点的在线状态
+func (bus *MeshBus) Leave() error {
+	if bus.stopChan == nil {
+		return nil
+	}
+	close(bus.stopChan)
+	for _, link := range bus.links {
+		link.client.Disconnect()
+	}
+	err := bus.listener.Close()
+	//Close the links from the other nodes, they reconnect once the current node is up again
+	//关闭其他节点的连接，当前节点重新启动后它们会重连
+	bus.lock.RLock()
+	peers := make([]*meshPeer, 0, len(bus.inbound))
+	for peer := range bus.inbound {
+		peers = append(peers, peer)
+	}
+	bus.lock.RUnlock()
+	for _, peer := range peers {
+		if handler := bus.app.ClientPool().GetClientByUid(peer.linkUid); handler != nil {
+			handler.Submit(&packet.Disconnect{})
+			handler.Stop(false)
+		}
+	}
+	return err
+}
+
+// SetPresence records the user and queues it into the links to the other nodes, it doesn't wait for them
+// 记录用户在线状态并加入到其他节点的连接队列，不等待其他节点
+func (bus *MeshBus) SetPresence(uid int64, online bool) error {
+	bus.presenceLock.Lock()
+	defer bus.presenceLock.Unlock()
+	bus.lock.Lock()
+	if bus.local[uid] == online {
+		bus.lock.Unlock()
+		return nil
+	}
+	if online {
+		bus.local[uid] = true
+	} else {
+		delete(bus.local, uid)
+	}
+	bus.lock.Unlock()
+	//The links not connected are skipped, the presence is sent once they are connected, see syncLink
+	//跳过未连接的连接，连接成功后会重新发送
+	update := meshUpdate{
+		payloadType: "mesh.Presence",
+		param:       &meshPresenceParam{Uid: uid, Online: online},
+	}
+	for _, link := range bus.links {
+		if link.client.State() == ClientStateConnected {
+			link.queue([]meshUpdate{update}, false)
+		}
+	}
+	return nil
+}
+
+func (bus *MeshBus) Lookup(uid int64) ([]string, error) {
+	bus.lock.RLock()
+	defer bus.lock.RUnlock()
+	var nodes []string
+	if bus.local[uid] {
+		nodes = append(nodes, bus.Node())
+	}
+	for node, peerState := range bus.peers {
+		if peerState.uids[uid] {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Strings(nodes)
+	return nodes, nil
+}
+
+func (bus *MeshBus) Send(ctx context.Context, node string, message *ClusterMessage) ([]PushResult, error) {
+	if node == bus.Node() {
+		return bus.handler(node, message), nil
+	}
+	link := bus.links[node]
+	if link == nil || link.client.State() != ClientStateConnected {
+		return nil, ErrNodeUnreachable
+	}
+	var data meshDeliverData
+	if err := link.client.Call(ctx, "mesh.Deliver", message, &data); err != nil {
+		return nil, err
+	}
+	results := make([]PushResult, len(data.Results))
+	for i, result := range data.Results {
+		results[i] = PushResult{
+			Uid:    result.Uid,
+			ConnId: result.ConnId,
+			Device: result.Device,
+			Status: result.Status,
+		}
+	}
+	return results, nil
+}
+
+// Replace the presence of the node with the first chunk sent by the latest link, the other chunks are added to it
+// 用最新连接发送的第一批用户替换节点的在线状态，之后的各批加入其中
+func (bus *MeshBus) syncPeer(peer *meshPeer, uids []int64, replace bool) {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	peerState := bus.peers[peer.node]
+	if replace || peerState == nil {
+		peerState = &meshPeerState{
+			owner: peer,
+			uids:  make(map[int64]bool, len(uids)),
+		}
+		bus.peers[peer.node] = peerState
+	}
+	if peerState.owner != peer {
+		return
+	}
+	for _, uid := range uids {
+		peerState.uids[uid] = true
+	}
+}
+
+// Update the presence of the user of the node, unless it's sent by an old link
+// 更新节点用户的在线状态，忽略旧连接发送的
+func (bus *MeshBus) updatePeer(peer *meshPeer, uid int64, online bool) {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	peerState := bus.peers[peer.node]
+	if peerState == nil {
+		peerState = &meshPeerState{
+			owner: peer,
+			uids:  make(map[int64]bool),
+		}
+		bus.peers[peer.node] = peerState
+	}
+	if peerState.owner != peer {
+		return
+	}
+	if online {
+		peerState.uids[uid] = true
+	} else {
+		delete(peerState.uids, uid)
+	}
+}
+
+// Remove the presence of the node once its link is down, unless there is a newer link
+// 连接断开后移除节点的在线状态，除非已有更新的连接
+func (bus *MeshBus) dropPeer(peer *meshPeer) {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	delete(bus.inbound, peer)
+	if peerState := bus.peers[peer.node]; peerState != nil && peerState.owner == peer {
+		delete(bus.peers, peer.node)
+	}
+}
+
+// The connect info of the links
+// 节点间连接的登陆信息
+type meshConnectInfo struct {
+	Node   string `json:"node"`   // The node connecting
+	Target string `json:"target"` // The node connected
+	Secret string `json:"secret"`
+}
+
+type meshConnectProvider struct {
+	node   string
+	target string
+	secret string
+}
+
+func (provider *meshConnectProvider) GetConnectInfo() string {
+	return JSONEncode(&meshConnectInfo{Node: provider.node, Target: provider.target, Secret: provider.secret})
+}
+
+// meshPeer is the user of a link from another node
+// 其他节点连接的用户
+type meshPeer struct {
+	AuthUser
+	bus     *MeshBus
+	node    string // The node of the link
+	linkUid int64  // The uid of the link, unique in the current node
+}
+
+// Auth accepts the nodes in MeshOptions.Peers with the same secret, each link gets a unique uid
+// 验证MeshOptions.Peers中密钥相同的节点，每个连接获得唯一的uid
+func (peer *meshPeer) Auth(payload string, ip string) (uid int64, code packet.ReturnCode) {
+	connectInfo := meshConnectInfo{}
+	if err := json.Unmarshal([]byte(payload), &connectInfo); err != nil {
+		return -1, packet.RetCodeBadLoginInfo
+	}
+	bus := peer.bus
+	if bus == nil || connectInfo.Target != bus.Node() {
+		return -1, packet.RetCodeBadLoginInfo
+	}
+	if subtle.ConstantTimeCompare([]byte(connectInfo.Secret), []byte(bus.options.Secret)) != 1 {
+		return -1, packet.RetCodeBadLoginInfo
+	}
+	if _, ok := bus.links[connectInfo.Node]; !ok {
+		return -1, packet.RetCodeBadLoginInfo
+	}
+	peer.node = connectInfo.Node
+	peer.linkUid = atomic.AddInt64(&bus.peerUids, 1)
+	bus.lock.Lock()
+	bus.inbound[peer] = true
+	bus.lock.Unlock()
+	return peer.linkUid, packet.RetCodeAccepted
+}
+
+// Logout removes the presence of the node sent through the link
+// 移除通过该连接发送的节点在线状态
+func (peer *meshPeer) Logout(isKickOut bool) {
+	if peer.node != "" {
+		peer.bus.dropPeer(peer)
+	}
+	peer.AuthUser.Logout(isKickOut)
+}
+
+type meshSyncParam struct {
+	Uids    []int64 `json:"uids"`
+	Replace bool    `json:"replace"` // Whether it's the first chunk replacing the users sent before
+}
+
+type meshPresenceParam struct {
+	Uid    int64 `json:"uid"`
+	Online bool  `json:"online"`
+}
+
+type meshPushResult struct {
+	Uid    int64        `json:"uid"`
+	ConnId string       `json:"conn_id"`
+	Device Device       `json:"device"`
+	Status SubmitStatus `json:"status"`
+}
+
+type meshDeliverData struct {
+	Results []meshPushResult `json:"results"`
+}
+
+// meshController handles the messages from the other nodes
+// 处理其他节点发来的消息
+type meshController struct {
+	Controller
+}
+
+func (controller *meshController) GetActionParamMap() map[string]interface{} {
+	return map[string]interface{}{
+		"Sync":     &meshSyncParam{},
+		"Presence": &meshPresenceParam{},
+		"Deliver":  &ClusterMessage{},
+	}
+}
+
+func (controller *meshController) GetActionPayloadLimitMap() map[string]int {
+	return map[string]int{
+		"Sync":    kMeshSyncPayloadLength,
+		"Deliver": kMeshDeliverPayloadLength,
+	}
+}
+
+func (controller *meshController) Sync(param *meshSyncParam, response *ResponseBody) {
+	peer := controller.User.(*meshPeer)
+	peer.bus.syncPeer(peer, param.Uids, param.Replace)
+	response.Status = StatusSuccess
+}
+
+func (controller *meshController) Presence(param *meshPresenceParam, response *ResponseBody) {
+	peer := controller.User.(*meshPeer)
+	peer.bus.updatePeer(peer, param.Uid, param.Online)
+	response.Status = StatusSuccess
+}
+
+func (controller *meshController) Deliver(param *ClusterMessage, response *ResponseBody) {
+	peer := controller.User.(*meshPeer)
+	results := peer.bus.handler(peer.node, param)
+	data := &meshDeliverData{
+		Results: make([]meshPushResult, len(results)),
+	}
+	for i, result := range results {
+		data.Results[i] = meshPushResult{
+			Uid:    result.Uid,
+			ConnId: result.ConnId,
+			Device: result.Device,
+			Status: result.Status,
+		}
+	}
+	response.Status = StatusSuccess
+	response.Data = data
+}
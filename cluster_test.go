@@ -0,0 +1,171 @@
+package gosocket_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	gosocket "github.com/yankawayu/go-socket"
+	"github.com/yankawayu/go-socket/gosockettest"
+)
+
+// Wait until the condition is met, the links of the mesh reconnect with backoff so it can take a few seconds
+func waitFor(t *testing.T, timeout time.Duration, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Pick a free local address for a node of the mesh
+func freeAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+	return addr
+}
+
+func newClusterServer(bus gosocket.ClusterBus) *gosockettest.Server {
+	return gosockettest.NewAppServerWithConfig(gosocket.NewApp(), nil, &gosocket.AppConfig{ClusterBus: bus})
+}
+
+func lookup(bus gosocket.ClusterBus, uid int64) []string {
+	nodes, _ := bus.Lookup(uid)
+	return nodes
+}
+
+func TestClusterPushAndKick(t *testing.T) {
+	cluster := gosocket.NewMemoryCluster()
+	busA, busB := cluster.NewNode("a"), cluster.NewNode("b")
+	serverA, serverB := newClusterServer(busA), newClusterServer(busB)
+	defer serverA.Close()
+	defer serverB.Close()
+	client, err := serverA.Connect(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, func() bool { return len(lookup(busB, 1)) == 1 })
+	results := serverB.App.PushToUid(context.Background(), 1, "test.Remote", 1)
+	if len(results) != 1 || results[0].Node != "a" || results[0].Status != gosocket.SubmitQueued {
+		t.Fatalf("results %+v", results)
+	}
+	if _, err := client.WaitPush("test.Remote", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if results := serverB.App.PushToUid(context.Background(), 2, "test.Remote", 1); len(results) != 1 || results[0].Status != gosocket.SubmitOffline {
+		t.Fatalf("results of the offline user %+v", results)
+	}
+	//Logging in on node b kicks the session on node a out
+	clientB, err := serverB.Connect(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertKicked(t, client)
+	results = serverA.App.KickUid(context.Background(), 1)
+	if len(results) != 1 || results[0].Node != "b" {
+		t.Fatalf("kick results %+v", results)
+	}
+	assertKicked(t, clientB)
+	waitFor(t, time.Second, func() bool { return len(lookup(busA, 1)) == 0 })
+}
+
+func TestMeshPush(t *testing.T) {
+	peers := []string{freeAddr(t), freeAddr(t)}
+	recorder := gosockettest.NewRecorder()
+	newBus := func(addr string) *gosocket.MeshBus {
+		return gosocket.NewMeshBus(&gosocket.MeshOptions{
+			Addr:    addr,
+			Peers:   peers,
+			Secret:  "secret",
+			Log:     recorder,
+			FastLog: recorder.FastLog(),
+		})
+	}
+	busA, busB := newBus(peers[0]), newBus(peers[1])
+	serverA, serverB := newClusterServer(busA), newClusterServer(busB)
+	defer serverA.Close()
+	defer serverB.Close()
+	client, err := serverA.Connect(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//The users of each node are sent through its own link, so both links are up once they are seen by the other node
+	if _, err := serverB.Connect(2); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, func() bool { return len(lookup(busB, 1)) == 1 && len(lookup(busA, 2)) == 1 })
+	results := serverB.App.PushToUid(context.Background(), 1, "test.Mesh", 1)
+	if len(results) != 1 || results[0].Node != peers[0] || results[0].Status != gosocket.SubmitQueued {
+		t.Fatalf("results %+v", results)
+	}
+	if _, err := client.WaitPush("test.Mesh", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	client.Disconnect()
+	waitFor(t, time.Second, func() bool { return len(lookup(busB, 1)) == 0 })
+	if logged := recorder.Errors(); len(logged) > 0 {
+		t.Fatalf("errors logged: %v", logged)
+	}
+}
+
+func TestMeshSyncsManyUsers(t *testing.T) {
+	peers := []string{freeAddr(t), freeAddr(t)}
+	recorder := gosockettest.NewRecorder()
+	handler := func(from string, message *gosocket.ClusterMessage) []gosocket.PushResult { return nil }
+	newBus := func(addr string) *gosocket.MeshBus {
+		return gosocket.NewMeshBus(&gosocket.MeshOptions{
+			Addr:    addr,
+			Peers:   peers,
+			Secret:  "secret",
+			Log:     recorder,
+			FastLog: recorder.FastLog(),
+		})
+	}
+	busA := newBus(peers[0])
+	if err := busA.Join(handler); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = busA.Leave() }()
+	//More users than a mesh.Sync message can hold, they are sent once node b is up
+	const users = 5000
+	for uid := int64(1); uid <= users; uid++ {
+		_ = busA.SetPresence(uid, true)
+	}
+	busB := newBus(peers[1])
+	if err := busB.Join(handler); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = busB.Leave() }()
+	waitFor(t, 5*time.Second, func() bool {
+		for uid := int64(1); uid <= users; uid++ {
+			if nodes := lookup(busB, uid); len(nodes) != 1 || nodes[0] != peers[0] {
+				return false
+			}
+		}
+		return true
+	})
+	if logged := recorder.Errors(); len(logged) > 0 {
+		t.Fatalf("errors logged: %v", logged)
+	}
+}
+
+func TestMeshRequiresSecret(t *testing.T) {
+	recorder := gosockettest.NewRecorder()
+	bus := gosocket.NewMeshBus(&gosocket.MeshOptions{
+		Addr:    freeAddr(t),
+		Log:     recorder,
+		FastLog: recorder.FastLog(),
+	})
+	if err := bus.Join(nil); err == nil {
+		_ = bus.Leave()
+		t.Fatal("joined without a secret")
+	}
+}
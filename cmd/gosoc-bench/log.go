@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// benchLog prints the errors and warnings of the clients to stderr in verbose mode
+// The default Log writes to the runtime directory, which is unnecessary for a command line tool
+// 仅在verbose模式下输出客户端的错误和警告
+type benchLog struct {
+	verbose bool
+}
+
+func (log *benchLog) print(level string, args ...interface{}) {
+	if log.verbose {
+		fmt.Fprintln(os.Stderr, append([]interface{}{level}, args...)...)
+	}
+}
+
+func (log *benchLog) printf(level string, format string, args ...interface{}) {
+	if log.verbose {
+		fmt.Fprintf(os.Stderr, level+" "+format+"\n", args...)
+	}
+}
+
+func (log *benchLog) Fatal(args ...interface{}) {
+	fmt.Fprintln(os.Stderr, args...)
+	os.Exit(1)
+}
+
+func (log *benchLog) Fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+func (log *benchLog) Panic(args ...interface{}) {
+	panic(fmt.Sprint(args...))
+}
+
+func (log *benchLog) Panicf(format string, args ...interface{}) {
+	panic(fmt.Sprintf(format, args...))
+}
+
+func (log *benchLog) Error(err interface{}) {
+	log.print("ERROR", err)
+}
+
+func (log *benchLog) Errorf(format string, args ...interface{}) {
+	log.printf("ERROR", format, args...)
+}
+
+func (log *benchLog) Warning(args ...interface{}) {
+	log.print("WARN", args...)
+}
+
+func (log *benchLog) Warningf(format string, args ...interface{}) {
+	log.printf("WARN", format, args...)
+}
+
+func (log *benchLog) Info(args ...interface{}) {}
+
+func (log *benchLog) Infof(format string, args ...interface{}) {}
+
+func (log *benchLog) Debug(args ...interface{}) {}
+
+func (log *benchLog) Debugf(format string, args ...interface{}) {}
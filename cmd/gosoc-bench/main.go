@@ -0,0 +1,193 @@
+// Command gosoc-bench is a load-generation tool for GOSOC servers
+//
+// It opens a number of concurrent connections with a ramp-up, authenticates each of them with a templated
+// Connect payload, and then keeps sending a weighted mix of `controller.action` requests until the duration is over.
+// At the end it reports connect latency, request latency percentiles, throughput and error breakdown.
+//
+// Usage:
+//
+//	gosoc-bench -host 127.0.0.1 -port 8080 -c 1000 -ramp 10s -d 1m -scenario scenario.json
+//
+// A scenario file looks like:
+//
+//	{
+//	  "connect": "{\"username\":\"bench{{.Index}}\",\"password\":\"xxx\"}",
+//	  "requests": [
+//	    {"type": "chat.AddMessage", "weight": 9, "payload": {"message": "hello {{.Seq}}"}},
+//	    {"type": "file.Upload", "weight": 1, "payload": {}, "data_size": 4096}
+//	  ]
+//	}
+//
+// Without a scenario file, -type, -payload and -connect describe a single kind of request.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sync"
+	"text/template"
+	"time"
+
+	gosocket "github.com/yankawayu/go-socket"
+)
+
+type benchConfig struct {
+	host        string
+	port        int
+	isTls       bool
+	connections int
+	rampUp      time.Duration
+	duration    time.Duration
+	interval    time.Duration
+	timeout     time.Duration
+	verbose     bool
+}
+
+func main() {
+	config := &benchConfig{}
+	flag.StringVar(&config.host, "host", "127.0.0.1", "server ip")
+	flag.IntVar(&config.port, "port", 8080, "server port")
+	flag.BoolVar(&config.isTls, "tls", false, "connect with tls")
+	flag.IntVar(&config.connections, "c", 100, "number of concurrent connections")
+	flag.DurationVar(&config.rampUp, "ramp", 10*time.Second, "time to open all the connections")
+	flag.DurationVar(&config.duration, "d", 30*time.Second, "total duration of the benchmark, including ramp-up")
+	flag.DurationVar(&config.interval, "interval", time.Second, "interval between requests on each connection, 0 to send as fast as possible")
+	flag.DurationVar(&config.timeout, "timeout", 10*time.Second, "timeout of each connect and request")
+	flag.BoolVar(&config.verbose, "v", false, "print the logs of the clients")
+	scenarioPath := flag.String("scenario", "", "path of the scenario json file")
+	connectInfo := flag.String("connect", "{}", "connect payload template, used without -scenario")
+	requestType := flag.String("type", "", "request type controller.action, used without -scenario")
+	payload := flag.String("payload", "{}", "request payload template, used without -scenario")
+	dataSize := flag.Int("data", 0, "size of random binary data sent with each request, used without -scenario")
+	flag.Parse()
+
+	var scenario *Scenario
+	var err error
+	if *scenarioPath != "" {
+		scenario, err = LoadScenario(*scenarioPath)
+		if err != nil {
+			exit(err)
+		}
+	} else {
+		if *requestType == "" {
+			exit(fmt.Errorf("either -scenario or -type is required"))
+		}
+		scenario = &Scenario{
+			Connect: *connectInfo,
+			Requests: []*RequestSpec{{
+				Type:     *requestType,
+				Payload:  []byte(*payload),
+				DataSize: *dataSize,
+			}},
+		}
+	}
+	if err = scenario.Prepare(); err != nil {
+		exit(err)
+	}
+	if scenario.Connect == "" {
+		scenario.Connect = "{}"
+	}
+	connectTemplate, err := template.New("connect").Parse(scenario.Connect)
+	if err != nil {
+		exit(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.duration)
+	defer cancel()
+	//Stop earlier on Ctrl+C, the report is still printed
+	//Ctrl+C提前结束，仍然输出结果
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt)
+	go func() {
+		<-signalChan
+		cancel()
+	}()
+
+	stats := NewStats()
+	logger := &benchLog{verbose: config.verbose}
+	fmt.Printf("Running %d connections against %s:%d for %v...\n", config.connections, config.host, config.port, config.duration)
+	startTime := time.Now()
+	waitGroup := &sync.WaitGroup{}
+	for i := 0; i < config.connections; i++ {
+		//Spread the connections evenly over the ramp-up time
+		//在预热时间内均匀地建立连接
+		if config.rampUp > 0 && i > 0 {
+			delay := time.Until(startTime.Add(config.rampUp * time.Duration(i) / time.Duration(config.connections)))
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		connectPayload, err := render(connectTemplate, TemplateArgs{Index: i})
+		if err != nil {
+			exit(err)
+		}
+		waitGroup.Add(1)
+		go func(index int, connectPayload string) {
+			defer waitGroup.Done()
+			runConnection(ctx, index, connectPayload, config, scenario, stats, logger)
+		}(i, string(connectPayload))
+	}
+	waitGroup.Wait()
+	stats.Report(os.Stdout, time.Since(startTime))
+}
+
+// Connect and keep sending requests until the ctx is done
+// 建立连接并持续发送请求
+func runConnection(ctx context.Context, index int, connectPayload string, config *benchConfig, scenario *Scenario, stats *Stats, logger gosocket.ILogger) {
+	client := gosocket.NewClient(config.host, config.port, config.isTls, logger, &connectProvider{connectInfo: connectPayload})
+	client.OnAnyPush(func(push *gosocket.Push) {
+		stats.AddPush(push.Type)
+	})
+	connectCtx, cancel := context.WithTimeout(ctx, config.timeout)
+	startTime := time.Now()
+	err := client.ConnectContext(connectCtx)
+	cancel()
+	if err != nil {
+		if ctx.Err() == nil {
+			stats.AddConnect(0, err)
+		}
+		return
+	}
+	stats.AddConnect(time.Since(startTime), nil)
+	defer client.Disconnect()
+
+	random := rand.New(rand.NewSource(time.Now().UnixNano() + int64(index)))
+	for seq := int64(0); ctx.Err() == nil; seq++ {
+		request := scenario.Pick(random)
+		payload, err := request.Render(TemplateArgs{Index: index, Seq: seq})
+		if err != nil {
+			stats.AddRequest(request.Type, 0, err)
+			return
+		}
+		requestCtx, cancel := context.WithTimeout(ctx, config.timeout)
+		startTime = time.Now()
+		err = client.CallWithData(requestCtx, request.Type, payload, request.data, nil)
+		latency := time.Since(startTime)
+		cancel()
+		//The benchmark is over, don't count the unfinished request
+		//压测结束，不统计未完成的请求
+		if err != nil && ctx.Err() != nil {
+			return
+		}
+		stats.AddRequest(request.Type, latency, err)
+		if config.interval > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(config.interval):
+			}
+		}
+	}
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stats collects the results of all connections
+// 统计结果
+type Stats struct {
+	lock sync.Mutex
+
+	connectLatencies []time.Duration
+	requestLatencies []time.Duration
+	requestTypes     map[string]int
+	errors           map[string]int
+	pushes           map[string]int
+}
+
+func NewStats() *Stats {
+	return &Stats{
+		requestTypes: make(map[string]int),
+		errors:       make(map[string]int),
+		pushes:       make(map[string]int),
+	}
+}
+
+func (stats *Stats) AddConnect(latency time.Duration, err error) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	if err != nil {
+		stats.errors["connect: "+err.Error()]++
+		return
+	}
+	stats.connectLatencies = append(stats.connectLatencies, latency)
+}
+
+func (stats *Stats) AddRequest(requestType string, latency time.Duration, err error) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	stats.requestTypes[requestType]++
+	if err != nil {
+		stats.errors[requestType+": "+err.Error()]++
+		return
+	}
+	stats.requestLatencies = append(stats.requestLatencies, latency)
+}
+
+func (stats *Stats) AddPush(pushType string) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	stats.pushes[pushType]++
+}
+
+// Report prints the results
+// 输出统计结果
+func (stats *Stats) Report(writer io.Writer, elapsed time.Duration) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	totalRequests := 0
+	for _, count := range stats.requestTypes {
+		totalRequests += count
+	}
+	totalErrors := 0
+	for _, count := range stats.errors {
+		totalErrors += count
+	}
+	totalPushes := 0
+	for _, count := range stats.pushes {
+		totalPushes += count
+	}
+	fmt.Fprintf(writer, "Duration:    %v\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(writer, "Connections: %d succeeded\n", len(stats.connectLatencies))
+	fmt.Fprintf(writer, "Requests:    %d sent, %d succeeded\n", totalRequests, len(stats.requestLatencies))
+	if elapsed > 0 {
+		fmt.Fprintf(writer, "Throughput:  %.1f req/s\n", float64(len(stats.requestLatencies))/elapsed.Seconds())
+	}
+	fmt.Fprintf(writer, "Pushes:      %d received\n", totalPushes)
+	fmt.Fprintln(writer)
+	fmt.Fprintln(writer, "Connect latency:")
+	writeLatencies(writer, stats.connectLatencies)
+	fmt.Fprintln(writer, "Request latency:")
+	writeLatencies(writer, stats.requestLatencies)
+	if len(stats.requestTypes) > 0 {
+		fmt.Fprintln(writer, "Requests by type:")
+		writeCounts(writer, stats.requestTypes)
+	}
+	if len(stats.pushes) > 0 {
+		fmt.Fprintln(writer, "Pushes by type:")
+		writeCounts(writer, stats.pushes)
+	}
+	if totalErrors > 0 {
+		fmt.Fprintf(writer, "Errors (%d):\n", totalErrors)
+		writeCounts(writer, stats.errors)
+	}
+}
+
+func writeLatencies(writer io.Writer, latencies []time.Duration) {
+	if len(latencies) == 0 {
+		fmt.Fprintln(writer, "  no data")
+		return
+	}
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+	fmt.Fprintf(writer, "  min %v  avg %v  max %v\n", latencies[0], total/time.Duration(len(latencies)), latencies[len(latencies)-1])
+	fmt.Fprintf(writer, "  p50 %v  p90 %v  p95 %v  p99 %v\n",
+		percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 95), percentile(latencies, 99))
+}
+
+// The latencies must be sorted
+func percentile(latencies []time.Duration, p int) time.Duration {
+	index := (len(latencies)*p+99)/100 - 1
+	if index < 0 {
+		index = 0
+	}
+	return latencies[index]
+}
+
+// Write the counts in descending order
+func writeCounts(writer io.Writer, counts map[string]int) {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	for _, key := range keys {
+		fmt.Fprintf(writer, "  %8d  %s\n", counts[key], key)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"text/template"
+)
+
+// Scenario describes what each connection does during the benchmark
+// 压测场景
+type Scenario struct {
+	// Connect is the template of the Connect payload, e.g. {"username":"bench{{.Index}}"}
+	// 连接信息模板
+	Connect string `json:"connect"`
+	// Requests is the weighted mix of requests sent by each connection
+	// 按权重发送的请求
+	Requests []*RequestSpec `json:"requests"`
+}
+
+// RequestSpec describes one kind of `controller.action` request
+type RequestSpec struct {
+	Type     string          `json:"type"`      //controller.action
+	Weight   int             `json:"weight"`    //Relative weight in the mix, 1 if not set
+	Payload  json.RawMessage `json:"payload"`   //JSON payload, it's a template as well
+	DataSize int             `json:"data_size"` //Size of the random binary data sent along with the payload
+	DataFile string          `json:"data_file"` //File used as the binary data, preferred over DataSize
+
+	payloadTemplate *template.Template
+	data            []byte
+}
+
+// TemplateArgs are the fields that can be used in the templates
+// 模板中可以使用的参数
+type TemplateArgs struct {
+	Index int   //Index of the connection, starts from 0
+	Seq   int64 //Sequence of the request on the connection, starts from 0
+}
+
+// Load the scenario from a json file
+// 从json文件中读取场景
+func LoadScenario(path string) (*Scenario, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	scenario := &Scenario{}
+	if err = json.Unmarshal(content, scenario); err != nil {
+		return nil, err
+	}
+	return scenario, nil
+}
+
+// Prepare parses the templates and loads the binary data
+// 解析模板并准备二进制数据
+func (scenario *Scenario) Prepare() error {
+	if len(scenario.Requests) == 0 {
+		return errors.New("scenario has no requests")
+	}
+	for _, request := range scenario.Requests {
+		if request.Type == "" {
+			return errors.New("request type is required")
+		}
+		if request.Weight <= 0 {
+			request.Weight = 1
+		}
+		payload := string(request.Payload)
+		if payload == "" {
+			payload = "{}"
+		}
+		payloadTemplate, err := template.New(request.Type).Parse(payload)
+		if err != nil {
+			return err
+		}
+		request.payloadTemplate = payloadTemplate
+		if request.DataFile != "" {
+			request.data, err = ioutil.ReadFile(request.DataFile)
+			if err != nil {
+				return err
+			}
+		} else if request.DataSize > 0 {
+			request.data = make([]byte, request.DataSize)
+			rand.Read(request.data)
+		}
+	}
+	return nil
+}
+
+// Pick a request randomly according to the weights
+// 按权重随机选择请求
+func (scenario *Scenario) Pick(random *rand.Rand) *RequestSpec {
+	total := 0
+	for _, request := range scenario.Requests {
+		total += request.Weight
+	}
+	n := random.Intn(total)
+	for _, request := range scenario.Requests {
+		if n < request.Weight {
+			return request
+		}
+		n -= request.Weight
+	}
+	return scenario.Requests[len(scenario.Requests)-1]
+}
+
+// Render the payload of the request
+// 生成请求内容
+func (request *RequestSpec) Render(args TemplateArgs) (json.RawMessage, error) {
+	return render(request.payloadTemplate, args)
+}
+
+func render(tmpl *template.Template, args TemplateArgs) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, args); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Connect info provider with the rendered template
+// 根据模板生成连接信息
+type connectProvider struct {
+	connectInfo string
+}
+
+func (provider *connectProvider) GetConnectInfo() string {
+	return provider.connectInfo
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	gosocket "github.com/yankawayu/go-socket"
+)
+
+// The identifiers declared by the generated code itself
+// 生成代码自身使用的标识符
+var reservedNames = map[string]bool{
+	"Client":    true,
+	"NewClient": true,
+}
+
+// generator writes the Go code of the client package from the catalog
+// 根据API目录生成客户端代码
+type generator struct {
+	catalog *gosocket.Catalog
+	pkgName string
+
+	typeNames map[string]string // The Go type names of the definitions
+	usedNames map[string]bool   // All the declared type names
+	imports   map[string]bool   // The packages imported by the types
+	types     bytes.Buffer      // The type declarations
+}
+
+func newGenerator(catalog *gosocket.Catalog, pkgName string) *generator {
+	return &generator{
+		catalog:   catalog,
+		pkgName:   pkgName,
+		typeNames: make(map[string]string),
+		usedNames: make(map[string]bool),
+		imports:   map[string]bool{"context": true},
+	}
+}
+
+func (gen *generator) generate() ([]byte, error) {
+	//Name all the definitions first, they can refer to each other
+	//先为所有定义命名，定义之间可以互相引用
+	defNames := make([]string, 0, len(gen.catalog.Definitions))
+	for defName := range gen.catalog.Definitions {
+		defNames = append(defNames, defName)
+	}
+	sort.Strings(defNames)
+	for _, defName := range defNames {
+		gen.typeNames[defName] = gen.declareName(definitionTypeName(defName))
+	}
+	for _, defName := range defNames {
+		gen.writeStruct(gen.typeNames[defName], gen.catalog.Definitions[defName])
+	}
+
+	var consts, methods bytes.Buffer
+	for _, route := range gen.catalog.Routes {
+		if route.Params == nil {
+			return nil, fmt.Errorf("route %s has no params", route.Type)
+		}
+		methodName := exportedName(route.Controller) + exportedName(route.Action)
+		constName := "Type" + methodName
+		fmt.Fprintf(&consts, "%s = %q\n", constName, route.Type)
+
+		paramType, paramIsStruct := gen.namedType(route.Params, methodName+"Params")
+		if paramIsStruct {
+			paramType = "*" + paramType
+		}
+		fmt.Fprintf(&methods, "\n// %s calls `%s`\n", methodName, route.Type)
+		if len(route.Roles) > 0 {
+			fmt.Fprintf(&methods, "// The user must have one of the roles: %s\n", strings.Join(route.Roles, ", "))
+		}
+		if route.Response == nil {
+			//The response isn't declared, leave it to the caller to decode
+			//未声明返回类型，由调用者自行解析
+			gen.imports["encoding/json"] = true
+			fmt.Fprintf(&methods, "func (client *Client) %s(ctx context.Context, request %s) (response json.RawMessage, err error) {\n", methodName, paramType)
+			fmt.Fprintf(&methods, "err = client.Call(ctx, %s, request, &response)\nreturn\n}\n", constName)
+			continue
+		}
+		responseType, responseIsStruct := gen.namedType(route.Response, methodName+"Response")
+		if responseIsStruct {
+			fmt.Fprintf(&methods, "func (client *Client) %s(ctx context.Context, request %s) (response *%s, err error) {\n", methodName, paramType, responseType)
+			fmt.Fprintf(&methods, "response = &%s{}\nif err = client.Call(ctx, %s, request, response); err != nil {\nreturn nil, err\n}\nreturn\n}\n", responseType, constName)
+		} else {
+			fmt.Fprintf(&methods, "func (client *Client) %s(ctx context.Context, request %s) (response %s, err error) {\n", methodName, paramType, responseType)
+			fmt.Fprintf(&methods, "err = client.Call(ctx, %s, request, &response)\nreturn\n}\n", constName)
+		}
+	}
+
+	var code bytes.Buffer
+	code.WriteString("// Code generated by gosoc-gen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&code, "// Package %s is the typed client of the GOSOC server\n", gen.pkgName)
+	fmt.Fprintf(&code, "package %s\n\n", gen.pkgName)
+	code.WriteString("import (\n")
+	imports := make([]string, 0, len(gen.imports))
+	for pkg := range gen.imports {
+		imports = append(imports, pkg)
+	}
+	sort.Strings(imports)
+	for _, pkg := range imports {
+		fmt.Fprintf(&code, "%q\n", pkg)
+	}
+	code.WriteString("\ngosocket \"github.com/yankawayu/go-socket\"\n)\n\n")
+	code.WriteString("// The payload types of the routes\nconst (\n")
+	code.Write(consts.Bytes())
+	code.WriteString(")\n\n")
+	code.WriteString("// Client calls the routes with typed params and responses\n")
+	code.WriteString("// It embeds *gosocket.Client, which is still used for connecting and receiving pushes\n")
+	code.WriteString("type Client struct {\n*gosocket.Client\n}\n\n")
+	code.WriteString("// NewClient wraps the client, it can be called before or after the client connects\n")
+	code.WriteString("func NewClient(client *gosocket.Client) *Client {\nreturn &Client{Client: client}\n}\n")
+	code.Write(methods.Bytes())
+	code.Write(gen.types.Bytes())
+	formatted, err := format.Source(code.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%v\n%s", err, code.Bytes())
+	}
+	return formatted, nil
+}
+
+// Get the type of the params or the response of a route, an inline object is declared as a named struct
+// Returns whether the type is a struct, which is passed by pointer
+// 获取参数或返回数据的类型，内联的对象声明为具名结构体，同时返回是否为结构体
+func (gen *generator) namedType(schema *gosocket.Schema, nameHint string) (string, bool) {
+	if schema.Ref != "" {
+		return gen.refType(schema.Ref), true
+	}
+	if isStruct(schema) {
+		name := gen.declareName(nameHint)
+		gen.writeStruct(name, schema)
+		return name, true
+	}
+	return gen.goType(schema), false
+}
+
+// Get the Go type of the schema
+// 获取Schema对应的Go类型
+func (gen *generator) goType(schema *gosocket.Schema) string {
+	if schema.Ref != "" {
+		return "*" + gen.refType(schema.Ref)
+	}
+	switch schema.Type {
+	case "string":
+		switch schema.Format {
+		case "date-time":
+			gen.imports["time"] = true
+			return "time.Time"
+		case "byte":
+			return "[]byte"
+		}
+		return "string"
+	case "integer":
+		return "int64"
+	case "number":
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "array":
+		if schema.Items == nil {
+			return "[]interface{}"
+		}
+		return "[]" + strings.TrimPrefix(gen.goType(schema.Items), "*")
+	case "object":
+		if isStruct(schema) {
+			var body bytes.Buffer
+			gen.writeFields(&body, schema)
+			return "struct {\n" + body.String() + "}"
+		}
+		if schema.AdditionalProperties != nil {
+			return "map[string]" + gen.goType(schema.AdditionalProperties)
+		}
+		return "struct{}"
+	}
+	//It can be anything
+	//任意类型
+	gen.imports["encoding/json"] = true
+	return "json.RawMessage"
+}
+
+func (gen *generator) refType(ref string) string {
+	defName := strings.TrimPrefix(ref, "#/definitions/")
+	if name, ok := gen.typeNames[defName]; ok {
+		return name
+	}
+	panic("definition " + defName + " not found")
+}
+
+func (gen *generator) writeStruct(name string, schema *gosocket.Schema) {
+	fmt.Fprintf(&gen.types, "\ntype %s struct {\n", name)
+	gen.writeFields(&gen.types, schema)
+	gen.types.WriteString("}\n")
+}
+
+func (gen *generator) writeFields(buffer *bytes.Buffer, schema *gosocket.Schema) {
+	required := make(map[string]bool)
+	for _, name := range schema.Required {
+		required[name] = true
+	}
+	jsonNames := make([]string, 0, len(schema.Properties))
+	for jsonName := range schema.Properties {
+		jsonNames = append(jsonNames, jsonName)
+	}
+	sort.Strings(jsonNames)
+	fieldNames := make(map[string]bool)
+	for _, jsonName := range jsonNames {
+		fieldName := exportedName(jsonName)
+		for i := 2; fieldNames[fieldName]; i++ {
+			fieldName = exportedName(jsonName) + strconv.Itoa(i)
+		}
+		fieldNames[fieldName] = true
+		tag := jsonName
+		if !required[jsonName] {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(buffer, "%s %s `json:%q`\n", fieldName, gen.goType(schema.Properties[jsonName]), tag)
+	}
+}
+
+// Declare a unique type name
+// 声明不重复的类型名
+func (gen *generator) declareName(name string) string {
+	result := name
+	for i := 2; gen.usedNames[result] || reservedNames[result]; i++ {
+		result = name + strconv.Itoa(i)
+	}
+	gen.usedNames[result] = true
+	return result
+}
+
+// An object with properties is generated as a struct, the definitions are always structs
+// 有属性的对象生成为结构体
+func isStruct(schema *gosocket.Schema) bool {
+	return schema.Type == "object" && schema.AdditionalProperties == nil
+}
+
+// The definition name is the type name, or the package path and the type name if there is a conflict
+// 定义名为类型名，冲突时带有包路径
+func definitionTypeName(defName string) string {
+	parts := strings.Split(defName, ".")
+	if len(parts) == 1 {
+		return exportedName(defName)
+	}
+	return exportedName(parts[len(parts)-2]) + exportedName(parts[len(parts)-1])
+}
+
+// Convert the name into an exported identifier, e.g. message_id -> MessageId
+// 转换为导出的标识符
+func exportedName(name string) string {
+	var builder strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		builder.WriteRune(r)
+	}
+	result := builder.String()
+	if result == "" || unicode.IsDigit(rune(result[0])) {
+		result = "X" + result
+	}
+	return result
+}
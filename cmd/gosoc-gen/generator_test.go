@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// Run `go test -update` in this directory to rewrite the golden file after an intended change of the output
+var update = flag.Bool("update", false, "update the golden file")
+
+// The golden file is also a package, so that the generated code can be compiled
+const goldenPath = "testdata/client/client.go"
+
+func TestGenerateGolden(t *testing.T) {
+	//The catalog written by the golden test of the catalog, so that the generator is tested on the real output
+	catalog, err := readCatalog(filepath.Join("..", "..", "testdata", "catalog.golden.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := newGenerator(catalog, "client").generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		if err := ioutil.WriteFile(goldenPath, code, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	golden, err := ioutil.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(code, golden) {
+		t.Fatalf("the output differs from %s, run with -update if the change is intended:\n%s", goldenPath, code)
+	}
+}
+
+func TestGeneratedPackageCompiles(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("the go tool isn't found")
+	}
+	//The packages under testdata are skipped by ./..., so it's vetted by its path, which also type-checks it
+	output, err := exec.Command(goTool, "vet", "./"+filepath.Dir(goldenPath)).CombinedOutput()
+	if err != nil {
+		t.Fatalf("the generated package doesn't compile: %v\n%s", err, output)
+	}
+}
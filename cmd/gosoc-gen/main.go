@@ -0,0 +1,69 @@
+// Command gosoc-gen generates a typed Go client package from the API catalog of a GOSOC server
+//
+// The catalog is written by the server binary, see gosocket.RunCatalogCommand. The generated package has
+// the types of the params and the responses, and a Client with one method per `controller.action`:
+//
+//	func (client *Client) ChatAddMessage(ctx context.Context, request *AddMessageReqBody) (*AddMessageRespBody, error)
+//
+// Usage:
+//
+//	go run ./examples catalog -o catalog.json
+//	gosoc-gen -catalog catalog.json -pkg chatapi -o chatapi/client.go
+//
+// Regenerate the package whenever the routes change, a removed or renamed route breaks the build of its callers.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	gosocket "github.com/yankawayu/go-socket"
+)
+
+func main() {
+	catalogPath := flag.String("catalog", "catalog.json", "the catalog file written by the server, - means stdin")
+	pkgName := flag.String("pkg", "api", "the package name of the generated code")
+	outPath := flag.String("o", "-", "the file to write the generated code into, - means stdout")
+	flag.Parse()
+
+	catalog, err := readCatalog(*catalogPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "read catalog:", err)
+		os.Exit(1)
+	}
+	code, err := newGenerator(catalog, *pkgName).generate()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "generate:", err)
+		os.Exit(1)
+	}
+	if *outPath == "-" {
+		_, err = os.Stdout.Write(code)
+	} else {
+		err = ioutil.WriteFile(*outPath, code, 0644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "write:", err)
+		os.Exit(1)
+	}
+}
+
+func readCatalog(path string) (*gosocket.Catalog, error) {
+	var reader io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+	catalog := &gosocket.Catalog{}
+	if err := json.NewDecoder(reader).Decode(catalog); err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
@@ -0,0 +1,107 @@
+// Code generated by gosoc-gen. DO NOT EDIT.
+
+// Package client is the typed client of the GOSOC server
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	gosocket "github.com/yankawayu/go-socket"
+)
+
+// The payload types of the routes
+const (
+	TypeCatalogDelete = "catalog.Delete"
+	TypeCatalogList   = "catalog.List"
+	TypeCatalogPost   = "catalog.Post"
+	TypeTypedGreet    = "typed.Greet"
+)
+
+// Client calls the routes with typed params and responses
+// It embeds *gosocket.Client, which is still used for connecting and receiving pushes
+type Client struct {
+	*gosocket.Client
+}
+
+// NewClient wraps the client, it can be called before or after the client connects
+func NewClient(client *gosocket.Client) *Client {
+	return &Client{Client: client}
+}
+
+// CatalogDelete calls `catalog.Delete`
+// The user must have one of the roles: admin
+func (client *Client) CatalogDelete(ctx context.Context, request *CatalogPostResp) (response json.RawMessage, err error) {
+	err = client.Call(ctx, TypeCatalogDelete, request, &response)
+	return
+}
+
+// CatalogList calls `catalog.List`
+func (client *Client) CatalogList(ctx context.Context, request *CatalogListParam) (response []CatalogPost, err error) {
+	err = client.Call(ctx, TypeCatalogList, request, &response)
+	return
+}
+
+// CatalogPost calls `catalog.Post`
+// The user must have one of the roles: admin, editor
+func (client *Client) CatalogPost(ctx context.Context, request *CatalogPost) (response *CatalogPostResp, err error) {
+	response = &CatalogPostResp{}
+	if err = client.Call(ctx, TypeCatalogPost, request, response); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// TypedGreet calls `typed.Greet`
+// The user must have one of the roles: user
+func (client *Client) TypedGreet(ctx context.Context, request *TypedReq) (response *TypedResp, err error) {
+	response = &TypedResp{}
+	if err = client.Call(ctx, TypeTypedGreet, request, response); err != nil {
+		return nil, err
+	}
+	return
+}
+
+type CatalogAuthor struct {
+	Email string `json:"email,omitempty"`
+	Id    string `json:"id,omitempty"`
+	Name  string `json:"name"`
+}
+
+type CatalogListParam struct {
+	Filter struct {
+		Kind string `json:"kind,omitempty"`
+	} `json:"filter,omitempty"`
+	Page int64 `json:"page,omitempty"`
+}
+
+type CatalogPost struct {
+	Any     json.RawMessage  `json:"any,omitempty"`
+	Attrs   map[string]int64 `json:"attrs,omitempty"`
+	Author  *CatalogAuthor   `json:"author"`
+	Code    string           `json:"code,omitempty"`
+	Cover   []byte           `json:"cover,omitempty"`
+	Created time.Time        `json:"created,omitempty"`
+	Extra   json.RawMessage  `json:"extra,omitempty"`
+	Kind    string           `json:"kind,omitempty"`
+	Level   int64            `json:"level,omitempty"`
+	Replies []CatalogPost    `json:"replies,omitempty"`
+	Score   float64          `json:"score,omitempty"`
+	Tags    []string         `json:"tags,omitempty"`
+	Title   string           `json:"title"`
+	Version int64            `json:"version,omitempty"`
+}
+
+type CatalogPostResp struct {
+	Id int64 `json:"id,omitempty"`
+}
+
+type TypedReq struct {
+	Fail string `json:"fail,omitempty"`
+	Name string `json:"name"`
+}
+
+type TypedResp struct {
+	Greeting string `json:"greeting,omitempty"`
+}
@@ -0,0 +1,53 @@
+package gosocket
+
+import (
+	"context"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// contextKey is the type of the keys of the values stored in the request context
+// 请求上下文中存值用的key类型
+type contextKey int
+
+const (
+	kContextKeyApp contextKey = iota
+	kContextKeyConnId
+	kContextKeyMessageId
+	kContextKeyTraceId
+)
+
+// AppFromContext returns the App handling the request
+// 获取处理请求的App
+func AppFromContext(ctx context.Context) *App {
+	app, _ := ctx.Value(kContextKeyApp).(*App)
+	return app
+}
+
+// ConnIdFromContext returns the unique id of the connection which the request comes from
+// It's empty if the request doesn't come from a connection
+// 获取请求所属连接的唯一id
+func ConnIdFromContext(ctx context.Context) string {
+	connId, _ := ctx.Value(kContextKeyConnId).(string)
+	return connId
+}
+
+// MessageIdFromContext returns the id of the SendReq message, which is only unique in the connection
+// 获取请求消息的id，仅在同一连接中唯一
+func MessageIdFromContext(ctx context.Context) (messageId uint16, ok bool) {
+	messageId, ok = ctx.Value(kContextKeyMessageId).(uint16)
+	return
+}
+
+// TraceIdFromContext returns the unique id of the request, which is also written into the access log
+// 获取请求的唯一id，访问日志中也会记录
+func TraceIdFromContext(ctx context.Context) string {
+	traceId, _ := ctx.Value(kContextKeyTraceId).(string)
+	return traceId
+}
+
+// Create a random unique id
+// 生成随机的唯一id
+func newUniqueId() string {
+	return uuid.Must(uuid.NewV4()).String()
+}
@@ -1,40 +1,25 @@
 package gosocket
 
 import (
+	"context"
 	"encoding/json"
 	"reflect"
 	"strings"
+	"time"
 )
 
-// Go language can't create instance from class name dynamically
-// therefore I use a map to reflect the string to the class here
-// Just use the Router function to add a new route with a new controller
-// 由于Go无法动态创建类型，故使用map将字符串映射到类型
-var (
-	controllerMap map[string]IController
-)
-
-// The max payload length is 16kb
-// 最大请求长度
+// The default max payload length is 16kb, see AppConfig.MaxPayloadLength
+// 默认最大请求长度
 const (
 	kMaxPayloadLength = (1 << 14) - 1
 )
 
-func init() {
-	controllerMap = make(map[string]IController)
-}
-
-// Router Register a controller to its corresponding name
-// 注册Controller以及对应的名字
-func Router(controllerName string, controller IController) {
-	controllerMap[controllerName] = controller
-}
-
 // These are all the status codes that embedded in ResponseBody
 // 返回状态码
 const (
 	StatusSuccess = Status(iota)
 
+	StatusForbidden     = Status(3) //The user doesn't have the roles required by the action
 	StatusError         = Status(4) //Format error, always return with a message
 	StatusInternalError = Status(5) //Unknown internal error, always found with a log record under runtime directory
 )
@@ -60,11 +45,42 @@ type IController interface {
 	AfterAction(data *ResponseBody)
 }
 
+// IContextController can be implemented by controllers to receive the context of the request
+// It's implemented by Controller, so it's unnecessary to implement it by yourself
+// 接收请求上下文的Controller接口，Controller基类已实现
+type IContextController interface {
+	SetContext(ctx context.Context)
+}
+
+// IActionTimeout can be implemented by controllers to set deadlines for their actions
+// The context of the request is cancelled once the deadline passes, it overrides AppConfig.ActionTimeout
+// 设置action的超时时间，超时后请求上下文被取消，优先于AppConfig.ActionTimeout
+type IActionTimeout interface {
+	GetActionTimeoutMap() map[string]time.Duration
+}
+
+// IActionRole can be implemented by controllers to declare the roles required by their actions
+// The user must have at least one of the roles of the action, see IRoleUser
+// Actions not in the map can be accessed by any logged-in user
+// 声明action所需的角色，用户至少拥有其中一个才能访问，不在map中的action不受限制
+type IActionRole interface {
+	GetActionRoleMap() map[string][]string
+}
+
+// IActionResponse can be implemented by controllers to declare the types of the response data of their actions
+// The types are only used by the catalog, see App.Catalog
+// 声明action返回数据的类型，仅用于生成API目录
+type IActionResponse interface {
+	GetActionResponseMap() map[string]interface{}
+}
+
 // Controller the base class of all controllers
 // Controller基类，用于共同的属性和方法
 type Controller struct {
 	User IUser
 	Data []byte
+
+	ctx context.Context
 }
 
 func (controller *Controller) Init(user IUser, data []byte) {
@@ -72,6 +88,23 @@ func (controller *Controller) Init(user IUser, data []byte) {
 	controller.Data = data
 }
 
+// SetContext set the context of the request
+// 设置请求上下文
+func (controller *Controller) SetContext(ctx context.Context) {
+	controller.ctx = ctx
+}
+
+// Context returns the context of the request
+// It's cancelled once the client disconnects, the server stops or the deadline of the action passes
+// Pass it to the downstream calls so that they stop with the request
+// 请求上下文，连接断开、服务器停止或action超时后被取消，应传给下游调用
+func (controller *Controller) Context() context.Context {
+	if controller.ctx == nil {
+		return context.Background()
+	}
+	return controller.ctx
+}
+
 // BeforeAction run before the action
 // action之前执行
 func (controller *Controller) BeforeAction(paramStr string) {}
@@ -80,13 +113,20 @@ func (controller *Controller) BeforeAction(paramStr string) {}
 // action之后执行
 func (controller *Controller) AfterAction(data *ResponseBody) {}
 
-func ProcessPayload(user IUser, payloadType string, payload string) (response *ResponseBody) {
-	return ProcessPayloadWithData(user, payloadType, payload, nil)
+func (app *App) ProcessPayload(user IUser, payloadType string, payload string) (response *ResponseBody) {
+	return app.ProcessPayloadWithData(user, payloadType, payload, nil)
 }
 
 // ProcessPayloadWithData process the request payload
 // This function will match the request to a certain action under the controller by reflecting
-func ProcessPayloadWithData(user IUser, payloadType string, payload string, data []byte) (response *ResponseBody) {
+func (app *App) ProcessPayloadWithData(user IUser, payloadType string, payload string, data []byte) (response *ResponseBody) {
+	return app.ProcessPayloadWithContext(context.Background(), user, payloadType, payload, data)
+}
+
+// ProcessPayloadWithContext is the same as ProcessPayloadWithData except that the ctx is passed to the controller
+// A trace id is added to the ctx if there isn't one
+// 与ProcessPayloadWithData相同，ctx会传给Controller
+func (app *App) ProcessPayloadWithContext(ctx context.Context, user IUser, payloadType string, payload string, data []byte) (response *ResponseBody) {
 	defer func() {
 		var message = "Internal BackEnd error"
 		var status = StatusError
@@ -94,13 +134,16 @@ func ProcessPayloadWithData(user IUser, payloadType string, payload string, data
 			//If an error implemented the `IUserError` interface, then it is a user customize error
 			//Just read the message from `ShowError` function
 			//如果是用户自定义错误，直接返回错误内容
-			if userError, ok := r.(IUserError); ok {
+			if actionError, ok := r.(*ActionError); ok {
+				status = actionError.Status
+				message = actionError.Message
+			} else if userError, ok := r.(IUserError); ok {
 				message = userError.ShowError()
 			} else {
 				//All the other errors need to be logged
 				//其他错误需要记录日志
 				err := getRecoverError(r)
-				TcpApp.Log.Error(err)
+				app.Log.Error(err)
 				status = StatusInternalError
 			}
 			response = nil
@@ -114,19 +157,48 @@ func ProcessPayloadWithData(user IUser, payloadType string, payload string, data
 			}
 		}
 	}()
-	if len(payload) > kMaxPayloadLength {
-		raiseError("length of payload exceeds the max length")
-	}
 	//Parse the payload type
 	//解析type
 	strs := strings.Split(payloadType, ".")
 	if len(strs) < 2 {
 		raiseError("payload type should be in the format of `controller.action`")
 	}
-	controllerName := strings.ToLower(strs[0])
-	actionName := strs[1]
+	if len(payload) > app.maxPayloadLength(user, strings.ToLower(strs[0])+"."+strs[1]) {
+		raiseError("length of payload exceeds the max length")
+	}
+	//Values of the request
+	//请求上下文中的值
+	ctx = context.WithValue(ctx, kContextKeyApp, app)
+	if TraceIdFromContext(ctx) == "" {
+		ctx = context.WithValue(ctx, kContextKeyTraceId, newUniqueId())
+	}
+	request := &Request{
+		Ctx:        ctx,
+		User:       user,
+		Type:       payloadType,
+		Controller: strings.ToLower(strs[0]),
+		Action:     strs[1],
+		Payload:    payload,
+		Data:       data,
+	}
+	//Run the middlewares before the action
+	//依次经过中间件后执行action
+	response = app.wrapMiddlewares(request.Controller, app.dispatch)(request)
+	return
+}
+
+// dispatch match the request to a certain action under the controller by reflecting and run it
+// 通过反射找到对应的action并执行
+func (app *App) dispatch(request *Request) (response *ResponseBody) {
+	controllerName := request.Controller
+	actionName := request.Action
+	//The typed handlers registered by Handle
+	//通过Handle注册的处理函数
+	if handler := app.handlerMap[controllerName+"."+actionName]; handler != nil {
+		return app.dispatchTyped(request, handler)
+	}
 	//Get type of the controller
-	controllerPtr := controllerMap[controllerName]
+	controllerPtr := app.controllerMap[controllerName]
 	if controllerPtr == nil {
 		raiseError("controller:" + controllerName + "not exist")
 	}
@@ -140,7 +212,14 @@ func ProcessPayloadWithData(user IUser, payloadType string, payload string, data
 		panic("controller is not IController")
 	}
 	//Initialize controller
-	execController.Init(user, data)
+	execController.Init(request.User, request.Data)
+	//Check whether the user has the roles required by the action
+	//检查用户是否拥有action所需的角色
+	if roleController, ok := execController.(IActionRole); ok {
+		if roles := roleController.GetActionRoleMap()[actionName]; len(roles) > 0 && !hasAnyRole(request.User, roles) {
+			return permissionDenied()
+		}
+	}
 
 	//Get action&param map from child controller
 	paramMap := execController.GetActionParamMap()
@@ -157,13 +236,22 @@ func ProcessPayloadWithData(user IUser, payloadType string, payload string, data
 	paramVal := reflect.New(paramType)
 	paramInt := paramVal.Interface()
 	//Only if payload exists
-	if len(payload) > 0 {
+	if len(request.Payload) > 0 {
 		//Decode payload into param
-		err := json.Unmarshal([]byte(payload), paramInt)
+		err := json.Unmarshal([]byte(request.Payload), paramInt)
 		if err != nil {
 			raiseError("Failed to decode payload into param for action:" + actionName + " in controller:" + controllerName)
 		}
 	}
+	//Validate the param by the `validate` tags
+	//根据validate tag校验参数
+	if errs := Validate(paramInt); len(errs) > 0 {
+		return &ResponseBody{
+			Status:  StatusError,
+			Message: errs.Error(),
+			Data:    errs,
+		}
+	}
 	//Initialize response body
 	response = &ResponseBody{}
 	//Default error
@@ -175,11 +263,63 @@ func ProcessPayloadWithData(user IUser, payloadType string, payload string, data
 	if !method.IsValid() {
 		raiseError("Action:" + actionName + " in controller:" + controllerName + " not found")
 	}
+	//Set the context with the deadline of the action
+	//设置请求上下文及超时时间
+	if contextController, ok := execController.(IContextController); ok {
+		ctx := request.Ctx
+		if timeout := app.actionTimeout(execController, actionName); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		contextController.SetContext(ctx)
+	}
 	//before hook
-	execController.BeforeAction(payload)
+	execController.BeforeAction(request.Payload)
 	//run action
 	method.Call([]reflect.Value{paramVal, responseVal})
 	//after hook
 	execController.AfterAction(response)
 	return
 }
+
+// Get the deadline of the action, 0 means no deadline
+// 获取action的超时时间，0表示不超时
+func (app *App) actionTimeout(controller IController, actionName string) time.Duration {
+	if timeoutController, ok := controller.(IActionTimeout); ok {
+		if timeout, ok := timeoutController.GetActionTimeoutMap()[actionName]; ok {
+			return timeout
+		}
+	}
+	if app.Config == nil {
+		return 0
+	}
+	return app.Config.ActionTimeout
+}
+
+// The response of the requests rejected by the role check
+// 角色检查未通过时的返回数据
+func permissionDenied() *ResponseBody {
+	return &ResponseBody{
+		Status:  StatusForbidden,
+		Message: "permission denied",
+		Data:    struct{}{},
+	}
+}
+
+// Whether the user has at least one of the roles
+// 用户是否拥有其中一个角色
+func hasAnyRole(user IUser, roles []string) bool {
+	roleUser, ok := user.(IRoleUser)
+	if !ok {
+		return false
+	}
+	for _, userRole := range roleUser.GetRoles() {
+		for _, role := range roles {
+			if userRole == role {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package gosocket
+
+import (
+	"net"
+)
+
+// Engine is the way the connections are served, see AppConfig.Engine
+// 连接的服务方式
+type Engine uint8
+
+const (
+	// EngineGoroutine serves each connection with a reading, a writing and a handling thread, it's the default one
+	//每个连接使用读、写、处理三个线程，默认引擎
+	EngineGoroutine Engine = iota
+	// EngineEpoll waits for the readable connections by epoll and decodes them without a reading thread,
+	// the messages are handled and written by shared worker pools. It's only supported on linux, and the tls connections
+	// are still served by EngineGoroutine
+	//通过epoll等待可读的连接，无需读线程，消息交给共享的工作协程池处理及发出
+	//仅支持linux，TLS连接仍使用EngineGoroutine
+	EngineEpoll
+)
+
+func (engine Engine) String() string {
+	switch engine {
+	case EngineGoroutine:
+		return "goroutine"
+	case EngineEpoll:
+		return "epoll"
+	}
+	return "unknown"
+}
+
+// The default number of the workers shared by the connections of EngineEpoll
+// EngineEpoll默认的工作协程数
+const kDefaultEngineWorkers = 256
+
+// connEngine serves the accepted connections instead of ClientConn
+// 代替ClientConn为连接提供服务
+type connEngine interface {
+	// Serve the connection, returns false if it can't, then the connection is served by ClientConn
+	//为连接提供服务，不支持时返回false，交给ClientConn
+	serve(conn net.Conn) bool
+	// Stop the engine after all the connections are closed
+	//所有连接关闭后停止引擎
+	shutdown()
+}
+
+// Create the engine selected by the config, returns nil for EngineGoroutine
+// 根据配置创建引擎，EngineGoroutine返回nil
+func (app *App) newConnEngine(tlsEnable bool) connEngine {
+	if app.Config == nil || app.Config.Engine != EngineEpoll {
+		return nil
+	}
+	if tlsEnable {
+		app.Log.Warning("the epoll engine doesn't support tls, fall back to the goroutine engine")
+		return nil
+	}
+	return app.newPollEngine()
+}
+
+func (app *App) engineWorkers() int {
+	if app.Config == nil || app.Config.EngineWorkers <= 0 {
+		return kDefaultEngineWorkers
+	}
+	return app.Config.EngineWorkers
+}
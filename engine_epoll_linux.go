@@ -0,0 +1,791 @@
+//go:build linux
+// +build linux
+
+package gosocket
+
+import (
+	"bytes"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yankawayu/go-socket/packet"
+)
+
+const (
+	kPollReadBufferSize = 64 * 1024        // The read buffer shared by the connections of a poller
+	kPollEventCount     = 256              // The max number of events returned by one epoll_wait
+	kPollWaitTimeout    = 1000             // The timeout of epoll_wait in milliseconds, so that the shutdown is noticed
+	kPollWriteTimeout   = 10 * time.Second // Give up writing to a client that doesn't read
+	kPollFrameOverhead  = 4 * 1024         // The room for the header, the message id and the payload type of a frame besides the payload
+)
+
+// pollEngine serves the connections with epoll, see EngineEpoll
+// A connection costs no goroutine while it's idle:
+// the pollers read and decode the readable connections, the workers handle their messages in order,
+// and the writers write the messages queued to them
+// epoll引擎，空闲连接不占用协程：poller读取并解码可读的连接，工作协程按顺序处理其消息，写协程发出队列中的消息
+type pollEngine struct {
+	app     *App
+	pollers []*poller
+	next    uint32 // Assign the connections to the pollers in turn
+
+	handling *pollQueue // The connections with messages to handle
+	writing  *pollQueue // The connections with messages to write
+
+	//The max frame length allowed by the limits of the app and the actions, see maxFrameLength
+	//App及action的限制所允许的最大帧长度
+	frameLimit int
+
+	pollerGroup sync.WaitGroup
+	done        chan struct{} // Closed on shutdown
+	sweepBuf    []*pollConn   // Reused by sweep
+}
+
+// poller waits for the readable connections by its own epoll instance
+// 每个poller使用独立的epoll实例
+type poller struct {
+	engine *pollEngine
+	epfd   int
+	buf    []byte // The read buffer shared by the connections, only used by the poller goroutine
+
+	lock  sync.Mutex
+	conns map[int]*pollConn
+}
+
+// pollConn is a connection served by the pollEngine, the counterpart of ClientConn
+// 由pollEngine服务的连接，相当于ClientConn
+type pollConn struct {
+	poller     *poller
+	conn       net.Conn // The Connection returned by the Listener
+	fd         int
+	clientIp   string
+	handler    *MessageHandler
+	msgManager *packet.MessageManager
+
+	readLock sync.Mutex // Make sure the fd isn't closed and reused while reading, or the socket is closed twice
+	pending  []byte     // The partial message read so far, grown in place, only used by the poller goroutine
+	lastRead int64      // The time of the last read in unix nanoseconds
+	timeout  int64      // The read timeout in nanoseconds derived from the keep alive time, 0 means no timeout
+	closed   int32      // Whether the connection is closed
+
+	lock      sync.Mutex
+	messages  []packet.IMessage // The messages waiting to be handled
+	scheduled bool              // Whether the connection is queued or being handled by a worker
+	paused    bool              // Whether the reading is paused by PolicyBlock
+
+	outLock         sync.Mutex
+	outbox          []pollJob     // The messages waiting to be written, bounded by AppConfig.OutboundPolicy
+	flushing        bool          // Whether the connection is queued or being written by a writer
+	drained         chan struct{} // Closed once the writer takes the outbox, waited by PolicyBlock
+	closeAfterFlush bool          // Close the socket once the outbox is written, so that the last messages such as Disconnect are sent
+}
+
+// pollJob is a message in the outbox of a pollConn
+// pollConn发出队列中的消息
+type pollJob struct {
+	message packet.IMessage
+	receipt *Receipt
+	key     string // The key of PolicyCoalesce, empty if it can't be replaced
+}
+
+// pollQueue hands the connections to a pool of workers without blocking the caller
+// A connection is queued once until the worker has drained it, so the overflow is bounded by the number of the connections
+// 将连接交给工作协程池且不阻塞调用者，每个连接被处理完之前只入队一次，因此overflow不超过连接数
+type pollQueue struct {
+	tasks chan *pollConn
+	group sync.WaitGroup
+
+	//The connections queued while the tasks are full
+	//tasks满时入队的连接
+	lock     sync.Mutex
+	overflow []*pollConn
+}
+
+func newPollQueue(length int, workers int, run func(client *pollConn)) *pollQueue {
+	queue := &pollQueue{
+		tasks: make(chan *pollConn, length),
+	}
+	for i := 0; i < workers; i++ {
+		queue.group.Add(1)
+		go queue.work(run)
+	}
+	return queue
+}
+
+// Run the connections in the tasks, and the ones in the overflow after each task
+// The tasks queued before the overflow make sure it's drained
+// 处理tasks中的连接，每处理完一个就检查overflow，加入overflow时tasks已满，保证overflow会被处理
+func (queue *pollQueue) work(run func(client *pollConn)) {
+	defer queue.group.Done()
+	for client := range queue.tasks {
+		run(client)
+		for overflowed := queue.pop(); overflowed != nil; overflowed = queue.pop() {
+			run(overflowed)
+		}
+	}
+}
+
+func (queue *pollQueue) push(client *pollConn) {
+	select {
+	case queue.tasks <- client:
+	default:
+		queue.lock.Lock()
+		queue.overflow = append(queue.overflow, client)
+		queue.lock.Unlock()
+	}
+}
+
+func (queue *pollQueue) pop() *pollConn {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	if len(queue.overflow) == 0 {
+		return nil
+	}
+	client := queue.overflow[0]
+	queue.overflow[0] = nil
+	queue.overflow = queue.overflow[1:]
+	if len(queue.overflow) == 0 {
+		queue.overflow = nil
+	}
+	return client
+}
+
+// Stop the workers once the tasks are done
+// 完成任务后停止工作协程
+func (queue *pollQueue) close() {
+	close(queue.tasks)
+	queue.group.Wait()
+}
+
+func (app *App) newPollEngine() connEngine {
+	engine := &pollEngine{
+		app:        app,
+		done:       make(chan struct{}),
+		frameLimit: app.maxPayloadLength(nil, ""),
+	}
+	//The actions can allow bigger payloads than the app
+	//action允许的请求长度可以大于App的限制
+	for _, limit := range app.payloadLimits {
+		if limit > engine.frameLimit {
+			engine.frameLimit = limit
+		}
+	}
+	engine.frameLimit += kPollFrameOverhead
+	for i := 0; i < runtime.NumCPU(); i++ {
+		epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+		if err != nil {
+			//Stop the pollers created already
+			//停止已经创建的poller
+			app.Log.Error(errors.Wrap(err, "create epoll"))
+			engine.shutdown()
+			return nil
+		}
+		engine.pollers = append(engine.pollers, &poller{
+			engine: engine,
+			epfd:   epfd,
+			buf:    make([]byte, kPollReadBufferSize),
+			conns:  make(map[int]*pollConn),
+		})
+	}
+	engine.handling = newPollQueue(app.queueLength()*16, app.engineWorkers(), (*pollConn).process)
+	engine.writing = newPollQueue(app.queueLength()*16, app.engineWorkers(), (*pollConn).flush)
+	for _, poller := range engine.pollers {
+		engine.pollerGroup.Add(1)
+		go poller.run()
+	}
+	engine.pollerGroup.Add(1)
+	go engine.startSweeper()
+	return engine
+}
+
+// Serve the tcp connections, the others such as the in-memory ones are left to ClientConn
+// 仅服务tcp连接，其他连接如内存连接交给ClientConn
+func (engine *pollEngine) serve(conn net.Conn) bool {
+	netConn := conn
+	if connection, ok := conn.(*Connection); ok {
+		netConn = connection.Conn
+	}
+	tcpConn, ok := netConn.(*net.TCPConn)
+	if !ok {
+		return false
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return false
+	}
+	fd := -1
+	if err = rawConn.Control(func(f uintptr) {
+		fd = int(f)
+	}); err != nil || fd < 0 {
+		return false
+	}
+	client := engine.app.newPollConn(conn, fd)
+	client.poller = engine.pollers[atomic.AddUint32(&engine.next, 1)%uint32(len(engine.pollers))]
+	if err = client.poller.add(client); err != nil {
+		engine.app.Log.Error(errors.Wrap(err, client.clientIp))
+		client.close()
+	}
+	return true
+}
+
+// Stop the pollers and the workers, it's called after all the connections are closed
+// The writers stop last, since the messages can be queued until the handlers stop
+// 停止poller和工作协程，在所有连接关闭后调用，写协程最后停止，因为handler停止前都可能发出消息
+func (engine *pollEngine) shutdown() {
+	close(engine.done)
+	engine.pollerGroup.Wait()
+	for _, poller := range engine.pollers {
+		_ = syscall.Close(poller.epfd)
+	}
+	if engine.handling != nil {
+		engine.handling.close()
+		engine.writing.close()
+	}
+}
+
+// Check the connections every second, close the ones timed out, or the idle ones if the app is restarting
+// 每秒检查连接，断开超时的连接，重启时断开空闲的连接
+func (engine *pollEngine) startSweeper() {
+	defer engine.pollerGroup.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-engine.done:
+			return
+		case <-ticker.C:
+			engine.sweep()
+		}
+	}
+}
+
+func (engine *pollEngine) sweep() {
+	now := time.Now().UnixNano()
+	isRestarting := engine.app.isRestarting()
+	for _, poller := range engine.pollers {
+		poller.lock.Lock()
+		clients := engine.sweepBuf[:0]
+		for _, client := range poller.conns {
+			clients = append(clients, client)
+		}
+		poller.lock.Unlock()
+		for _, client := range clients {
+			timeout := atomic.LoadInt64(&client.timeout)
+			if timeout > 0 && now-atomic.LoadInt64(&client.lastRead) > timeout {
+				//If the connection is idle without any data including ping pong messages
+				engine.app.Log.Debugf("user %d client conn timeout", client.handler.user.GetUid())
+				client.close()
+			} else if isRestarting && client.isIdle() {
+				client.close()
+			}
+		}
+		//Don't hold the closed connections until the next sweep
+		//避免持有已关闭的连接直到下次检查
+		for i := range clients {
+			clients[i] = nil
+		}
+		engine.sweepBuf = clients
+	}
+}
+
+func (poller *poller) add(client *pollConn) error {
+	poller.lock.Lock()
+	poller.conns[client.fd] = client
+	poller.lock.Unlock()
+	event := &syscall.EpollEvent{
+		Events: syscall.EPOLLIN | syscall.EPOLLRDHUP,
+		Fd:     int32(client.fd),
+	}
+	return syscall.EpollCtl(poller.epfd, syscall.EPOLL_CTL_ADD, client.fd, event)
+}
+
+// Stop waiting for the connection to be readable
+// 停止等待连接可读
+func (poller *poller) pause(client *pollConn) {
+	event := &syscall.EpollEvent{
+		Fd: int32(client.fd),
+	}
+	_ = syscall.EpollCtl(poller.epfd, syscall.EPOLL_CTL_MOD, client.fd, event)
+}
+
+func (poller *poller) resume(client *pollConn) {
+	if atomic.LoadInt32(&client.closed) == 1 {
+		return
+	}
+	event := &syscall.EpollEvent{
+		Events: syscall.EPOLLIN | syscall.EPOLLRDHUP,
+		Fd:     int32(client.fd),
+	}
+	_ = syscall.EpollCtl(poller.epfd, syscall.EPOLL_CTL_MOD, client.fd, event)
+}
+
+func (poller *poller) remove(client *pollConn) {
+	poller.lock.Lock()
+	if poller.conns[client.fd] == client {
+		delete(poller.conns, client.fd)
+	}
+	poller.lock.Unlock()
+	_ = syscall.EpollCtl(poller.epfd, syscall.EPOLL_CTL_DEL, client.fd, nil)
+}
+
+// Wait for the readable connections and read them until the engine shuts down
+// 等待可读的连接并读取，直到引擎停止
+func (poller *poller) run() {
+	defer poller.engine.pollerGroup.Done()
+	events := make([]syscall.EpollEvent, kPollEventCount)
+	for {
+		select {
+		case <-poller.engine.done:
+			return
+		default:
+		}
+		n, err := syscall.EpollWait(poller.epfd, events, kPollWaitTimeout)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			poller.engine.app.Log.Error(errors.Wrap(err, "epoll wait"))
+			return
+		}
+		for i := 0; i < n; i++ {
+			poller.lock.Lock()
+			client := poller.conns[int(events[i].Fd)]
+			poller.lock.Unlock()
+			if client != nil && !poller.read(client) {
+				client.close()
+			}
+		}
+	}
+}
+
+// Read the readable connection and decode the whole messages, returns false if the connection should be closed
+// 读取连接并解码完整的消息，返回false时应断开连接
+func (poller *poller) read(client *pollConn) bool {
+	client.readLock.Lock()
+	defer client.readLock.Unlock()
+	if atomic.LoadInt32(&client.closed) == 1 {
+		return true
+	}
+	n, err := syscall.Read(client.fd, poller.buf)
+	if err == syscall.EAGAIN || err == syscall.EINTR {
+		return true
+	}
+	if err != nil || n <= 0 {
+		//The client closed or cut the connection
+		//客户端关闭或中断连接
+		return false
+	}
+	atomic.StoreInt64(&client.lastRead, time.Now().UnixNano())
+	data := poller.buf[:n]
+	//Append to the partial message, the buffer grows in place instead of being copied on every read
+	//追加到不完整的消息之后，缓冲区原地增长，而不是每次读取都复制
+	if len(client.pending) > 0 {
+		client.pending = append(client.pending, data...)
+		data = client.pending
+	}
+	var messages []packet.IMessage
+	var length int
+	for len(data) > 0 {
+		length, err = packet.DeclaredFrameLength(data)
+		//Reject the oversized message before reading the rest of it
+		//在读完之前拒绝过长的消息
+		if err == nil && length > client.poller.engine.frameLimit && length > client.maxFrameLength() {
+			client.handler.app.Log.Debugf("%s message of %d bytes is too long", client.clientIp, length)
+			return false
+		}
+		if err == nil && (length == 0 || length > len(data)) {
+			break
+		}
+		var msg packet.IMessage
+		if err == nil {
+			msg, err = client.msgManager.DecodeMessage(bytes.NewReader(data[:length]))
+		}
+		if err != nil {
+			//Errors regarding gzip
+			//gzip错误
+			if strings.HasPrefix(err.Error(), "gzip") {
+				return false
+			}
+			//Log all error messages under debug environment
+			//如果是自己定义的消息错误，仅在Debug环境输出
+			if _, ok := err.(packet.MessageErr); ok {
+				client.handler.app.Log.Debug(errors.Wrap(err, client.clientIp))
+			} else {
+				client.handler.app.Log.Error(errors.Wrap(err, client.clientIp))
+			}
+			return false
+		}
+		//The read timeout should be bigger than the interval of the ping pong message, see AppConfig.ReadTimeoutFactor
+		//超时时间，默认为心跳包间隔的1.5倍
+		if _, ok := msg.(*packet.Connect); ok {
+			timeout := client.handler.app.readTimeout(client.msgManager.ProCommon.KeepAliveTime)
+			atomic.StoreInt64(&client.timeout, int64(timeout))
+		}
+		messages = append(messages, msg)
+		data = data[length:]
+	}
+	client.keepPending(data, length)
+	if len(messages) > 0 {
+		return client.schedule(messages)
+	}
+	return true
+}
+
+// Keep the partial message for the next read, length is the one declared by its header, 0 if it's unknown
+// The read buffer is shared, so the data in it must be copied, the pending buffer is reused instead
+// 保留不完整的消息，length为头部声明的长度，未知时为0，读缓冲区是共享的，必须复制，pending缓冲区则复用
+func (client *pollConn) keepPending(data []byte, length int) {
+	if len(data) == 0 {
+		//Release the buffer, the idle connections shouldn't hold any memory
+		//释放缓冲区，空闲连接不应占用内存
+		client.pending = nil
+		return
+	}
+	if len(client.pending) > 0 && &data[0] == &client.pending[0] {
+		//Nothing is decoded, the pending buffer is kept as it is
+		//没有解码出消息，pending缓冲区保持不变
+		return
+	}
+	if length < len(data) {
+		length = len(data)
+	}
+	if cap(client.pending) < length {
+		//Allocate the whole message at once, it's bounded by maxFrameLength
+		//一次分配整条消息的空间，其长度受maxFrameLength限制
+		pending := make([]byte, len(data), length)
+		copy(pending, data)
+		client.pending = pending
+		return
+	}
+	//Move the partial message to the front of the pending buffer
+	//将不完整的消息移到pending缓冲区开头
+	client.pending = client.pending[:copy(client.pending[:cap(client.pending)], data)]
+}
+
+// The max length of a frame of the connection, the payload limit of the user can be bigger than the one of the app
+// 连接允许的最大帧长度，用户的请求长度限制可以大于App的限制
+func (client *pollConn) maxFrameLength() int {
+	return client.handler.app.maxPayloadLength(client.handler.user, "") + kPollFrameOverhead
+}
+
+func (app *App) newPollConn(conn net.Conn, fd int) *pollConn {
+	clientIp := conn.RemoteAddr().String()
+	//Get ip only
+	//忽略端口号，只取ip
+	ipAndPort := strings.Split(clientIp, ":")
+	if len(ipAndPort) > 0 {
+		clientIp = ipAndPort[0]
+	}
+	client := &pollConn{
+		conn:       conn,
+		fd:         fd,
+		clientIp:   clientIp,
+		handler:    app.newMessageHandler(nil, clientIp),
+		msgManager: &packet.MessageManager{},
+		lastRead:   time.Now().UnixNano(),
+	}
+	client.handler.queueMessage = client.queue
+	client.handler.onStop = client.close
+	client.handler.closeConn = client.close
+	return client
+}
+
+// Queue the messages and hand the connection to a worker, unless a worker is handling it already
+// 将消息加入队列，如果没有工作协程正在处理该连接，则交给工作协程
+// If the queue is full, the messages are handled by AppConfig.InboundPolicy, returns false if the connection should be closed
+// PolicyBlock stops reading the connection until the queue is drained, instead of blocking the poller
+// 队列满时按AppConfig.InboundPolicy处理，返回false时应断开连接，PolicyBlock会暂停读取该连接直到队列清空，而不是阻塞poller
+func (client *pollConn) schedule(messages []packet.IMessage) bool {
+	client.lock.Lock()
+	var dropped []packet.IMessage
+	policy := client.handler.app.backpressurePolicy(Inbound)
+	if overflow := len(client.messages) + len(messages) - client.handler.app.queueLength(); overflow > 0 {
+		switch policy {
+		case PolicyBlock:
+			//The messages are read already, keep them all
+			//消息已经读取，全部保留
+			client.messages = append(client.messages, messages...)
+			if !client.paused {
+				client.paused = true
+				client.poller.pause(client)
+			}
+		case PolicyDropOldest:
+			client.messages = append(client.messages, messages...)
+			dropped = append(dropped, client.messages[:overflow]...)
+			client.messages = append([]packet.IMessage(nil), client.messages[overflow:]...)
+		case PolicyDisconnect:
+			client.lock.Unlock()
+			client.handler.reportDrop(Inbound, policy, DropReasonSlowConsumer, messages[0])
+			return false
+		default:
+			keep := len(messages) - overflow
+			if keep < 0 {
+				keep = 0
+			}
+			client.messages = append(client.messages, messages[:keep]...)
+			dropped = messages[keep:]
+		}
+	} else {
+		client.messages = append(client.messages, messages...)
+	}
+	defer func() {
+		for _, msg := range dropped {
+			reason := DropReasonQueueFull
+			if policy == PolicyDropOldest {
+				reason = DropReasonEvicted
+			}
+			client.handler.reportDrop(Inbound, policy, reason, msg)
+		}
+	}()
+	if client.scheduled {
+		client.lock.Unlock()
+		return true
+	}
+	client.scheduled = true
+	client.lock.Unlock()
+	client.poller.engine.handling.push(client)
+	return true
+}
+
+// Handle the queued messages one by one, the same as the handling thread of ClientConn
+// 逐条处理队列中的消息，与ClientConn的处理线程相同
+func (client *pollConn) process() {
+	defer func() {
+		if err := recover(); err != nil {
+			client.handler.app.Log.Error(err)
+			client.lock.Lock()
+			client.scheduled = false
+			client.lock.Unlock()
+			client.close()
+		}
+	}()
+	for {
+		client.lock.Lock()
+		messages := client.messages
+		client.messages = nil
+		if len(messages) == 0 {
+			client.scheduled = false
+			//The queue is drained, continue reading the connection paused by PolicyBlock
+			//队列已清空，继续读取被PolicyBlock暂停的连接
+			if client.paused {
+				client.paused = false
+				client.poller.resume(client)
+			}
+			client.lock.Unlock()
+			return
+		}
+		client.lock.Unlock()
+		for _, msg := range messages {
+			if atomic.LoadInt32(&client.closed) == 1 {
+				break
+			}
+			if !client.handler.handleMessage(msg) {
+				client.close()
+			}
+		}
+	}
+}
+
+// Whether there is nothing to handle or to respond
+// 是否没有待处理或待答复的消息
+func (client *pollConn) isIdle() bool {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	if client.scheduled || len(client.messages) > 0 {
+		return false
+	}
+	client.outLock.Lock()
+	writing := client.flushing || len(client.outbox) > 0
+	client.outLock.Unlock()
+	if writing {
+		return false
+	}
+	return client.handler.workers == nil || len(client.handler.workers) == 0
+}
+
+// Queue the message into the outbox by the outbound policy, it's called by the handler instead of queueing into jobChan
+// The writers write the outbox, so a client that doesn't read never blocks the caller, e.g. a broadcast
+// 按发出策略将消息加入发出队列，由handler调用以代替jobChan，由写协程发出，不读取的客户端不会阻塞调用者，如广播
+func (client *pollConn) queue(message packet.IMessage, receipt *Receipt, options *SubmitOptions) SubmitStatus {
+	handler := client.handler
+	policy := handler.app.backpressurePolicy(Outbound)
+	if options != nil {
+		policy = options.Policy
+	}
+	key := ""
+	if policy == PolicyCoalesce {
+		key = coalesceKey(message, options)
+	}
+	var timer *time.Timer
+	var evicted *pollJob
+	client.outLock.Lock()
+	for {
+		if atomic.LoadInt32(&client.closed) == 1 {
+			client.outLock.Unlock()
+			return SubmitClosed
+		}
+		//Replace the queued message with the same key
+		//替换队列中键相同的消息
+		if key != "" {
+			for i := range client.outbox {
+				if client.outbox[i].key != key {
+					continue
+				}
+				replaced := client.outbox[i]
+				client.outbox[i].message, client.outbox[i].receipt = message, receipt
+				client.outLock.Unlock()
+				if replaced.receipt != nil {
+					replaced.receipt.resolve(ErrMessageCoalesced)
+				}
+				handler.reportDrop(Outbound, policy, DropReasonCoalesced, replaced.message)
+				return SubmitCoalesced
+			}
+		}
+		if len(client.outbox) < handler.app.queueLength() {
+			break
+		}
+		switch policy {
+		case PolicyBlock:
+			//Wait until the writer takes the outbox
+			//等待写协程取走队列中的消息
+			if timer == nil {
+				timer = time.NewTimer(handler.app.backpressureTimeout(options))
+				defer timer.Stop()
+			}
+			if client.drained == nil {
+				client.drained = make(chan struct{})
+			}
+			drained := client.drained
+			client.outLock.Unlock()
+			select {
+			case <-drained:
+			case <-handler.ctx.Done():
+				handler.reportDrop(Outbound, policy, DropReasonClosed, message)
+				return SubmitClosed
+			case <-timer.C:
+				handler.reportDrop(Outbound, policy, DropReasonTimeout, message)
+				return SubmitDropped
+			}
+			client.outLock.Lock()
+			continue
+		case PolicyDropOldest:
+			oldest := client.outbox[0]
+			evicted = &oldest
+			client.outbox = append(client.outbox[:0], client.outbox[1:]...)
+		case PolicyDisconnect:
+			client.outLock.Unlock()
+			handler.disconnectSlowConsumer(Outbound, message)
+			return SubmitDropped
+		default:
+			client.outLock.Unlock()
+			handler.reportDrop(Outbound, policy, DropReasonQueueFull, message)
+			return SubmitDropped
+		}
+		break
+	}
+	client.outbox = append(client.outbox, pollJob{
+		message: message,
+		receipt: receipt,
+		key:     key,
+	})
+	dispatch := !client.flushing
+	client.flushing = true
+	client.outLock.Unlock()
+	if dispatch {
+		client.poller.engine.writing.push(client)
+	}
+	if evicted != nil {
+		if evicted.receipt != nil {
+			evicted.receipt.resolve(ErrMessageDropped)
+		}
+		handler.reportDrop(Outbound, policy, DropReasonEvicted, evicted.message)
+	}
+	return SubmitQueued
+}
+
+// Write the outbox until it's empty, the messages taken together are sent by one write
+// A client that doesn't read for kPollWriteTimeout is disconnected
+// 发出队列中的消息直到队列为空，一起取出的消息一次性发送，kPollWriteTimeout内不读取的客户端会被断开
+func (client *pollConn) flush() {
+	app := client.handler.app
+	writer := app.getBufferedWriter(client.conn)
+	defer app.putBufferedWriter(writer)
+	var err error
+	for {
+		client.outLock.Lock()
+		jobs := client.outbox
+		client.outbox = nil
+		if client.drained != nil {
+			close(client.drained)
+			client.drained = nil
+		}
+		if len(jobs) == 0 {
+			client.flushing = false
+			closeAfterFlush := client.closeAfterFlush
+			client.outLock.Unlock()
+			if closeAfterFlush {
+				client.closeSocket()
+			}
+			return
+		}
+		client.outLock.Unlock()
+		//Once failed, the messages left fail with the same error
+		//失败后剩余的消息以相同的错误失败
+		if err == nil {
+			_ = client.conn.SetWriteDeadline(time.Now().Add(kPollWriteTimeout))
+			for _, job := range jobs {
+				if err = client.msgManager.EncodeMessage(writer, job.message); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				err = writer.Flush()
+			}
+			if err != nil {
+				app.Log.Debug(err)
+				client.close()
+			}
+		}
+		for _, job := range jobs {
+			if job.receipt != nil {
+				job.receipt.resolve(err)
+			}
+		}
+	}
+}
+
+// Close the connection and stop the handler, it's safe to be called more than once
+// 断开连接并停止handler，可以多次调用
+func (client *pollConn) close() {
+	if !atomic.CompareAndSwapInt32(&client.closed, 0, 1) {
+		return
+	}
+	client.poller.remove(client)
+	//It returns directly if the handler is stopping, such as being kicked out
+	//如果handler正在停止（如被踢出），会直接返回
+	client.handler.Stop(false)
+	//Let the writer close the socket after sending the messages queued, such as Disconnect
+	//由写协程发完队列中的消息（如Disconnect）后关闭socket
+	client.outLock.Lock()
+	if client.flushing {
+		client.closeAfterFlush = true
+		client.outLock.Unlock()
+		return
+	}
+	client.outLock.Unlock()
+	client.closeSocket()
+}
+
+// Close the socket once the reading finishes, so that the fd can't be reused while reading
+// 等待读取结束后关闭socket，避免读取时文件描述符被复用
+func (client *pollConn) closeSocket() {
+	client.readLock.Lock()
+	_ = client.conn.Close()
+	client.readLock.Unlock()
+}
@@ -0,0 +1,373 @@
+//go:build linux
+// +build linux
+
+package gosocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yankawayu/go-socket/packet"
+	"go.uber.org/zap"
+)
+
+// epollUser accepts the uid as the connect info
+type epollUser struct {
+	AuthUser
+}
+
+func (user *epollUser) Auth(payload string, ip string) (uid int64, code packet.ReturnCode) {
+	uid, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil || uid <= 0 {
+		return -1, packet.RetCodeBadLoginInfo
+	}
+	return uid, packet.RetCodeAccepted
+}
+
+type epollController struct {
+	Controller
+}
+
+type epollParam struct {
+	Text string `json:"text"`
+}
+
+func (controller *epollController) GetActionParamMap() map[string]interface{} {
+	return map[string]interface{}{
+		"Echo": &epollParam{},
+	}
+}
+
+func (controller *epollController) Echo(param *epollParam, response *ResponseBody) {
+	response.Status = StatusSuccess
+	response.Data = param.Text
+}
+
+// Serve an app with the epoll engine on a loopback port, the server stops once the test and its connections finish
+func newEpollServer(t *testing.T, config *AppConfig) (*App, string) {
+	app := NewApp()
+	app.SetAuthUser(&epollUser{})
+	app.Router("epoll", &epollController{})
+	config.Engine = EngineEpoll
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- app.Serve(listener, config, &Log{sugarLogger: zap.NewNop().Sugar()}, &FastLog{logger: zap.NewNop()})
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+		select {
+		case err := <-served:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("the epoll server didn't stop")
+		}
+	})
+	return app, listener.Addr().String()
+}
+
+// epollClient speaks the protocol on a raw tcp connection, so that the frames can be split at will
+type epollClient struct {
+	conn       net.Conn
+	msgManager *packet.MessageManager
+}
+
+func newEpollClient(t *testing.T, addr string, keepAlive uint16) *epollClient {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &epollClient{
+		conn: conn,
+		msgManager: &packet.MessageManager{
+			ProCommon: packet.ProtocolCommon{
+				ProName:       packet.ProtocolName,
+				ProVersion:    packet.ProtocolVersion,
+				KeepAliveTime: keepAlive,
+			},
+		},
+	}
+}
+
+// Connect as the user with a keep alive time of a minute
+func dialEpoll(t *testing.T, addr string, uid int64) *epollClient {
+	client := newEpollClient(t, addr, 60)
+	client.connect(t, uid)
+	return client
+}
+
+func (client *epollClient) connect(t *testing.T, uid int64) {
+	t.Helper()
+	client.write(t, client.encode(t, &packet.Connect{Payload: strconv.FormatInt(uid, 10)}))
+	ack, ok := client.read(t).(*packet.ConnAck)
+	if !ok || ack.ReturnCode != packet.RetCodeAccepted {
+		t.Fatalf("connect failed: %#v", ack)
+	}
+}
+
+func (client *epollClient) encode(t *testing.T, messages ...packet.IMessage) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	for _, msg := range messages {
+		if err := client.msgManager.EncodeMessage(buf, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func (client *epollClient) write(t *testing.T, data []byte) {
+	t.Helper()
+	if _, err := client.conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (client *epollClient) read(t *testing.T) packet.IMessage {
+	t.Helper()
+	_ = client.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg, err := client.msgManager.DecodeMessage(client.conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// Wait for the server to close the connection
+func (client *epollClient) waitClosed(t *testing.T, timeout time.Duration) {
+	t.Helper()
+	_ = client.conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		if _, err := client.msgManager.DecodeMessage(client.conn); err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				t.Fatal("the connection is still open")
+			}
+			return
+		}
+	}
+}
+
+func echoReq(id uint16, text string) *packet.SendReq {
+	return &packet.SendReq{
+		ReplyLevel: packet.RLevelReplyLater,
+		MessageId:  id,
+		Type:       "epoll.Echo",
+		Payload:    JSONEncode(&epollParam{Text: text}),
+	}
+}
+
+// Read the response and check that it echoes the text
+func (client *epollClient) expectEcho(t *testing.T, id uint16, text string) {
+	t.Helper()
+	resp, ok := client.read(t).(*packet.SendResp)
+	if !ok || resp.MessageId != id {
+		t.Fatalf("response %#v, want the one of %d", resp, id)
+	}
+	body := struct {
+		Status Status `json:"status"`
+		Data   string `json:"data"`
+	}{}
+	if err := json.Unmarshal([]byte(resp.Payload), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Status != StatusSuccess || body.Data != text {
+		t.Fatalf("response %s, want %q", resp.Payload, text)
+	}
+}
+
+func TestEpollRequestResponse(t *testing.T) {
+	app, addr := newEpollServer(t, &AppConfig{})
+	client := dialEpoll(t, addr, 1)
+	defer client.conn.Close()
+	if app.ClientPool().GetClientByUid(1) == nil {
+		t.Fatal("user not in the pool after connecting")
+	}
+	client.write(t, client.encode(t, echoReq(1, "hello")))
+	client.expectEcho(t, 1, "hello")
+	client.write(t, client.encode(t, &packet.PingReq{}))
+	if _, ok := client.read(t).(*packet.PingResp); !ok {
+		t.Fatal("no PingResp for the PingReq")
+	}
+	//The pushes from other goroutines go through the outbox
+	if status, receipt := app.ClientPool().GetClientByUid(1).PushNotify("epoll.Push", 1); status != SubmitQueued || receipt == nil {
+		t.Fatalf("push status %v", status)
+	}
+	if push, ok := client.read(t).(*packet.SendReq); !ok || push.Type != "epoll.Push" {
+		t.Fatalf("push %#v", push)
+	}
+}
+
+func TestEpollFramesSplitAcrossReads(t *testing.T) {
+	_, addr := newEpollServer(t, &AppConfig{})
+	client := newEpollClient(t, addr, 60)
+	defer client.conn.Close()
+	data := client.encode(t, &packet.Connect{Payload: "1"}, echoReq(1, "first"), echoReq(2, strings.Repeat("x", 300)))
+	//A few bytes at a time, so that the headers and the payloads are cut across the reads
+	for len(data) > 0 {
+		n := 7
+		if n > len(data) {
+			n = len(data)
+		}
+		client.write(t, data[:n])
+		data = data[n:]
+		time.Sleep(time.Millisecond)
+	}
+	if ack, ok := client.read(t).(*packet.ConnAck); !ok || ack.ReturnCode != packet.RetCodeAccepted {
+		t.Fatalf("connect failed: %#v", ack)
+	}
+	client.expectEcho(t, 1, "first")
+	client.expectEcho(t, 2, strings.Repeat("x", 300))
+	//Several frames in one write, the last one cut in the middle
+	data = client.encode(t, echoReq(3, "a"), echoReq(4, "b"), echoReq(5, "c"))
+	client.write(t, data[:len(data)-3])
+	time.Sleep(10 * time.Millisecond)
+	client.write(t, data[len(data)-3:])
+	for i, text := range []string{"a", "b", "c"} {
+		client.expectEcho(t, uint16(3+i), text)
+	}
+}
+
+func TestEpollRejectsOversizedFrame(t *testing.T) {
+	app, addr := newEpollServer(t, &AppConfig{MaxPayloadLength: 1024})
+	client := dialEpoll(t, addr, 1)
+	defer client.conn.Close()
+	//Within the frame overhead, it reaches the action which rejects it by the payload limit
+	client.write(t, client.encode(t, echoReq(1, strings.Repeat("x", 2048))))
+	if resp, ok := client.read(t).(*packet.SendResp); !ok || !strings.Contains(resp.Payload, "exceeds the max length") {
+		t.Fatalf("response %#v to the request over the limit", resp)
+	}
+	//Only the header of the oversized frame is sent, the connection is closed without waiting for the rest
+	data := client.encode(t, echoReq(2, strings.Repeat("x", 64*1024)))
+	client.write(t, data[:8])
+	client.waitClosed(t, time.Second)
+	deadline := time.Now().Add(time.Second)
+	for app.ClientPool().GetClientByUid(1) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the user is still online")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEpollReadTimeout(t *testing.T) {
+	app, addr := newEpollServer(t, &AppConfig{ReadTimeoutFactor: 1})
+	client := newEpollClient(t, addr, 1)
+	defer client.conn.Close()
+	client.connect(t, 1)
+	//The sweeper checks every second, so the idle connection is closed between 1 and 2 seconds
+	start := time.Now()
+	client.waitClosed(t, 5*time.Second)
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Fatalf("closed after %v, before the read timeout", elapsed)
+	}
+	if app.ClientPool().GetClientByUid(1) != nil {
+		t.Fatal("the user is still online")
+	}
+}
+
+func TestEpollCloseDuringFlush(t *testing.T) {
+	app, addr := newEpollServer(t, &AppConfig{QueueLength: 64})
+	client := newEpollClient(t, addr, 60)
+	defer client.conn.Close()
+	//A small receive buffer so that the writer blocks on the pushes the client doesn't read
+	if err := client.conn.(*net.TCPConn).SetReadBuffer(64 * 1024); err != nil {
+		t.Fatal(err)
+	}
+	client.connect(t, 1)
+	handler := app.ClientPool().GetClientByUid(1)
+	payload := strings.Repeat("x", 64*1024)
+	var receipts []*Receipt
+	for i := 0; i < 8; i++ {
+		status, receipt := handler.PushNotify("epoll.Big", payload)
+		if status != SubmitQueued {
+			t.Fatalf("push %d status %v", i, status)
+		}
+		receipts = append(receipts, receipt)
+	}
+	//Kicking out queues a Disconnect and closes the connection while the pushes are being written
+	select {
+	case <-receipts[len(receipts)-1].Done():
+		t.Fatal("the pushes are flushed before closing")
+	default:
+	}
+	app.KickUid(context.Background(), 1)
+	pushes := 0
+	for {
+		msg := client.read(t)
+		if disconnect, ok := msg.(*packet.Disconnect); ok {
+			if disconnect.Type != packet.DiscTypeKickout {
+				t.Fatalf("disconnect type %v", disconnect.Type)
+			}
+			break
+		}
+		if _, ok := msg.(*packet.SendReq); !ok {
+			t.Fatalf("message %#v", msg)
+		}
+		pushes++
+	}
+	if pushes != len(receipts) {
+		t.Fatalf("%d pushes before the disconnect, want %d", pushes, len(receipts))
+	}
+	//The socket is closed once the outbox is written
+	client.waitClosed(t, time.Second)
+	for _, receipt := range receipts {
+		if err := receipt.WaitTimeout(time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPollQueueOverflow(t *testing.T) {
+	release := make(chan struct{})
+	var lock sync.Mutex
+	runs := make(map[*pollConn]int)
+	queue := newPollQueue(1, 1, func(client *pollConn) {
+		<-release
+		lock.Lock()
+		runs[client]++
+		lock.Unlock()
+	})
+	//The worker blocks on the first one and the tasks hold one more, the others overflow without blocking the caller
+	clients := make([]*pollConn, 10)
+	pushed := make(chan struct{})
+	go func() {
+		for i := range clients {
+			clients[i] = &pollConn{}
+			queue.push(clients[i])
+		}
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatal("push blocked while the worker is busy")
+	}
+	queue.lock.Lock()
+	overflow := len(queue.overflow)
+	queue.lock.Unlock()
+	if overflow < len(clients)-2 {
+		t.Fatalf("%d overflowed, want at least %d", overflow, len(clients)-2)
+	}
+	close(release)
+	queue.close()
+	for i, client := range clients {
+		if runs[client] != 1 {
+			t.Fatalf("connection %d ran %d times", i, runs[client])
+		}
+	}
+	if queue.pop() != nil {
+		t.Fatal("the overflow isn't drained")
+	}
+}
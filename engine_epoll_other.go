@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package gosocket
+
+// epoll is only available on linux
+// 仅linux支持epoll
+func (app *App) newPollEngine() connEngine {
+	app.Log.Warning("the epoll engine is only supported on linux, fall back to the goroutine engine")
+	return nil
+}
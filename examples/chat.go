@@ -20,6 +20,13 @@ func (controller *ChatController) GetActionParamMap() map[string]interface{} {
 	}
 }
 
+// GetActionResponseMap declares the response data of the actions for the API catalog
+func (controller *ChatController) GetActionResponseMap() map[string]interface{} {
+	return map[string]interface{}{
+		"AddMessage": map[string]string{},
+	}
+}
+
 func (controller *ChatController) AddMessage(request *AddMessageReqBody, response *gosocket.ResponseBody) {
 	fmt.Println("user " + strconv.FormatInt(controller.User.GetUid(), 10) + " message received: " + request.Message)
 	response.Data = map[string]string{
@@ -2,16 +2,25 @@ package main
 
 import (
 	"github.com/yankawayu/go-socket"
+	"log"
+	"os"
 )
 
 func main() {
+	//Add ChatController to the router
+	gosocket.Router("chat", &ChatController{})
+	//Write the API catalog and exit if the command is `catalog -o catalog.json`
+	if ok, err := gosocket.RunCatalogCommand(os.Args[1:]); ok {
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	appConfig := &gosocket.AppConfig{
 		TcpAddr:   "0.0.0.0",
 		TcpPort:   8080,
 		TlsEnable: false,
 	}
 	fastLog := gosocket.GetFastLog("app.access", false)
-	//Add ChatController to the router
-	gosocket.Router("chat", &ChatController{})
 	gosocket.Run(appConfig, &TestUser{}, gosocket.GetLog(false), fastLog)
 }
@@ -1,5 +1,7 @@
 package gosocket
 
+import "context"
+
 // Run start the server
 // This is the entry point of the whole framework
 // The appConfig is used to configure the server
@@ -7,11 +9,85 @@ package gosocket
 // The log is used to log any errors happens during the process
 // The fastLog is used to log any requests received by the server
 // The requests can be very frequent therefore using the fast logger will make sure the performance is high
+//
+// It runs the default TcpApp, create your own App with NewApp to run several servers in one process
 func Run(config *AppConfig, user IUser, log ILogger, fastLog IFastLogger) {
-	if user == nil {
-		setAuthUser(&AuthUser{})
-	} else {
-		setAuthUser(user)
-	}
+	TcpApp.SetAuthUser(user)
 	TcpApp.Run(config, log, fastLog)
 }
+
+// SetAuthUser set the class used to customize the user identification process of the default TcpApp
+// Pass nil to use the default AuthUser
+// It's called by Run, call it yourself only if you start the server through App.Serve
+// 设置登陆验证类，传nil使用默认的AuthUser
+func SetAuthUser(user IUser) {
+	TcpApp.SetAuthUser(user)
+}
+
+// Router Register a controller to its corresponding name in the default TcpApp
+// 注册Controller以及对应的名字
+func Router(controllerName string, controller IController) {
+	TcpApp.Router(controllerName, controller)
+}
+
+// Routes returns all the routes of the default TcpApp sorted by the payload type
+// 获取默认App的所有路由
+func Routes() []*Route {
+	return TcpApp.Routes()
+}
+
+// GetCatalog generate the API catalog of the default TcpApp
+// 生成默认App的API目录
+func GetCatalog() *Catalog {
+	return TcpApp.Catalog()
+}
+
+// RunCatalogCommand write the catalog of the default TcpApp if the args are `catalog [-o path]`
+// 如果参数是目录命令，写入默认App的API目录
+func RunCatalogCommand(args []string) (ok bool, err error) {
+	return TcpApp.RunCatalogCommand(args)
+}
+
+// Use add middlewares for all the requests of the default TcpApp
+// 添加作用于所有请求的中间件
+func Use(middlewares ...Middleware) {
+	TcpApp.Use(middlewares...)
+}
+
+// UseController add middlewares for the requests of the controller in the default TcpApp
+// 添加仅作用于指定Controller的中间件
+func UseController(controllerName string, middlewares ...Middleware) {
+	TcpApp.UseController(controllerName, middlewares...)
+}
+
+// GetClientPool get the ClientPool of the default TcpApp
+// 获取连接池
+func GetClientPool() *ClientPool {
+	return TcpApp.ClientPool()
+}
+
+// GetRestartManager get the RestartManager of the default TcpApp
+// 获取重启管理器
+func GetRestartManager() *RestartManager {
+	return TcpApp.RestartManager()
+}
+
+// InitGracefulRestart is used to initialize graceful restart of the default TcpApp
+// If you don't call this function, the server won't be able to restart itself through signal
+func InitGracefulRestart() {
+	TcpApp.InitGracefulRestart()
+}
+
+func ProcessPayload(user IUser, payloadType string, payload string) (response *ResponseBody) {
+	return TcpApp.ProcessPayload(user, payloadType, payload)
+}
+
+// ProcessPayloadWithData process the request payload with the routes of the default TcpApp
+func ProcessPayloadWithData(user IUser, payloadType string, payload string, data []byte) (response *ResponseBody) {
+	return TcpApp.ProcessPayloadWithData(user, payloadType, payload, data)
+}
+
+// ProcessPayloadWithContext process the request payload with the ctx and the routes of the default TcpApp
+func ProcessPayloadWithContext(ctx context.Context, user IUser, payloadType string, payload string, data []byte) (response *ResponseBody) {
+	return TcpApp.ProcessPayloadWithContext(ctx, user, payloadType, payload, data)
+}
@@ -0,0 +1,134 @@
+package gosockettest
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	gosocket "github.com/yankawayu/go-socket"
+)
+
+var ErrWaitTimeout = errors.New("wait timeout")
+
+// Client is a connected gosocket.Client that captures all the pushes and state changes
+// 记录所有推送和状态变化的客户端
+type Client struct {
+	*gosocket.Client
+
+	lock        sync.Mutex
+	pushes      []*gosocket.Push
+	states      []gosocket.ClientState
+	closeReason error
+	isClosed    bool
+	// changeChan is closed and replaced every time something is captured
+	//每次有新记录时关闭并替换，用于等待
+	changeChan chan struct{}
+}
+
+func newClient() *Client {
+	return &Client{
+		changeChan: make(chan struct{}),
+	}
+}
+
+func (client *Client) onPush(push *gosocket.Push) {
+	client.lock.Lock()
+	client.pushes = append(client.pushes, push)
+	client.notify()
+	client.lock.Unlock()
+}
+
+func (client *Client) onStateChange(state gosocket.ClientState, err error) {
+	client.lock.Lock()
+	client.states = append(client.states, state)
+	if state == gosocket.ClientStateClosed {
+		client.isClosed = true
+		client.closeReason = err
+	}
+	client.notify()
+	client.lock.Unlock()
+}
+
+// Must be called with the lock
+func (client *Client) notify() {
+	close(client.changeChan)
+	client.changeChan = make(chan struct{})
+}
+
+// Pushes returns all the captured pushes
+// 收到的所有推送
+func (client *Client) Pushes() []*gosocket.Push {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	pushes := make([]*gosocket.Push, len(client.pushes))
+	copy(pushes, client.pushes)
+	return pushes
+}
+
+// States returns all the states the client has been through
+// 经历过的所有状态
+func (client *Client) States() []gosocket.ClientState {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	states := make([]gosocket.ClientState, len(client.states))
+	copy(states, client.states)
+	return states
+}
+
+// WaitPush waits until a push of the type arrives, including the ones captured before
+// 等待指定类型的推送
+func (client *Client) WaitPush(pushType string, timeout time.Duration) (*gosocket.Push, error) {
+	var push *gosocket.Push
+	err := client.wait(timeout, func() bool {
+		for _, captured := range client.pushes {
+			if captured.Type == pushType {
+				push = captured
+				return true
+			}
+		}
+		return false
+	})
+	return push, err
+}
+
+// WaitDisconnect waits until the client is closed and returns the reason, e.g. gosocket.ErrKickedOut
+// It returns ErrWaitTimeout if the client is still open after the timeout
+// 等待连接断开，返回断开原因
+func (client *Client) WaitDisconnect(timeout time.Duration) error {
+	var reason error
+	err := client.wait(timeout, func() bool {
+		reason = client.closeReason
+		return client.isClosed
+	})
+	if err != nil {
+		return err
+	}
+	return reason
+}
+
+// IsClosed whether the connection is off
+func (client *Client) IsClosed() bool {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	return client.isClosed
+}
+
+// Wait until the condition is met, the condition is checked with the lock
+func (client *Client) wait(timeout time.Duration, condition func() bool) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		client.lock.Lock()
+		met := condition()
+		changeChan := client.changeChan
+		client.lock.Unlock()
+		if met {
+			return nil
+		}
+		select {
+		case <-changeChan:
+		case <-timer.C:
+			return ErrWaitTimeout
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package gosockettest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// errClosed is returned by a closed Listener, with the same text as the error of a closed net.Listener
+// so that gosocket.Server stops accepting as it does with tcp
+// listener关闭后返回的错误，与net.Listener关闭时的错误信息相同
+var errClosed = errors.New("use of closed network connection")
+
+// Listener is an in-memory net.Listener
+// Every connection created by Dial is a net.Pipe, no port or file descriptor is used
+// 内存中的listener，通过net.Pipe建立连接
+type Listener struct {
+	connChan  chan net.Conn
+	closeChan chan struct{}
+	closeOnce sync.Once
+}
+
+func NewListener() *Listener {
+	return &Listener{
+		connChan:  make(chan net.Conn),
+		closeChan: make(chan struct{}),
+	}
+}
+
+// Accept waits for the next connection created by Dial
+func (listener *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.connChan:
+		return conn, nil
+	case <-listener.closeChan:
+		return nil, errClosed
+	}
+}
+
+// Close stops accepting connections, the existing ones are not affected
+func (listener *Listener) Close() error {
+	listener.closeOnce.Do(func() {
+		close(listener.closeChan)
+	})
+	return nil
+}
+
+func (listener *Listener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+// Dial creates a new connection to the listener, the addr is ignored
+// It can be used as gosocket.ClientOptions.Dialer
+// 建立一个到listener的连接，可以用作ClientOptions.Dialer
+func (listener *Listener) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	serverConn, clientConn := net.Pipe()
+	select {
+	case listener.connChan <- serverConn:
+		return clientConn, nil
+	case <-listener.closeChan:
+		return nil, errClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string {
+	return "pipe"
+}
+
+func (pipeAddr) String() string {
+	return "pipe"
+}
@@ -0,0 +1,209 @@
+package gosockettest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// LogLevel is the level of a captured log entry
+type LogLevel string
+
+const (
+	LevelDebug   = LogLevel("debug")
+	LevelInfo    = LogLevel("info")
+	LevelWarning = LogLevel("warning")
+	LevelError   = LogLevel("error")
+	LevelFatal   = LogLevel("fatal")
+	LevelPanic   = LogLevel("panic")
+)
+
+// LogEntry is a log captured by Recorder
+type LogEntry struct {
+	Level   LogLevel
+	Message string
+	Access  bool            //Whether it's an access log written through gosocket.IFastLogger
+	Fields  []zapcore.Field //Only the access logs have fields
+}
+
+// Field returns the value of the field with the key, and whether it exists
+// 获取日志中指定字段的值
+func (entry LogEntry) Field(key string) (interface{}, bool) {
+	encoder := zapcore.NewMapObjectEncoder()
+	for _, field := range entry.Fields {
+		if field.Key == key {
+			field.AddTo(encoder)
+			return encoder.Fields[key], true
+		}
+	}
+	return nil, false
+}
+
+// Recorder implements gosocket.ILogger, and FastLog returns a gosocket.IFastLogger sharing the same records
+// It keeps all the logs in memory for assertions instead of writing the runtime directory
+// 在内存中记录所有日志，用于断言
+type Recorder struct {
+	lock    sync.Mutex
+	entries []LogEntry
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (recorder *Recorder) add(level LogLevel, message string, fields []zapcore.Field) {
+	recorder.addEntry(LogEntry{
+		Level:   level,
+		Message: message,
+		Fields:  fields,
+	})
+}
+
+func (recorder *Recorder) addEntry(entry LogEntry) {
+	recorder.lock.Lock()
+	recorder.entries = append(recorder.entries, entry)
+	recorder.lock.Unlock()
+}
+
+// FastLog returns the gosocket.IFastLogger that writes access logs into the recorder
+// 返回记录访问日志的IFastLogger
+func (recorder *Recorder) FastLog() *FastRecorder {
+	return &FastRecorder{recorder: recorder}
+}
+
+// AccessLogs returns the access logs with the message, e.g. "sendReq", "connect"
+// Pass an empty message to get all of them
+// 获取指定类型的访问日志
+func (recorder *Recorder) AccessLogs(message string) []LogEntry {
+	var entries []LogEntry
+	for _, entry := range recorder.Entries() {
+		if entry.Access && (message == "" || entry.Message == message) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Entries returns all the captured logs
+// 所有日志
+func (recorder *Recorder) Entries() []LogEntry {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	entries := make([]LogEntry, len(recorder.entries))
+	copy(entries, recorder.entries)
+	return entries
+}
+
+// Level returns the captured logs of the level
+// 指定级别的日志
+func (recorder *Recorder) Level(level LogLevel) []LogEntry {
+	var entries []LogEntry
+	for _, entry := range recorder.Entries() {
+		if !entry.Access && entry.Level == level {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Errors returns the captured error logs
+// 错误日志
+func (recorder *Recorder) Errors() []LogEntry {
+	return recorder.Level(LevelError)
+}
+
+// Contains checks whether any captured log contains the text
+// 是否有包含指定内容的日志
+func (recorder *Recorder) Contains(text string) bool {
+	for _, entry := range recorder.Entries() {
+		if strings.Contains(entry.Message, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reset removes all the captured logs
+func (recorder *Recorder) Reset() {
+	recorder.lock.Lock()
+	recorder.entries = nil
+	recorder.lock.Unlock()
+}
+
+func (recorder *Recorder) Fatal(args ...interface{}) {
+	recorder.add(LevelFatal, fmt.Sprint(args...), nil)
+}
+
+func (recorder *Recorder) Fatalf(format string, args ...interface{}) {
+	recorder.add(LevelFatal, fmt.Sprintf(format, args...), nil)
+}
+
+func (recorder *Recorder) Panic(args ...interface{}) {
+	message := fmt.Sprint(args...)
+	recorder.add(LevelPanic, message, nil)
+	panic(message)
+}
+
+func (recorder *Recorder) Panicf(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	recorder.add(LevelPanic, message, nil)
+	panic(message)
+}
+
+func (recorder *Recorder) Error(err interface{}) {
+	recorder.add(LevelError, fmt.Sprintf("%+v", err), nil)
+}
+
+func (recorder *Recorder) Errorf(format string, args ...interface{}) {
+	recorder.add(LevelError, fmt.Sprintf(format, args...), nil)
+}
+
+func (recorder *Recorder) Warning(args ...interface{}) {
+	recorder.add(LevelWarning, fmt.Sprint(args...), nil)
+}
+
+func (recorder *Recorder) Warningf(format string, args ...interface{}) {
+	recorder.add(LevelWarning, fmt.Sprintf(format, args...), nil)
+}
+
+func (recorder *Recorder) Info(args ...interface{}) {
+	recorder.add(LevelInfo, fmt.Sprint(args...), nil)
+}
+
+func (recorder *Recorder) Infof(format string, args ...interface{}) {
+	recorder.add(LevelInfo, fmt.Sprintf(format, args...), nil)
+}
+
+func (recorder *Recorder) Debug(args ...interface{}) {
+	recorder.add(LevelDebug, fmt.Sprint(args...), nil)
+}
+
+func (recorder *Recorder) Debugf(format string, args ...interface{}) {
+	recorder.add(LevelDebug, fmt.Sprintf(format, args...), nil)
+}
+
+// FastRecorder implements gosocket.IFastLogger, it's created by Recorder.FastLog
+// 记录访问日志
+type FastRecorder struct {
+	recorder *Recorder
+}
+
+func (fastRecorder *FastRecorder) Info(msg string, fields ...zapcore.Field) {
+	fastRecorder.recorder.addEntry(LogEntry{
+		Level:   LevelInfo,
+		Message: msg,
+		Access:  true,
+		Fields:  fields,
+	})
+}
+
+func (fastRecorder *FastRecorder) Debug(msg string, fields ...zapcore.Field) {
+	fastRecorder.recorder.addEntry(LogEntry{
+		Level:   LevelDebug,
+		Message: msg,
+		Access:  true,
+		Fields:  fields,
+	})
+}
@@ -0,0 +1,165 @@
+// Package gosockettest provides utilities for testing gosocket servers and controllers
+//
+// Server starts the server on an in-memory listener, so tests don't need a port or the runtime directory:
+//
+//	gosocket.Router("chat", &ChatController{})
+//	server := gosockettest.NewServer(nil)
+//	defer server.Close()
+//	client, err := server.Connect(1)
+//	err = client.Call(ctx, "chat.AddMessage", req, &resp)
+//	push, err := client.WaitPush("chat.NewMessage", time.Second)
+//
+// Use NewAppServer to run an App of its own, so that tests with different routes can run in parallel:
+//
+//	app := gosocket.NewApp()
+//	app.Router("chat", &ChatController{})
+//	server := gosockettest.NewAppServer(app, nil)
+//
+// Call runs an action directly with a fake user, without any connection:
+//
+//	response := gosockettest.Call(gosockettest.NewFakeUser(1), "chat.AddMessage", req, nil)
+package gosockettest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	gosocket "github.com/yankawayu/go-socket"
+)
+
+// kCloseTimeout is the max time to wait for all the connections to finish in Close
+const kCloseTimeout = 5 * time.Second
+
+// Server is a gosocket server listening on an in-memory Listener
+// All the logs are captured by Log
+// 在内存listener上运行的服务器
+type Server struct {
+	App      *gosocket.App
+	Listener *Listener
+	Log      *Recorder
+
+	lock      sync.Mutex
+	clients   []*Client
+	serveErr  chan error
+	closeOnce sync.Once
+}
+
+// NewServer start the default gosocket.TcpApp with the user as the auth class, pass nil to use FakeUser
+// The routes are registered by gosocket.Router, and only one of these servers can run at a time
+// 启动默认App，user传nil时使用FakeUser
+func NewServer(user gosocket.IUser) *Server {
+	return NewAppServer(gosocket.TcpApp, user)
+}
+
+// NewAppServer start the app with the user as the auth class, pass nil to use FakeUser
+// 启动指定的App，user传nil时使用FakeUser
+func NewAppServer(app *gosocket.App, user gosocket.IUser) *Server {
+	return NewAppServerWithConfig(app, user, nil)
+}
+
+// NewAppServerWithConfig start the app with the config, such as MaxConcurrentRequests, the address in it is ignored
+// 使用指定配置启动App，配置中的地址会被忽略
+func NewAppServerWithConfig(app *gosocket.App, user gosocket.IUser, appConfig *gosocket.AppConfig) *Server {
+	if user == nil {
+		user = &FakeUser{}
+	}
+	app.SetAuthUser(user)
+	server := &Server{
+		App:      app,
+		Listener: NewListener(),
+		Log:      NewRecorder(),
+		serveErr: make(chan error, 1),
+	}
+	go func() {
+		server.serveErr <- server.App.Serve(server.Listener, appConfig, server.Log, server.Log.FastLog())
+	}()
+	return server
+}
+
+// NewClient returns a connected client with the connect info
+// 使用连接信息建立连接
+func (server *Server) NewClient(connectInfo string) (*Client, error) {
+	return server.NewClientWithOptions(connectInfo, nil)
+}
+
+// NewClientWithOptions is the same as NewClient except that the options are used to create the client
+// The Dialer and OnStateChange fields are overwritten
+func (server *Server) NewClientWithOptions(connectInfo string, options *gosocket.ClientOptions) (*Client, error) {
+	if options == nil {
+		options = gosocket.DefaultClientOptions()
+	}
+	client := newClient()
+	options.Dialer = server.Listener.Dial
+	options.OnStateChange = client.onStateChange
+	client.Client = gosocket.NewClientWithOptions("pipe", 1, false, server.Log, &connectProvider{connectInfo}, options)
+	client.OnAnyPush(client.onPush)
+	ctx, cancel := context.WithTimeout(context.Background(), kCloseTimeout)
+	defer cancel()
+	if err := client.ConnectContext(ctx); err != nil {
+		return nil, err
+	}
+	server.lock.Lock()
+	server.clients = append(server.clients, client)
+	server.lock.Unlock()
+	return client, nil
+}
+
+// Connect returns a client connected as the user with the uid and the roles, it works with FakeUser
+// 以指定uid的用户建立连接，需配合FakeUser使用
+func (server *Server) Connect(uid int64, roles ...string) (*Client, error) {
+	return server.NewClient(ConnectInfo(uid, roles...))
+}
+
+// Close disconnects all the clients and stops the server
+// 断开所有客户端并停止服务器
+func (server *Server) Close() error {
+	var err error
+	server.closeOnce.Do(func() {
+		server.lock.Lock()
+		clients := server.clients
+		server.clients = nil
+		server.lock.Unlock()
+		for _, client := range clients {
+			client.Disconnect()
+		}
+		_ = server.Listener.Close()
+		select {
+		case err = <-server.serveErr:
+		case <-time.After(kCloseTimeout):
+			err = errors.New("some connections are still open")
+		}
+	})
+	return err
+}
+
+// Call runs the action of payloadType in the default gosocket.TcpApp with the user directly, without any connection
+// The payload can be a json string or anything that can be encoded into json
+// 直接调用action，payload可以是json字符串或可以编码为json的对象
+func Call(user gosocket.IUser, payloadType string, payload interface{}, data []byte) *gosocket.ResponseBody {
+	return CallApp(gosocket.TcpApp, user, payloadType, payload, data)
+}
+
+// CallApp is the same as Call except that the action is found in the routes of the app
+// 调用指定App中的action
+func CallApp(app *gosocket.App, user gosocket.IUser, payloadType string, payload interface{}, data []byte) *gosocket.ResponseBody {
+	payloadStr, ok := payload.(string)
+	if !ok && payload != nil {
+		payloadStr = gosocket.JSONEncode(payload)
+	}
+	//Errors are logged by the app, make sure there is a logger
+	//确保有日志记录错误
+	if app.Log == nil {
+		app.Log = NewRecorder()
+	}
+	return app.ProcessPayloadWithData(user, payloadType, payloadStr, data)
+}
+
+type connectProvider struct {
+	connectInfo string
+}
+
+func (provider *connectProvider) GetConnectInfo() string {
+	return provider.connectInfo
+}
@@ -0,0 +1,91 @@
+package gosockettest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	gosocket "github.com/yankawayu/go-socket"
+	"github.com/yankawayu/go-socket/gosockettest"
+)
+
+type echoController struct {
+	gosocket.Controller
+}
+
+type echoParam struct {
+	Text string `json:"text"`
+}
+
+func (controller *echoController) GetActionParamMap() map[string]interface{} {
+	return map[string]interface{}{
+		"Say": &echoParam{},
+	}
+}
+
+func (controller *echoController) Say(param *echoParam, response *gosocket.ResponseBody) {
+	response.Status = gosocket.StatusSuccess
+	response.Data = &echoParam{Text: param.Text}
+}
+
+func newEchoServer() *gosockettest.Server {
+	app := gosocket.NewApp()
+	app.Router("echo", &echoController{})
+	return gosockettest.NewAppServer(app, nil)
+}
+
+func TestServerSmoke(t *testing.T) {
+	server := newEchoServer()
+	client, err := server.Connect(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply echoParam
+	if err := client.Call(context.Background(), "echo.Say", &echoParam{Text: "hi"}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Text != "hi" {
+		t.Fatalf("reply %q, want %q", reply.Text, "hi")
+	}
+	results := server.App.PushToUid(context.Background(), 1, "echo.Pushed", &echoParam{Text: "pushed"})
+	if len(results) != 1 || results[0].Status != gosocket.SubmitQueued {
+		t.Fatalf("push results %+v", results)
+	}
+	push, err := client.WaitPush("echo.Pushed", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pushed echoParam
+	if err := push.Decode(&pushed); err != nil || pushed.Text != "pushed" {
+		t.Fatalf("push %q, err %v", push.Payload, err)
+	}
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WaitDisconnect(time.Second); err == gosockettest.ErrWaitTimeout {
+		t.Fatal("client still connected after Close")
+	}
+	if logged := server.Log.Errors(); len(logged) > 0 {
+		t.Fatalf("errors logged: %v", logged)
+	}
+}
+
+func TestConnectRejected(t *testing.T) {
+	server := newEchoServer()
+	defer server.Close()
+	if _, err := server.NewClient("{}"); err == nil {
+		t.Fatal("connected without a uid")
+	}
+}
+
+func TestCallApp(t *testing.T) {
+	app := gosocket.NewApp()
+	app.Router("echo", &echoController{})
+	response := gosockettest.CallApp(app, gosockettest.NewFakeUser(1), "echo.Say", &echoParam{Text: "direct"}, nil)
+	if response.Status != gosocket.StatusSuccess {
+		t.Fatalf("status %d, message %q", response.Status, response.Message)
+	}
+	if data, ok := response.Data.(*echoParam); !ok || data.Text != "direct" {
+		t.Fatalf("data %#v", response.Data)
+	}
+}
@@ -0,0 +1,51 @@
+package gosockettest
+
+import (
+	"encoding/json"
+
+	gosocket "github.com/yankawayu/go-socket"
+	"github.com/yankawayu/go-socket/packet"
+)
+
+// FakeUser is an IUser for tests
+// NewFakeUser returns a logged-in user, which can be passed to Call directly
+// As the auth class of a Server, it accepts the connect info returned by ConnectInfo
+// 测试用的用户
+type FakeUser struct {
+	gosocket.AuthUser
+	Roles []string
+}
+
+// NewFakeUser create a user that has logged in with the uid and the roles
+// 创建一个已登陆的用户
+func NewFakeUser(uid int64, roles ...string) *FakeUser {
+	user := &FakeUser{Roles: roles}
+	user.Uid = uid
+	return user
+}
+
+// ConnectInfo returns the connect info accepted by FakeUser.Auth
+// 生成FakeUser可以验证通过的连接信息
+func ConnectInfo(uid int64, roles ...string) string {
+	return gosocket.JSONEncode(fakeLoginInfo{Uid: uid, Roles: roles})
+}
+
+type fakeLoginInfo struct {
+	Uid   int64    `json:"uid"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+// Auth accepts the connect info in the format of {"uid":123,"roles":["admin"]}
+func (user *FakeUser) Auth(payload string, ip string) (uid int64, code packet.ReturnCode) {
+	loginInfo := fakeLoginInfo{}
+	if err := json.Unmarshal([]byte(payload), &loginInfo); err != nil || loginInfo.Uid <= 0 {
+		return -1, packet.RetCodeBadLoginInfo
+	}
+	user.Roles = loginInfo.Roles
+	return loginInfo.Uid, packet.RetCodeAccepted
+}
+
+// GetRoles returns the roles in the connect info
+func (user *FakeUser) GetRoles() []string {
+	return user.Roles
+}
@@ -0,0 +1,239 @@
+package gosocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ActionError is an error with the status and the message sent to the client
+// Return it from the typed handlers, or panic it in the actions of the controllers
+// 带状态码和提示信息的错误，可在typed handler中返回，或在action中panic
+type ActionError struct {
+	Status  Status
+	Message string
+}
+
+// NewActionError create an error responded with the status and the message
+// 创建一个返回指定状态码和信息的错误
+func NewActionError(status Status, message string) *ActionError {
+	return &ActionError{
+		Status:  status,
+		Message: message,
+	}
+}
+
+func (e *ActionError) Error() string {
+	return e.Message
+}
+
+// ShowError implements IUserError
+func (e *ActionError) ShowError() string {
+	return e.Message
+}
+
+// ErrorMapper maps the error returned by a typed handler to the response
+// Return nil to use the default mapping, see App.SetErrorMapper
+// 将typed handler返回的错误转换为返回数据，返回nil时使用默认转换
+type ErrorMapper func(request *Request, err error) *ResponseBody
+
+// TypedHandler is a handler with the types of its param and response,
+// create it by NewTypedHandler, or by Typed with Go 1.21 or later
+// 带参数及返回类型的处理函数，通过NewTypedHandler创建，Go 1.21及以上版本也可以通过Typed创建
+type TypedHandler struct {
+	paramType        reflect.Type
+	responseType     reflect.Type
+	handle           func(ctx context.Context, payload string) (interface{}, error)
+	maxPayloadLength int
+	roles            []string
+	timeout          time.Duration
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// NewTypedHandler create a TypedHandler from a function in the form of func(ctx context.Context, req *Req) (*Resp, error)
+// It's the same as Typed except that the function is called by reflection, so it works with all the versions of Go
+// It panics if the function isn't in the form, so that the mistakes are found once it's registered
+//
+//	app.Handle("chat.AddMessage", gosocket.NewTypedHandler(func(ctx context.Context, req *AddMessageReq) (*AddMessageResp, error) {
+//		return &AddMessageResp{MessageId: "1"}, nil
+//	}))
+//
+// 通过形如func(ctx context.Context, req *Req) (*Resp, error)的函数创建TypedHandler，与Typed相同但通过反射调用，支持所有版本的Go
+// 函数形式不符时panic，以便注册时发现错误
+func NewTypedHandler(handler interface{}) *TypedHandler {
+	handlerValue := reflect.ValueOf(handler)
+	if handlerValue.Kind() != reflect.Func {
+		panic(fmt.Sprintf("typed handler should be func(context.Context, *Req) (*Resp, error): %T", handler))
+	}
+	handlerType := handlerValue.Type()
+	if handlerType.NumIn() != 2 || handlerType.NumOut() != 2 ||
+		handlerType.In(0) != contextType || handlerType.In(1).Kind() != reflect.Ptr ||
+		handlerType.Out(0).Kind() != reflect.Ptr || handlerType.Out(1) != errorType {
+		panic(fmt.Sprintf("typed handler should be func(context.Context, *Req) (*Resp, error): %T", handler))
+	}
+	paramType := handlerType.In(1).Elem()
+	return &TypedHandler{
+		paramType:    paramType,
+		responseType: handlerType.Out(0).Elem(),
+		handle: func(ctx context.Context, payload string) (interface{}, error) {
+			req := reflect.New(paramType)
+			if err := decodeTypedParam(payload, req.Interface()); err != nil {
+				return nil, err
+			}
+			results := handlerValue.Call([]reflect.Value{reflect.ValueOf(&ctx).Elem(), req})
+			if err, _ := results[1].Interface().(error); err != nil {
+				return nil, err
+			}
+			if results[0].IsNil() {
+				return nil, nil
+			}
+			return results[0].Interface(), nil
+		},
+	}
+}
+
+// Decode the payload into the param of the typed handler and validate it by its `validate` tags
+// 将payload解码到typed handler的参数并按`validate`标签校验
+func decodeTypedParam(payload string, req interface{}) error {
+	if len(payload) > 0 {
+		if err := json.Unmarshal([]byte(payload), req); err != nil {
+			return NewActionError(StatusError, "Failed to decode payload into param")
+		}
+	}
+	if errs := Validate(req); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// WithMaxPayloadLength set the max payload length of the handler in bytes, the same as IActionPayloadLimit
+//
+//	app.Handle("file.Upload", gosocket.Typed(upload).WithMaxPayloadLength(1 << 20))
+//
+// 设置最大请求长度（字节），与IActionPayloadLimit相同
+func (handler *TypedHandler) WithMaxPayloadLength(length int) *TypedHandler {
+	handler.maxPayloadLength = length
+	return handler
+}
+
+// WithRoles set the roles required by the handler, the same as IActionRole
+// The user must have at least one of them, or the request is responded with StatusForbidden
+//
+//	app.Handle("admin.Ban", gosocket.Typed(ban).WithRoles("admin"))
+//
+// 设置处理函数所需的角色，与IActionRole相同，用户至少拥有其中一个角色，否则返回StatusForbidden
+func (handler *TypedHandler) WithRoles(roles ...string) *TypedHandler {
+	handler.roles = roles
+	return handler
+}
+
+// WithTimeout set the deadline of the handler, the same as IActionTimeout, it overrides AppConfig.ActionTimeout
+// 设置处理函数的超时时间，与IActionTimeout相同，优先于AppConfig.ActionTimeout
+func (handler *TypedHandler) WithTimeout(timeout time.Duration) *TypedHandler {
+	handler.timeout = timeout
+	return handler
+}
+
+// Handle register the typed handler to the payload type in the format of `controller.action`
+// It lives alongside the controllers registered by Router, and takes priority over them
+// The middlewares added by UseController with the controller part of the payload type are applied
+// 注册payload type对应的处理函数，与Router注册的Controller共存且优先
+func (app *App) Handle(payloadType string, handler *TypedHandler) {
+	payloadType = normalizePayloadType(payloadType)
+	checkValidateTags(handler.paramType, "handler:"+payloadType)
+	app.handlerMap[payloadType] = handler
+	if handler.maxPayloadLength > 0 {
+		app.payloadLimits[payloadType] = handler.maxPayloadLength
+	} else {
+		delete(app.payloadLimits, payloadType)
+	}
+}
+
+// SetErrorMapper set the hook to map the errors returned by the typed handlers to the responses
+// 设置typed handler错误的转换函数
+func (app *App) SetErrorMapper(mapper ErrorMapper) {
+	app.errorMapper = mapper
+}
+
+// Run the typed handler and map its error to the response
+// 执行typed handler并转换错误
+func (app *App) dispatchTyped(request *Request, handler *TypedHandler) *ResponseBody {
+	//Check whether the user has the roles required by the handler, the same as the actions
+	//检查用户是否拥有处理函数所需的角色，与action相同
+	if len(handler.roles) > 0 && !hasAnyRole(request.User, handler.roles) {
+		return permissionDenied()
+	}
+	ctx := request.Ctx
+	timeout := handler.timeout
+	if timeout <= 0 {
+		timeout = app.actionTimeout(nil, request.Action)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	data, err := handler.handle(ctx, request.Payload)
+	if err != nil {
+		return app.mapError(request, err)
+	}
+	if data == nil {
+		data = struct{}{}
+	}
+	return &ResponseBody{
+		Status: StatusSuccess,
+		Data:   data,
+	}
+}
+
+// Map the error to the response, errors unknown to the default mapping are logged as internal errors
+// 将错误转换为返回数据，未知错误记录日志并返回内部错误
+func (app *App) mapError(request *Request, err error) *ResponseBody {
+	if app.errorMapper != nil {
+		if response := app.errorMapper(request, err); response != nil {
+			return response
+		}
+	}
+	response := &ResponseBody{
+		Status: StatusError,
+		Data:   struct{}{},
+	}
+	//The errors can be wrapped
+	//错误可以是被包装过的
+	var actionError *ActionError
+	var validationErrors ValidationErrors
+	var userError IUserError
+	switch {
+	case errors.As(err, &actionError):
+		response.Status = actionError.Status
+		response.Message = actionError.Message
+	case errors.As(err, &validationErrors):
+		response.Message = validationErrors.Error()
+		response.Data = validationErrors
+	case errors.As(err, &userError):
+		response.Message = userError.ShowError()
+	default:
+		app.Log.Error(err)
+		response.Status = StatusInternalError
+		response.Message = "Internal BackEnd error"
+	}
+	return response
+}
+
+// Make the controller part of the payload type lower case, the same as the routing of the controllers
+// 将payload type中的controller部分转为小写，与Controller的路由一致
+func normalizePayloadType(payloadType string) string {
+	strs := strings.Split(payloadType, ".")
+	if len(strs) < 2 {
+		panic("payload type should be in the format of `controller.action`: " + payloadType)
+	}
+	return strings.ToLower(strs[0]) + "." + strs[1]
+}
@@ -0,0 +1,46 @@
+//go:build go1.21
+// +build go1.21
+
+package gosocket
+
+import (
+	"context"
+	"reflect"
+)
+
+// The generic helpers of the typed handlers, they're built with Go 1.21 or later only,
+// so that the module keeps working with the older versions of Go, which use NewTypedHandler instead
+// typed handler的泛型函数，仅在Go 1.21及以上版本编译，以便模块仍支持旧版本的Go，旧版本使用NewTypedHandler
+
+// Typed create a TypedHandler from the function
+// The payload is decoded into Req and validated by its `validate` tags before the function is called,
+// and the returned Resp is sent as the data of the response
+// 创建TypedHandler，payload解码到Req并校验后调用函数，返回的Resp作为返回数据
+func Typed[Req any, Resp any](handler func(ctx context.Context, req *Req) (*Resp, error)) *TypedHandler {
+	return &TypedHandler{
+		paramType:    reflect.TypeOf((*Req)(nil)).Elem(),
+		responseType: reflect.TypeOf((*Resp)(nil)).Elem(),
+		handle: func(ctx context.Context, payload string) (interface{}, error) {
+			req := new(Req)
+			if err := decodeTypedParam(payload, req); err != nil {
+				return nil, err
+			}
+			resp, err := handler(ctx, req)
+			if err != nil || resp == nil {
+				return nil, err
+			}
+			return resp, nil
+		},
+	}
+}
+
+// Handle register the function to the payload type in the default TcpApp, see App.Handle and Typed
+//
+//	gosocket.Handle("chat.AddMessage", func(ctx context.Context, req *AddMessageReq) (*AddMessageResp, error) {
+//		return &AddMessageResp{MessageId: "1"}, nil
+//	})
+//
+// 在默认App中注册处理函数
+func Handle[Req any, Resp any](payloadType string, handler func(ctx context.Context, req *Req) (*Resp, error)) {
+	TcpApp.Handle(payloadType, Typed(handler))
+}
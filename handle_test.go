@@ -0,0 +1,185 @@
+package gosocket_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	gosocket "github.com/yankawayu/go-socket"
+	"github.com/yankawayu/go-socket/gosockettest"
+)
+
+type typedReq struct {
+	Name string `json:"name" validate:"required,max=5"`
+	Fail string `json:"fail"`
+}
+
+type typedResp struct {
+	Greeting string `json:"greeting"`
+}
+
+type userError string
+
+func (err userError) Error() string {
+	return string(err)
+}
+
+func (err userError) ShowError() string {
+	return "shown: " + string(err)
+}
+
+// Return the error named by Fail, so that each mapping can be reached from the payload
+func typedGreet(ctx context.Context, req *typedReq) (*typedResp, error) {
+	switch req.Fail {
+	case "action":
+		return nil, gosocket.NewActionError(gosocket.StatusForbidden, "not allowed")
+	case "wrapped":
+		return nil, fmt.Errorf("greet: %w", gosocket.NewActionError(gosocket.StatusForbidden, "wrapped"))
+	case "user":
+		return nil, userError("bad name")
+	case "internal":
+		return nil, errors.New("database is down")
+	case "nil":
+		return nil, nil
+	case "deadline":
+		if _, ok := ctx.Deadline(); !ok {
+			return nil, errors.New("no deadline")
+		}
+	}
+	return &typedResp{Greeting: "hello " + req.Name}, nil
+}
+
+func newTypedApp(handler *gosocket.TypedHandler) (*gosocket.App, *gosockettest.Recorder) {
+	app := gosocket.NewApp()
+	app.Log = gosockettest.NewRecorder()
+	app.Handle("Typed.Greet", handler)
+	return app, app.Log.(*gosockettest.Recorder)
+}
+
+func TestTypedHandlerResponses(t *testing.T) {
+	app, log := newTypedApp(gosocket.NewTypedHandler(typedGreet))
+	user := gosockettest.NewFakeUser(1)
+	cases := []struct {
+		name    string
+		payload string
+		status  gosocket.Status
+		message string
+	}{
+		{"success", `{"name":"bob"}`, gosocket.StatusSuccess, ""},
+		{"nil response", `{"name":"bob","fail":"nil"}`, gosocket.StatusSuccess, ""},
+		{"bad json", `{"name":`, gosocket.StatusError, "Failed to decode payload into param"},
+		{"invalid param", `{"name":"robert"}`, gosocket.StatusError, "name must be at most 5 characters"},
+		{"action error", `{"name":"bob","fail":"action"}`, gosocket.StatusForbidden, "not allowed"},
+		{"wrapped action error", `{"name":"bob","fail":"wrapped"}`, gosocket.StatusForbidden, "wrapped"},
+		{"user error", `{"name":"bob","fail":"user"}`, gosocket.StatusError, "shown: bad name"},
+		{"internal error", `{"name":"bob","fail":"internal"}`, gosocket.StatusInternalError, "Internal BackEnd error"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			response := gosockettest.CallApp(app, user, "typed.Greet", c.payload, nil)
+			if response.Status != c.status || response.Message != c.message {
+				t.Fatalf("response %+v, want status %d and message %q", response, c.status, c.message)
+			}
+		})
+	}
+	if errs := log.Errors(); len(errs) != 1 {
+		t.Fatalf("%d errors logged, want the internal one only", len(errs))
+	}
+}
+
+func TestTypedHandlerData(t *testing.T) {
+	app, _ := newTypedApp(gosocket.NewTypedHandler(typedGreet))
+	user := gosockettest.NewFakeUser(1)
+	response := gosockettest.CallApp(app, user, "typed.Greet", `{"name":"bob"}`, nil)
+	if resp, ok := response.Data.(*typedResp); !ok || resp.Greeting != "hello bob" {
+		t.Fatalf("data %#v", response.Data)
+	}
+	//The data of the empty responses is an empty object rather than null
+	response = gosockettest.CallApp(app, user, "typed.Greet", `{"name":"bob","fail":"nil"}`, nil)
+	if gosocket.JSONEncode(response.Data) != "{}" {
+		t.Fatalf("data of the nil response %#v", response.Data)
+	}
+	response = gosockettest.CallApp(app, user, "typed.Greet", `{}`, nil)
+	if errs, ok := response.Data.(gosocket.ValidationErrors); !ok || len(errs) != 1 || errs[0].Field != "name" || errs[0].Rule != "required" {
+		t.Fatalf("data of the invalid param %#v", response.Data)
+	}
+}
+
+func TestTypedHandlerRolesAndTimeout(t *testing.T) {
+	app, _ := newTypedApp(gosocket.NewTypedHandler(typedGreet).WithRoles("admin").WithTimeout(time.Second))
+	payload := `{"name":"bob","fail":"deadline"}`
+	if response := gosockettest.CallApp(app, gosockettest.NewFakeUser(1), "typed.Greet", payload, nil); response.Status != gosocket.StatusForbidden {
+		t.Fatalf("response %+v without the role", response)
+	}
+	if response := gosockettest.CallApp(app, gosockettest.NewFakeUser(1, "admin"), "typed.Greet", payload, nil); response.Status != gosocket.StatusSuccess {
+		t.Fatalf("response %+v with the role and the timeout", response)
+	}
+}
+
+func TestErrorMapper(t *testing.T) {
+	app, log := newTypedApp(gosocket.NewTypedHandler(typedGreet))
+	app.SetErrorMapper(func(request *gosocket.Request, err error) *gosocket.ResponseBody {
+		if err.Error() != "database is down" {
+			return nil
+		}
+		return &gosocket.ResponseBody{Status: gosocket.StatusError, Message: "try again later " + request.Type}
+	})
+	user := gosockettest.NewFakeUser(1)
+	if response := gosockettest.CallApp(app, user, "typed.Greet", `{"name":"bob","fail":"internal"}`, nil); response.Status != gosocket.StatusError || response.Message != "try again later typed.Greet" {
+		t.Fatalf("mapped response %+v", response)
+	}
+	//Returning nil falls back to the default mapping
+	if response := gosockettest.CallApp(app, user, "typed.Greet", `{"name":"bob","fail":"action"}`, nil); response.Status != gosocket.StatusForbidden || response.Message != "not allowed" {
+		t.Fatalf("default response %+v", response)
+	}
+	if errs := log.Errors(); len(errs) != 0 {
+		t.Fatalf("errors logged: %v", errs)
+	}
+}
+
+func TestNewTypedHandlerRejectsBadSignatures(t *testing.T) {
+	handlers := []interface{}{
+		nil,
+		"not a function",
+		func(req *typedReq) (*typedResp, error) { return nil, nil },
+		func(ctx context.Context, req typedReq) (*typedResp, error) { return nil, nil },
+		func(ctx context.Context, req *typedReq) *typedResp { return nil },
+		func(ctx context.Context, req *typedReq) (typedResp, error) { return typedResp{}, nil },
+		func(ctx context.Context, req *typedReq) (*typedResp, string) { return nil, "" },
+	}
+	for i, handler := range handlers {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("handler %d is accepted", i)
+				}
+			}()
+			gosocket.NewTypedHandler(handler)
+		}()
+	}
+}
+
+func TestActionErrorPanickedInController(t *testing.T) {
+	app := gosocket.NewApp()
+	app.Router("panic", &panicController{})
+	response := gosockettest.CallApp(app, gosockettest.NewFakeUser(1), "panic.Do", `{}`, nil)
+	if response.Status != gosocket.StatusForbidden || response.Message != "panicked" {
+		t.Fatalf("response %+v", response)
+	}
+}
+
+type panicController struct {
+	gosocket.Controller
+}
+
+func (controller *panicController) GetActionParamMap() map[string]interface{} {
+	return map[string]interface{}{
+		"Do": &struct{}{},
+	}
+}
+
+func (controller *panicController) Do(param *struct{}, response *gosocket.ResponseBody) {
+	panic(gosocket.NewActionError(gosocket.StatusForbidden, "panicked"))
+}
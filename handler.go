@@ -1,19 +1,17 @@
 package gosocket
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/yankawayu/go-socket/packet"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"reflect"
+	"sync"
 	"time"
 )
 
-var (
-	authUser IUser
-)
-
 const (
 	kAccessLogType     = "type"
 	kAccessLogIp       = "ip"
@@ -22,17 +20,17 @@ const (
 	kAccessLogStatus   = "status"
 	kAccessLogMessage  = "message"
 	kAccessLogDuration = "duration"
+	kAccessLogConnId   = "conn_id"
+	kAccessLogTraceId  = "trace_id"
 )
 
-// Set a class that implements IUser to do identification in the login process
-// 设置登陆验证类
-func setAuthUser(user IUser) {
-	authUser = user
-}
-
 // MessageHandler is the class responsible for processing messages from client and generating responses messages
 // This class is an essential member of ClientConn, it handles most of the time-consuming works
 type MessageHandler struct {
+	// The app which the connection belongs to
+	//所属的App
+	app *App
+
 	// Used to store and validate user information
 	//用户信息
 	user IUser
@@ -40,29 +38,82 @@ type MessageHandler struct {
 	// Used to store all the messages that about to be sent
 	//发出消息任务队列
 	jobChan chan Job
+	// Sending to jobChan holds the read lock, closing it holds the write lock, so that it's never sent to once closed
+	//发送时持有读锁，关闭时持有写锁，确保关闭后不再发送
+	jobLock   sync.RWMutex
+	jobClosed bool // whether jobChan is closed, protected by jobLock
 	// Used to store all the messages that come from the Reading thread
 	//收到消息任务队列
 	workChan chan packet.IMessage
 
-	ip     string // client ip
-	isStop bool   // whether the handler has stopped
+	ip          string    // client ip
+	refreshTime time.Time // the last time the online status was refreshed
+
+	stopLock  sync.Mutex // protect isStop and isKickOut, Stop can be called by any goroutine
+	isStop    bool       // whether the handler has stopped
+	isKickOut bool       // whether the handler is stopped by being kicked out
+
+	// Queue the messages into the engine instead of jobChan by the outbound policy, used by the engines without a writer thread
+	//按发出策略将消息交给引擎，不经过发出消息任务队列，用于没有写线程的引擎
+	queueMessage func(message packet.IMessage, receipt *Receipt, options *SubmitOptions) SubmitStatus
+	// Called once the handler stops, used by the engines to close the connection
+	//停止时调用，用于引擎关闭连接
+	onStop func()
+	// Close the connection, used to disconnect the slow consumer
+	//关闭连接，用于断开处理不过来的连接
+	closeConn func()
+
+	coalesceLock  sync.Mutex               // protect coalesceSlots
+	coalesceSlots map[string]*coalesceSlot // the messages in jobChan that can be replaced, see PolicyCoalesce
+
+	connId string             // the unique id of the connection
+	device Device             // the device of the session, see IDeviceUser
+	ctx    context.Context    // the parent context of all the requests, cancelled once the handler stops
+	cancel context.CancelFunc // used to cancel ctx
+
+	// The requests in process, only used if AppConfig.MaxConcurrentRequests is greater than 1
+	//正在处理的请求，仅在AppConfig.MaxConcurrentRequests大于1时使用
+	workers     chan struct{}                   // a slot for each running worker
+	workerGroup sync.WaitGroup                  // wait for the workers before logging out and closing the job queue
+	orderLock   sync.Mutex                      // protect orderQueues
+	orderQueues map[string]chan *packet.SendReq // the queues of the ordering keys with running workers
 }
 
+// NewMessageHandler create a handler of the default TcpApp
 func NewMessageHandler(jobChan chan Job, ip string) *MessageHandler {
+	return TcpApp.newMessageHandler(jobChan, ip)
+}
+
+func (app *App) newMessageHandler(jobChan chan Job, ip string) *MessageHandler {
 	handler := &MessageHandler{
-		jobChan:  jobChan,
-		workChan: make(chan packet.IMessage, kQueueLength),
-		ip:       ip,
-		isStop:   false,
+		app:         app,
+		jobChan:     jobChan,
+		ip:          ip,
+		refreshTime: time.Now(),
+		connId:      newUniqueId(),
+	}
+	//The engines without a writer thread don't have a handling thread either
+	//没有写线程的引擎也没有处理线程
+	if jobChan != nil {
+		handler.workChan = make(chan packet.IMessage, app.queueLength())
+	}
+	if maxConcurrent := app.maxConcurrentRequests(); maxConcurrent > 1 {
+		handler.workers = make(chan struct{}, maxConcurrent)
+		handler.orderQueues = make(map[string]chan *packet.SendReq)
+	}
+	handler.ctx, handler.cancel = context.WithCancel(context.WithValue(context.Background(), kContextKeyConnId, handler.connId))
+	if app.newUser != nil {
+		handler.user = app.newUser()
+		return handler
 	}
 	//验证
-	userReflectVal := reflect.ValueOf(authUser)
+	userReflectVal := reflect.ValueOf(app.authUser)
 	userType := reflect.Indirect(userReflectVal).Type()
 	//获取user
 	user := reflect.New(userType)
 	execUser, ok := user.Interface().(IUser)
 	if !ok {
-		TcpApp.Log.Error("controller is not IController")
+		app.Log.Error("user is not IUser")
 	}
 	handler.user = execUser
 	return handler
@@ -73,82 +124,41 @@ func NewMessageHandler(jobChan chan Job, ip string) *MessageHandler {
 func (handler *MessageHandler) Start() {
 	defer func() {
 		if err := recover(); err != nil {
-			TcpApp.Log.Error(err)
+			handler.app.Log.Error(err)
 		}
 	}()
 	defer func() {
+		//Stop if the loop exits by itself, such as the client disconnecting, it returns directly if it's stopped already
+		//如果循环自行退出（如客户端断开），停止处理，已经停止过则直接返回
+		handler.Stop(false)
+		//Log out once the requests in process respond
+		//等待正在处理的请求答复后注销
+		handler.finish()
 		//关闭发消息任务队列
-		if handler.jobChan != nil {
-			close(handler.jobChan)
-			handler.jobChan = nil
-		}
+		handler.closeJobs()
 	}()
-	//维持在线状态的时间
-	refreshTime := time.Now()
+	if handler.workChan == nil {
+		return
+	}
+	//Check the restart signal every second, even if there is no message
+	//即使没有消息，也每秒检查一次重启信号
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	for {
-		if handler.workChan == nil {
-			return
-		}
 		select {
-		case msg, isOpen := <-handler.workChan:
-			if !isOpen {
+		case msg := <-handler.workChan:
+			if !handler.handleMessage(msg) {
 				return
 			}
-			switch msg := msg.(type) {
-			case *packet.Connect:
-				if handler.handleConnect(msg) {
-
-				} else {
-					//登陆失败，跳出循环
-					return
-				}
-			case *packet.SendReq:
-				if handler.user.IsLogin() {
-					handler.handleSendReq(msg)
-				} else {
-					//TcpApp.Log.Debug("ping request receive without login, disconnect...")
-					return
-				}
-			case *packet.PingReq:
-				if handler.user.IsLogin() {
-					handler.handlePingReq(msg)
-				} else {
-					//TcpApp.Log.Debug("ping request receive without login, disconnect...")
-					return
-				}
-			case *packet.Disconnect:
-				//断开连接
-				//TcpApp.Log.Debug("disconnect received")
-				return
-			case *packet.ConnAck, *packet.PingResp, *packet.SendResp:
-				//服务器不应该收到的消息类型，断开连接
-				TcpApp.Log.Debug("invalid message type, disconnect")
-				return
-			default:
-				//未知消息类型
-				TcpApp.Log.Debug("read unknown message type, disconnect...", msg)
-				return
-			}
-			//如果已登陆
-			if handler.user.IsLogin() {
-				//距离上一次刷新超过3分钟，在线状态的过期时间必须大于3+1分钟，目前状态过期时间是5分钟
-				if time.Since(refreshTime) > 3*time.Minute {
-					//刷新在线状态
-					handler.user.Refresh()
-					//更新刷新时间
-					refreshTime = time.Now()
-				}
-			}
-		case <-time.After(time.Second):
-			break
+		case <-handler.ctx.Done():
+			//Stopped by Stop, such as being kicked out
+			//被Stop停止，如被踢出
+			return
+		case <-ticker.C:
 		}
 		// If the main process received restart signal and both queues have no data to process
 		// 判断. 若需要退出, 且此时读写队列都没有数据了, 则断开链接
-		isStop := false
-		if GetRestartManager() != nil {
-			isStop = GetRestartManager().IsStop()
-		}
-		if isStop &&
+		if handler.app.isRestarting() &&
 			len(handler.jobChan) <= 0 &&
 			len(handler.workChan) <= 0 {
 			break
@@ -156,34 +166,136 @@ func (handler *MessageHandler) Start() {
 	}
 }
 
-// Stop handling messages
-// 停止处理消息
+// Close jobChan so that the writing thread exits, the senders blocked by PolicyBlock return once the handler stops
+// 关闭发消息任务队列使写线程退出，被PolicyBlock阻塞的发送者在停止后返回
+func (handler *MessageHandler) closeJobs() {
+	if handler.jobChan == nil {
+		return
+	}
+	handler.jobLock.Lock()
+	defer handler.jobLock.Unlock()
+	if !handler.jobClosed {
+		handler.jobClosed = true
+		close(handler.jobChan)
+	}
+}
+
+// Handle a message from the client, returns false if the connection should be closed
+// It's shared by all the engines, the messages of a connection are passed in one by one
+// 处理一条客户端消息，返回false时应断开连接，所有引擎共用，同一连接的消息逐条传入
+func (handler *MessageHandler) handleMessage(msg packet.IMessage) bool {
+	switch msg := msg.(type) {
+	case *packet.Connect:
+		if !handler.handleConnect(msg) {
+			//登陆失败，跳出循环
+			return false
+		}
+	case *packet.SendReq:
+		if !handler.user.IsLogin() {
+			//TcpApp.Log.Debug("ping request receive without login, disconnect...")
+			return false
+		}
+		if handler.workers != nil {
+			handler.dispatchSendReq(msg)
+		} else {
+			handler.handleSendReq(msg)
+		}
+	case *packet.PingReq:
+		if !handler.user.IsLogin() {
+			//TcpApp.Log.Debug("ping request receive without login, disconnect...")
+			return false
+		}
+		handler.handlePingReq(msg)
+	case *packet.Disconnect:
+		//断开连接
+		//TcpApp.Log.Debug("disconnect received")
+		return false
+	case *packet.ConnAck, *packet.PingResp, *packet.SendResp:
+		//服务器不应该收到的消息类型，断开连接
+		handler.app.Log.Debug("invalid message type, disconnect")
+		return false
+	default:
+		//未知消息类型
+		handler.app.Log.Debug("read unknown message type, disconnect...", msg)
+		return false
+	}
+	//如果已登陆
+	if handler.user.IsLogin() {
+		//距离上一次刷新超过AppConfig.RefreshInterval（默认3分钟），在线状态的过期时间必须大于该间隔+1分钟
+		if time.Since(handler.refreshTime) > handler.app.refreshInterval() {
+			//刷新在线状态
+			handler.user.Refresh()
+			//更新刷新时间
+			handler.refreshTime = time.Now()
+		}
+	}
+	return true
+}
+
+// Stop handling messages, it's safe to be called by any goroutine and more than once
+// The user is logged out by the handling thread once the requests in process respond
+// 停止处理消息，可以被任意协程多次调用，处理线程在正在处理的请求答复后注销用户
 func (handler *MessageHandler) Stop(isKickOut bool) {
 	//If the handler is already stopped, then return directly to avoid being called twice
 	//如果停止过了，直接返回，避免stop在短时间内两次调用导致用户在线状态被清空
+	handler.stopLock.Lock()
 	if handler.isStop {
+		handler.stopLock.Unlock()
 		return
 	}
 	handler.isStop = true
-	//If the work channel hasn't been closed, close it now
-	//如果工作队列未关闭，关闭
-	if handler.workChan != nil {
-		close(handler.workChan)
-		handler.workChan = nil
+	handler.isKickOut = isKickOut
+	handler.stopLock.Unlock()
+	//Cancel all the requests in process, the handling thread exits as well
+	//取消所有正在处理的请求，处理线程也随之退出
+	handler.cancel()
+	//The engines without a handling thread log out here, don't wait for the workers in the caller since it may be one of them
+	//没有处理线程的引擎在此注销，调用者可能就是工作协程，所以不在调用者中等待
+	if handler.workChan == nil {
+		if handler.workers != nil {
+			go handler.finish()
+		} else {
+			handler.finish()
+		}
 	}
+	if handler.onStop != nil {
+		handler.onStop()
+	}
+}
+
+// Log the user out after the workers exit, it's called once the handler stops
+// 工作协程退出后注销用户，在停止后调用
+func (handler *MessageHandler) finish() {
+	handler.workerGroup.Wait()
 	//If the user has logged in before
 	//如果已登陆，注销
-	if handler.user.IsLogin() {
-		//Make sure that the connection wasn't kicked out by himself before removing the online status
-		//如果不是被同一账号登陆踢出
-		//否则可能会移除掉最新登陆的状态
-		if !isKickOut {
-			//Remove online status
-			//移除本地记录的在线状态
-			GetClientPool().RemoveClientByUid(handler.user.GetUid())
-		}
-		handler.user.Logout(isKickOut)
+	if !handler.user.IsLogin() {
+		return
 	}
+	handler.stopLock.Lock()
+	isKickOut := handler.isKickOut
+	handler.stopLock.Unlock()
+	//Only remove this session, the kicked out one is removed already and the others of the user aren't affected
+	//只移除当前会话，被踢出的会话已经移除，不影响该用户的其他会话
+	stillOnline := handler.app.ClientPool().removeSession(handler, handler.user.GetUid())
+	//Mark the user is offline in the cluster if it's the last session on the current node
+	//如果是当前节点的最后一个会话，在集群中标记用户下线
+	handler.app.syncPresence(handler.user.GetUid())
+	//The user is still online if there are other sessions, so it's treated the same as being kicked out
+	//如果还有其他会话，用户仍在线，与被踢出同样处理，以免移除在线状态
+	handler.user.Logout(isKickOut || stillOnline)
+}
+
+// ConnId returns the unique id of the connection
+// 连接的唯一id
+func (handler *MessageHandler) ConnId() string {
+	return handler.connId
+}
+
+// Device returns the device of the session, see IDeviceUser
+// 会话的设备
+func (handler *MessageHandler) Device() Device {
+	return handler.device
 }
 
 // Handle the connect message
@@ -193,7 +305,7 @@ func (handler *MessageHandler) handleConnect(msg *packet.Connect) (isConnect boo
 	var returnCode packet.ReturnCode
 	defer func() {
 		if err := recover(); err != nil {
-			TcpApp.Log.Error(err)
+			handler.app.Log.Error(err)
 			returnCode = packet.RetCodeServerUnavailable
 		}
 		//发送连接回执
@@ -215,6 +327,7 @@ func (handler *MessageHandler) handleConnect(msg *packet.Connect) (isConnect boo
 		connectInfo := []zapcore.Field{
 			zap.String(kAccessLogIp, handler.ip),
 			zap.Int64(kAccessLogUid, handler.user.GetUid()),
+			zap.String(kAccessLogConnId, handler.connId),
 			zap.String(kAccessLogParams, msg.Payload),
 			zap.Uint8(kAccessLogStatus, uint8(returnCode)),
 			zap.String(kAccessLogMessage, message),
@@ -222,38 +335,45 @@ func (handler *MessageHandler) handleConnect(msg *packet.Connect) (isConnect boo
 		}
 		//Add custom connect info
 		connectInfo = append(connectInfo, handler.user.GetConnectInfo()...)
-		TcpApp.FastLog.Info("connect", connectInfo...)
+		handler.app.FastLog.Info("connect", connectInfo...)
 	}()
 	//获取用户信息
 	var uid int64
 	uid, returnCode = handler.user.Auth(msg.Payload, handler.ip)
+	if deviceUser, ok := handler.user.(IDeviceUser); ok && returnCode == packet.RetCodeAccepted {
+		handler.device = deviceUser.GetDevice()
+	}
+	//The connection is its own device if the device is unknown, so that the sessions of SessionAllowMulti don't kick each other out
+	//设备未知时以连接作为设备，以免SessionAllowMulti的会话互相踢出
+	if handler.device.Id == "" {
+		handler.device.Id = handler.connId
+	}
 	if returnCode == packet.RetCodeAccepted && uid != 0 {
 		//We need locks here to avoid the situation of same account trying to log in from different devices simultaneously
 		//获取锁
 		hasLock := handler.user.RequireLock(uid)
-		if hasLock {
+		policy := handler.app.sessionPolicy()
+		if hasLock && policy == SessionRejectNew &&
+			(handler.app.ClientPool().GetClientByUid(uid) != nil || len(handler.app.remoteNodes(uid)) > 0) {
+			//The user is online on the current server or another node of the cluster, reject the new session before logging in
+			//用户已在当前服务器或集群其他节点上在线，登陆前拒绝新会话
+			returnCode = packet.RetCodeConcurrentLogin
+			handler.user.ReleaseLock(uid)
+		} else if hasLock {
 			//验证登陆信息
 			returnCode = handler.user.Login(uid)
 			//如果登陆成功，在当前服务器上记录在线状态
 			if returnCode == packet.RetCodeAccepted {
-				//Check whether there is another connection with the same user on the current server
-				oldHandler := GetClientPool().GetClientByUid(handler.user.GetUid())
-				//If the user is connecting on the current server on another connection
-				//如果之前连接过，说明在新旧连接在同一台服务器上，需要将旧的连接移除
-				if oldHandler != nil {
-					//Send KickOut message to remove the old connection
-					//通知客户端连接断开
-					msgDisconnect := &packet.Disconnect{
-						Type: packet.DiscTypeKickout,
-					}
-					oldHandler.Submit(msgDisconnect)
-					//停止处理消息
-					oldHandler.Stop(true)
-					TcpApp.Log.Debugf("kick out same server account %d", uid)
+				//Add the session, the old sessions on the current server are kicked out according to AppConfig.SessionPolicy
+				//加入会话，按AppConfig.SessionPolicy踢出当前服务器上的旧会话
+				kicked := handler.app.ClientPool().addSession(handler, handler.user.GetUid(), policy, handler.app.maxSessionsPerUser())
+				for _, oldHandler := range kicked {
+					handler.app.kickOut(oldHandler, true)
 				}
-				//Mark the user with the latest connection
-				//设置最新的在线状态
-				GetClientPool().SetClientByUid(handler, handler.user.GetUid())
+				//Kick the old sessions on the other nodes of the cluster out, and mark the user is online on the current node
+				//踢出集群其他节点上的旧会话，并标记用户在当前节点在线
+				handler.app.kickRemoteSessions(handler.ctx, uid, handler.device)
+				handler.app.syncPresence(uid)
 			}
 			//释放锁
 			handler.user.ReleaseLock(uid)
@@ -269,7 +389,7 @@ func (handler *MessageHandler) handleConnect(msg *packet.Connect) (isConnect boo
 func (handler *MessageHandler) handleSendReq(msg *packet.SendReq) {
 	defer func() {
 		if err := recover(); err != nil {
-			TcpApp.Log.Error(err)
+			handler.app.Log.Error(err)
 		}
 	}()
 	switch msg.ReplyLevel {
@@ -285,17 +405,23 @@ func (handler *MessageHandler) handleSendReq(msg *packet.SendReq) {
 			zap.String(kAccessLogType, msg.Type),
 			zap.String(kAccessLogIp, handler.ip),
 			zap.Int64(kAccessLogUid, handler.user.GetUid()),
+			zap.String(kAccessLogConnId, handler.connId),
 			zap.String(kAccessLogParams, msg.Payload),
 			zap.String(kAccessLogDuration, processDuration),
 		}
 		//添加自定义请求信息
 		sendReqInfo = append(sendReqInfo, handler.user.GetSendReqInfo()...)
-		TcpApp.FastLog.Info("sendReqNoReply", sendReqInfo...)
+		handler.app.FastLog.Info("sendReqNoReply", sendReqInfo...)
 	//Messages that need to be replied
 	case packet.RLevelReplyLater:
 		startTime := time.Now()
+		//The context of the request, cancelled once the handler stops
+		//请求上下文，连接断开后被取消
+		traceId := newUniqueId()
+		ctx := context.WithValue(handler.ctx, kContextKeyMessageId, msg.MessageId)
+		ctx = context.WithValue(ctx, kContextKeyTraceId, traceId)
 		//业务逻辑
-		response := ProcessPayloadWithData(handler.user, msg.Type, msg.Payload, msg.Data)
+		response := handler.app.ProcessPayloadWithContext(ctx, handler.user, msg.Type, msg.Payload, msg.Data)
 		//To find out whether there are slow requests
 		//处理时间
 		processDuration := fmt.Sprintf("%.3f", float32(time.Since(startTime))/float32(time.Second))
@@ -319,6 +445,8 @@ func (handler *MessageHandler) handleSendReq(msg *packet.SendReq) {
 			zap.String(kAccessLogType, msg.Type),
 			zap.String(kAccessLogIp, handler.ip),
 			zap.Int64(kAccessLogUid, handler.user.GetUid()),
+			zap.String(kAccessLogConnId, handler.connId),
+			zap.String(kAccessLogTraceId, traceId),
 			zap.Any(kAccessLogParams, tmpMap),
 			zap.Uint8(kAccessLogStatus, uint8(response.Status)),
 			zap.String(kAccessLogMessage, response.Message),
@@ -326,13 +454,13 @@ func (handler *MessageHandler) handleSendReq(msg *packet.SendReq) {
 		}
 		//Add custom request info
 		sendReqInfo = append(sendReqInfo, handler.user.GetSendReqInfo()...)
-		TcpApp.FastLog.Info("sendReq", sendReqInfo...)
+		handler.app.FastLog.Info("sendReq", sendReqInfo...)
 		//答复结果
 		sendResp := &packet.SendResp{
 			MessageId: msg.MessageId,
 			Payload:   JSONEncode(response),
 		}
-		handler.Submit(sendResp)
+		handler.submitAsync(sendResp)
 	}
 }
 
@@ -340,57 +468,81 @@ func (handler *MessageHandler) handleSendReq(msg *packet.SendReq) {
 // 心跳消息
 func (handler *MessageHandler) handlePingReq(msg *packet.PingReq) {
 	pingResp := &packet.PingResp{}
-	handler.Submit(pingResp)
+	handler.submitAsync(pingResp)
+}
+
+// PushNotify Send push message to the client, see Submit for the status and the receipt
+// The receipt tells whether the push is delivered to the socket, e.g. store it for offline users if not:
+//
+//	_, receipt := handler.PushNotify("chat.NewMessage", message)
+//	if receipt == nil || receipt.WaitTimeout(5*time.Second) != nil {
+//		storeOffline(uid, message)
+//	}
+//
+// 发推送到客户端，返回值同Submit
+func (handler *MessageHandler) PushNotify(notifyType string, body interface{}) (SubmitStatus, *Receipt) {
+	return handler.PushNotifyWithOptions(notifyType, body, nil)
 }
 
-// PushNotify Send push message to the client
-// 发推送到客户端
-func (handler *MessageHandler) PushNotify(notifyType string, body interface{}) {
+// PushNotifyWithOptions is the same as PushNotify except that the options override the outbound policy
+// e.g. coalesce the pushes of the same type so that only the latest one is sent:
+//
+//	handler.PushNotifyWithOptions("room.Members", members, &gosocket.SubmitOptions{Policy: gosocket.PolicyCoalesce})
+//
+// 与PushNotify相同，但使用指定的发出策略
+func (handler *MessageHandler) PushNotifyWithOptions(notifyType string, body interface{}, options *SubmitOptions) (SubmitStatus, *Receipt) {
 	msgReq := &packet.SendReq{
 		Type:       notifyType,
 		Payload:    JSONEncode(body),
 		ReplyLevel: packet.RLevelNoReply,
 	}
-	handler.Submit(msgReq)
+	return handler.SubmitWithOptions(msgReq, options)
+}
+
+// Submit Send message asynchronously, if the queue is full then it's handled by AppConfig.OutboundPolicy
+// It returns whether the message is queued, and a receipt resolved once the message is flushed to the socket
+// or fails to be sent. The receipt is nil if the message is dropped or the connection is closed
+// 发送消息，异步进行，如果任务队列满了则按AppConfig.OutboundPolicy处理
+// 返回消息是否加入队列，以及消息写入socket或发送失败后完成的回执，消息被丢弃或连接已关闭时回执为nil
+func (handler *MessageHandler) Submit(message packet.IMessage) (SubmitStatus, *Receipt) {
+	return handler.SubmitWithOptions(message, nil)
 }
 
-// Submit Send message asynchronously, if the queue is full then ignore the message and log error
-// 发送消息，异步进行，消息发送成功就返回，如果任务队列满了则忽略消息
-func (handler *MessageHandler) Submit(message packet.IMessage) {
+// SubmitWithOptions is the same as Submit except that the options override the outbound policy, nil means the default one
+// 与Submit相同，但使用指定的发出策略，nil表示默认策略
+func (handler *MessageHandler) SubmitWithOptions(message packet.IMessage, options *SubmitOptions) (SubmitStatus, *Receipt) {
 	job := Job{
 		Message: message,
+		Receipt: newReceipt(),
 	}
-	//Make sure the channel is opened
-	if handler.jobChan != nil {
-		//Ignore the message if the queue is full
-		select {
-		case handler.jobChan <- job:
-		default:
-			fullMessage := fmt.Sprintf("%d's job queue full", handler.user.GetUid())
-			TcpApp.Log.Error(fullMessage)
-		}
+	var status SubmitStatus
+	if handler.queueMessage != nil {
+		status = handler.queueMessage(job.Message, job.Receipt, options)
+	} else {
+		status = handler.enqueueJob(job, options)
 	}
+	if status == SubmitDropped || status == SubmitClosed {
+		return status, nil
+	}
+	return status, job.Receipt
 }
 
-// Send message synchronously, if the queue is full then wait until the message is sent
-// 发送消息，同步进行，只有消息发送成功且被处理完之后才返回，如果队列满了则等待
-func (handler *MessageHandler) submitSync(message packet.IMessage) {
-	job := Job{
-		Message: message,
-		Receipt: make(Receipt),
+// Send message asynchronously without a receipt, such as the responses
+// 发送消息，异步进行，不需要回执，如请求的答复
+func (handler *MessageHandler) submitAsync(message packet.IMessage) {
+	if handler.queueMessage != nil {
+		handler.queueMessage(message, nil, nil)
+		return
 	}
-	//Make sure the channel is opened
-	if handler.jobChan != nil {
-		//The select block is essential here or else there could be a deadlock
-		//加入任务队列，必须判断channel是否满，否则会死锁
-		select {
-		case handler.jobChan <- job:
-			//Block until the message is sent
-			//阻塞直到消息发送完成
-			job.Receipt.Wait()
-		default:
-			fullMessage := fmt.Sprintf("%d's job queue full", handler.user.GetUid())
-			TcpApp.Log.Error(fullMessage)
-		}
+	handler.enqueueJob(Job{Message: message}, nil)
+}
+
+// Send message synchronously, if the queue is full then it's handled by AppConfig.OutboundPolicy
+// 发送消息，同步进行，只有消息发送成功且被处理完之后才返回，如果队列满了则按AppConfig.OutboundPolicy处理
+func (handler *MessageHandler) submitSync(message packet.IMessage) {
+	if _, receipt := handler.Submit(message); receipt != nil {
+		//Block until the message is sent
+		//阻塞直到消息发送完成
+		_ = receipt.Wait()
 	}
 }
@@ -0,0 +1,130 @@
+package gosocket
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The default limits used when the fields of AppConfig are 0
+// AppConfig中的字段为0时使用的默认值
+const (
+	kDefaultReadTimeoutFactor  = 1.5
+	kDefaultRefreshInterval    = 3 * time.Minute
+	kDefaultTcpKeepAlivePeriod = time.Minute
+)
+
+// IActionPayloadLimit can be implemented by controllers to set the max payload length of their actions in bytes
+// It overrides IUserLimits and AppConfig.MaxPayloadLength, e.g. for the actions uploading files
+// 设置action的最大请求长度（字节），优先于IUserLimits和AppConfig.MaxPayloadLength，如上传文件的action
+type IActionPayloadLimit interface {
+	GetActionPayloadLimitMap() map[string]int
+}
+
+// IUserLimits can be implemented by the auth class to override the limits of the app for a class of users
+// It's called after the user logs in
+// 实现此接口可为某类用户覆盖App的限制，登陆后调用
+type IUserLimits interface {
+	// GetUserLimits returns the limits of the current user, nil means the ones of the app
+	// 当前用户的限制，nil表示使用App的限制
+	GetUserLimits() *UserLimits
+}
+
+// UserLimits is the limits of a class of users, the zero fields are not overridden
+// 某类用户的限制，为0的字段不覆盖
+type UserLimits struct {
+	// MaxPayloadLength is the max payload length of the requests in bytes, the limits of the actions take priority
+	//请求的最大长度（字节），action的限制优先
+	MaxPayloadLength int
+}
+
+// Validate checks the config, it's called by Run and Serve
+// The zero values are valid, they mean the default ones
+// 检查配置，Run和Serve会调用，0值表示使用默认值
+func (config *AppConfig) Validate() error {
+	switch {
+	case config.ActionTimeout < 0:
+		return errors.New("ActionTimeout can't be negative")
+	case config.MaxConcurrentRequests < 0:
+		return errors.New("MaxConcurrentRequests can't be negative")
+	case config.Engine != EngineGoroutine && config.Engine != EngineEpoll:
+		return fmt.Errorf("unknown Engine %d", config.Engine)
+	case config.EngineWorkers < 0:
+		return errors.New("EngineWorkers can't be negative")
+	case config.InboundPolicy > PolicyDisconnect || config.OutboundPolicy > PolicyDisconnect:
+		return errors.New("unknown backpressure policy")
+	case config.InboundPolicy == PolicyCoalesce:
+		return errors.New("PolicyCoalesce only applies to OutboundPolicy")
+	case config.BackpressureTimeout < 0:
+		return errors.New("BackpressureTimeout can't be negative")
+	case config.QueueLength < 0:
+		return errors.New("QueueLength can't be negative")
+	case config.MaxPayloadLength < 0:
+		return errors.New("MaxPayloadLength can't be negative")
+	case config.ReadTimeoutFactor != 0 && config.ReadTimeoutFactor < 1:
+		return errors.New("ReadTimeoutFactor should be at least 1")
+	case config.RefreshInterval < 0:
+		return errors.New("RefreshInterval can't be negative")
+	case config.ReadBufferSize < 0 || config.WriteBufferSize < 0:
+		return errors.New("buffer size can't be negative")
+	case config.ClientPoolShards < 0:
+		return errors.New("ClientPoolShards can't be negative")
+	case config.SessionPolicy > SessionPerDeviceClass:
+		return errors.New("unknown SessionPolicy")
+	case config.MaxSessionsPerUser < 0:
+		return errors.New("MaxSessionsPerUser can't be negative")
+	}
+	return nil
+}
+
+// The length of the queues of a connection
+// 连接的队列长度
+func (app *App) queueLength() int {
+	if app.Config == nil || app.Config.QueueLength <= 0 {
+		return kQueueLength
+	}
+	return app.Config.QueueLength
+}
+
+// The max payload length of the request, the limit of the action takes priority over the one of the user
+// 请求的最大长度，action的限制优先于用户的限制
+func (app *App) maxPayloadLength(user IUser, payloadType string) int {
+	if limit := app.payloadLimits[payloadType]; limit > 0 {
+		return limit
+	}
+	if limitsUser, ok := user.(IUserLimits); ok {
+		if limits := limitsUser.GetUserLimits(); limits != nil && limits.MaxPayloadLength > 0 {
+			return limits.MaxPayloadLength
+		}
+	}
+	if app.Config == nil || app.Config.MaxPayloadLength <= 0 {
+		return kMaxPayloadLength
+	}
+	return app.Config.MaxPayloadLength
+}
+
+// The read timeout of a connection with the keep alive time in seconds sent by the client, 0 means no timeout
+// 根据客户端发送的心跳间隔（秒）计算连接的读超时时间，0表示不超时
+func (app *App) readTimeout(keepAliveTime uint16) time.Duration {
+	factor := kDefaultReadTimeoutFactor
+	if app.Config != nil && app.Config.ReadTimeoutFactor > 0 {
+		factor = app.Config.ReadTimeoutFactor
+	}
+	return time.Duration(float64(keepAliveTime) * factor * float64(time.Second))
+}
+
+func (app *App) refreshInterval() time.Duration {
+	if app.Config == nil || app.Config.RefreshInterval <= 0 {
+		return kDefaultRefreshInterval
+	}
+	return app.Config.RefreshInterval
+}
+
+// The period of the keep alive of tcp, negative means disabled
+// tcp保活的间隔，负数表示关闭
+func (app *App) tcpKeepAlivePeriod() time.Duration {
+	if app.Config == nil || app.Config.TcpKeepAlivePeriod == 0 {
+		return kDefaultTcpKeepAlivePeriod
+	}
+	return app.Config.TcpKeepAlivePeriod
+}
@@ -0,0 +1,92 @@
+//go:build linux
+// +build linux
+
+package gosocket_test
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	gosocket "github.com/yankawayu/go-socket"
+	"github.com/yankawayu/go-socket/gosockettest"
+)
+
+// acceptRecorder passes the accepted tcp connections to the test, so that their options can be checked
+type acceptRecorder struct {
+	net.Listener
+	accepted chan *net.TCPConn
+}
+
+func (listener *acceptRecorder) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+	if err == nil {
+		listener.accepted <- conn.(*net.TCPConn)
+	}
+	return conn, err
+}
+
+func sockoptInt(t *testing.T, conn *net.TCPConn, level int, opt int) int {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value int
+	var optErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		value, optErr = syscall.GetsockoptInt(int(fd), level, opt)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if optErr != nil {
+		t.Fatal(optErr)
+	}
+	return value
+}
+
+func TestTcpKeepAlivePeriod(t *testing.T) {
+	cases := []struct {
+		name    string
+		config  *gosocket.AppConfig
+		enabled bool
+		idle    int
+	}{
+		{"default", nil, true, 60},
+		{"override", &gosocket.AppConfig{TcpKeepAlivePeriod: 7 * time.Second}, true, 7},
+		{"disabled", &gosocket.AppConfig{TcpKeepAlivePeriod: -1}, false, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			listener := &acceptRecorder{Listener: tcpListener, accepted: make(chan *net.TCPConn, 1)}
+			recorder := gosockettest.NewRecorder()
+			served := make(chan error, 1)
+			go func() {
+				served <- gosocket.NewApp().Serve(listener, c.config, recorder, recorder.FastLog())
+			}()
+			defer func() {
+				_ = listener.Close()
+				<-served
+			}()
+			clientConn, err := net.Dial("tcp", tcpListener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer clientConn.Close()
+			serverConn := <-listener.accepted
+			//The options are set once the accepted connection is returned to the server
+			waitFor(t, time.Second, func() bool {
+				enabled := sockoptInt(t, serverConn, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE) != 0
+				if !c.enabled {
+					return !enabled
+				}
+				//The period is the idle time before the first probe, the interval of the probes depends on the go version
+				return enabled && sockoptInt(t, serverConn, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE) == c.idle
+			})
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package gosocket_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	gosocket "github.com/yankawayu/go-socket"
+	"github.com/yankawayu/go-socket/gosockettest"
+	"github.com/yankawayu/go-socket/packet"
+)
+
+func TestAppConfigValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		config gosocket.AppConfig
+		valid  bool
+	}{
+		{"zero values", gosocket.AppConfig{}, true},
+		{"read timeout factor of 1", gosocket.AppConfig{ReadTimeoutFactor: 1}, true},
+		{"tcp keep alive disabled", gosocket.AppConfig{TcpKeepAlivePeriod: -1}, true},
+		{"negative ActionTimeout", gosocket.AppConfig{ActionTimeout: -1}, false},
+		{"negative MaxConcurrentRequests", gosocket.AppConfig{MaxConcurrentRequests: -1}, false},
+		{"unknown Engine", gosocket.AppConfig{Engine: gosocket.EngineEpoll + 1}, false},
+		{"negative EngineWorkers", gosocket.AppConfig{EngineWorkers: -1}, false},
+		{"unknown InboundPolicy", gosocket.AppConfig{InboundPolicy: gosocket.PolicyDisconnect + 1}, false},
+		{"unknown OutboundPolicy", gosocket.AppConfig{OutboundPolicy: gosocket.PolicyDisconnect + 1}, false},
+		{"coalescing inbound", gosocket.AppConfig{InboundPolicy: gosocket.PolicyCoalesce}, false},
+		{"negative BackpressureTimeout", gosocket.AppConfig{BackpressureTimeout: -1}, false},
+		{"negative QueueLength", gosocket.AppConfig{QueueLength: -1}, false},
+		{"negative MaxPayloadLength", gosocket.AppConfig{MaxPayloadLength: -1}, false},
+		{"read timeout factor below 1", gosocket.AppConfig{ReadTimeoutFactor: 0.5}, false},
+		{"negative RefreshInterval", gosocket.AppConfig{RefreshInterval: -1}, false},
+		{"negative ReadBufferSize", gosocket.AppConfig{ReadBufferSize: -1}, false},
+		{"negative WriteBufferSize", gosocket.AppConfig{WriteBufferSize: -1}, false},
+		{"negative ClientPoolShards", gosocket.AppConfig{ClientPoolShards: -1}, false},
+		{"unknown SessionPolicy", gosocket.AppConfig{SessionPolicy: gosocket.SessionPerDeviceClass + 1}, false},
+		{"negative MaxSessionsPerUser", gosocket.AppConfig{MaxSessionsPerUser: -1}, false},
+	}
+	for _, c := range cases {
+		if err := c.config.Validate(); (err == nil) != c.valid {
+			t.Fatalf("%s: validate returns %v", c.name, err)
+		}
+	}
+}
+
+func TestServeRejectsInvalidConfig(t *testing.T) {
+	recorder := gosockettest.NewRecorder()
+	err := gosocket.NewApp().Serve(gosockettest.NewListener(), &gosocket.AppConfig{MaxPayloadLength: -1}, recorder, recorder.FastLog())
+	if err == nil || !strings.Contains(err.Error(), "MaxPayloadLength") {
+		t.Fatalf("serve returns %v", err)
+	}
+}
+
+// limitsUser raises the max payload length of the users
+type limitsUser struct {
+	gosockettest.FakeUser
+}
+
+func (user *limitsUser) GetUserLimits() *gosocket.UserLimits {
+	return &gosocket.UserLimits{MaxPayloadLength: 2048}
+}
+
+type limitsController struct {
+	gosocket.Controller
+}
+
+type limitsParam struct {
+	Text string `json:"text"`
+}
+
+func (controller *limitsController) GetActionParamMap() map[string]interface{} {
+	return map[string]interface{}{
+		"Echo":   &limitsParam{},
+		"Upload": &limitsParam{},
+	}
+}
+
+func (controller *limitsController) GetActionPayloadLimitMap() map[string]int {
+	return map[string]int{
+		"Upload": 4096,
+	}
+}
+
+func (controller *limitsController) Echo(param *limitsParam, response *gosocket.ResponseBody) {
+	response.Status = gosocket.StatusSuccess
+}
+
+func (controller *limitsController) Upload(param *limitsParam, response *gosocket.ResponseBody) {
+	response.Status = gosocket.StatusSuccess
+}
+
+// A payload of the length, the json around the text takes 11 bytes
+func payloadOf(length int) string {
+	return `{"text":"` + strings.Repeat("a", length-11) + `"}`
+}
+
+func TestMaxPayloadLength(t *testing.T) {
+	app := gosocket.NewApp()
+	app.Router("limits", &limitsController{})
+	user := gosockettest.NewFakeUser(1)
+	bigUser := &limitsUser{FakeUser: *gosockettest.NewFakeUser(2)}
+	cases := []struct {
+		name        string
+		config      *gosocket.AppConfig
+		user        gosocket.IUser
+		payloadType string
+		limit       int
+	}{
+		{"default without config", nil, user, "limits.Echo", 16*1024 - 1},
+		{"default of zero", &gosocket.AppConfig{}, user, "limits.Echo", 16*1024 - 1},
+		{"config", &gosocket.AppConfig{MaxPayloadLength: 1024}, user, "limits.Echo", 1024},
+		{"user over config", &gosocket.AppConfig{MaxPayloadLength: 1024}, bigUser, "limits.Echo", 2048},
+		{"action over user", &gosocket.AppConfig{MaxPayloadLength: 1024}, bigUser, "limits.Upload", 4096},
+		{"action over config", &gosocket.AppConfig{MaxPayloadLength: 1024}, user, "limits.Upload", 4096},
+	}
+	for _, c := range cases {
+		app.Config = c.config
+		if response := gosockettest.CallApp(app, c.user, c.payloadType, payloadOf(c.limit), nil); response.Status != gosocket.StatusSuccess {
+			t.Fatalf("%s: response %+v at the limit", c.name, response)
+		}
+		response := gosockettest.CallApp(app, c.user, c.payloadType, payloadOf(c.limit+1), nil)
+		if response.Status != gosocket.StatusError || response.Message != "length of payload exceeds the max length" {
+			t.Fatalf("%s: response %+v over the limit", c.name, response)
+		}
+	}
+}
+
+func TestMaxPayloadLengthOfConnection(t *testing.T) {
+	app := gosocket.NewApp()
+	app.Router("limits", &limitsController{})
+	server := gosockettest.NewAppServerWithConfig(app, nil, &gosocket.AppConfig{MaxPayloadLength: 1024})
+	defer server.Close()
+	client, err := server.Connect(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call(context.Background(), "limits.Echo", &limitsParam{Text: strings.Repeat("a", 1013)}, nil); err != nil {
+		t.Fatal(err)
+	}
+	err = client.Call(context.Background(), "limits.Echo", &limitsParam{Text: strings.Repeat("a", 1014)}, nil)
+	var responseErr *gosocket.ResponseError
+	if !errors.As(err, &responseErr) || responseErr.Message != "length of payload exceeds the max length" {
+		t.Fatalf("call over the limit returns %v", err)
+	}
+	if err := client.Call(context.Background(), "limits.Upload", &limitsParam{Text: strings.Repeat("a", 4085)}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Connect on a raw connection with the keep alive time, and return how long the server keeps it without any data
+func idleTimeout(t *testing.T, server *gosockettest.Server, keepAlive uint16) time.Duration {
+	conn, err := server.Listener.Dial(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	manager := &packet.MessageManager{ProCommon: packet.ProtocolCommon{
+		ProName:       packet.ProtocolName,
+		ProVersion:    packet.ProtocolVersion,
+		KeepAliveTime: keepAlive,
+	}}
+	go func() {
+		_ = manager.EncodeMessage(conn, &packet.Connect{Payload: gosockettest.ConnectInfo(1)})
+	}()
+	reader := bufio.NewReader(conn)
+	if msg, err := manager.DecodeMessage(reader); err != nil {
+		t.Fatal(err)
+	} else if ack, ok := msg.(*packet.ConnAck); !ok || ack.ReturnCode != packet.RetCodeAccepted {
+		t.Fatalf("connect failed: %#v", msg)
+	}
+	start := time.Now()
+	_ = conn.SetReadDeadline(start.Add(5 * time.Second))
+	for {
+		if _, err := manager.DecodeMessage(reader); err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				t.Fatal("the idle connection isn't closed")
+			}
+			return time.Since(start)
+		}
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	cases := []struct {
+		name   string
+		config *gosocket.AppConfig
+		want   time.Duration
+	}{
+		{"default factor", nil, 1500 * time.Millisecond},
+		{"factor of 1", &gosocket.AppConfig{ReadTimeoutFactor: 1}, time.Second},
+		{"factor of 2", &gosocket.AppConfig{ReadTimeoutFactor: 2}, 2 * time.Second},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := gosockettest.NewAppServerWithConfig(gosocket.NewApp(), nil, c.config)
+			defer server.Close()
+			if idle := idleTimeout(t, server, 1); idle < c.want-100*time.Millisecond || idle > c.want+400*time.Millisecond {
+				t.Fatalf("closed after %v, want %v", idle, c.want)
+			}
+		})
+	}
+}
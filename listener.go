@@ -1,23 +1,28 @@
 package gosocket
 
 import (
+	"errors"
 	"net"
 	"sync"
 	"time"
 )
 
-// Listener is a wrapper of net.TCPListener
+// Listener is a wrapper of net.Listener, normally it's a net.TCPListener
 type Listener struct {
-	*net.TCPListener
+	net.Listener
 	// record all the connections
 	// 用于记录当前连接
 	waitGroup *sync.WaitGroup
+	// the period of the keep alive of tcp, negative means disabled
+	// tcp保活的间隔，负数表示关闭
+	keepAlivePeriod time.Duration
 }
 
-func NewListener(listener *net.TCPListener) *Listener {
+func NewListener(listener net.Listener) *Listener {
 	return &Listener{
-		TCPListener: listener,
-		waitGroup:   &sync.WaitGroup{},
+		Listener:        listener,
+		waitGroup:       &sync.WaitGroup{},
+		keepAlivePeriod: kDefaultTcpKeepAlivePeriod,
 	}
 }
 
@@ -29,30 +34,42 @@ func (listener *Listener) WaitAllFinished() {
 
 // Accept a new connection
 func (listener *Listener) Accept() (net.Conn, error) {
-	tcpConn, err := listener.AcceptTCP()
+	acceptConn, err := listener.Listener.Accept()
 	if err != nil {
 		return nil, err
 	}
-	//Make sure the KeepAlive mechanism in TCP is opened
-	//Ignore the errors
-	//底层协议中也进行心跳保活
-	_ = tcpConn.SetKeepAlive(true)
-	_ = tcpConn.SetKeepAlivePeriod(time.Minute)
+	if tcpConn, ok := acceptConn.(*net.TCPConn); ok {
+		//Make sure the KeepAlive mechanism in TCP is opened unless it's disabled by AppConfig.TcpKeepAlivePeriod
+		//The tcp listeners of go enable it by default, so it's turned off explicitly when disabled
+		//Ignore the errors
+		//底层协议中也进行心跳保活，go的tcp listener默认开启，关闭时需显式关闭
+		if listener.keepAlivePeriod >= 0 {
+			_ = tcpConn.SetKeepAlive(true)
+			_ = tcpConn.SetKeepAlivePeriod(listener.keepAlivePeriod)
+		} else {
+			_ = tcpConn.SetKeepAlive(false)
+		}
+	}
 	//记录一个连接
 	listener.waitGroup.Add(1)
 	//Embed net.Conn in Connection to rewrite Close function
 	//使用自定义的Connection嵌套net.Conn实例，以重写Close方法
 	conn := &Connection{
-		Conn:     tcpConn,
+		Conn:     acceptConn,
 		listener: listener,
 	}
 	return conn, nil
 }
 
 // GetFd Get the fds to pass them to sub process
+// Only tcp listeners have fds
 // 获取文件描述符，传递给子进程
 func (listener *Listener) GetFd() (uintptr, error) {
-	file, err := listener.File()
+	tcpListener, ok := listener.Listener.(*net.TCPListener)
+	if !ok {
+		return 0, errors.New("listener is not a tcp listener")
+	}
+	file, err := tcpListener.File()
 	if err != nil {
 		return 0, err
 	}
@@ -0,0 +1,57 @@
+package gosocket
+
+import (
+	"context"
+	"strings"
+)
+
+// Request is the request passed through the middlewares
+// The fields can be changed by the middlewares before calling the next handler
+// 经过中间件的请求，中间件可以在调用下一个处理函数前修改其中的字段
+type Request struct {
+	Ctx        context.Context // The context of the request, see Controller.Context
+	User       IUser           // The user who sent the request
+	Type       string          // The payload type in the format of `controller.action`
+	Controller string          // The controller name in lower case
+	Action     string          // The action name
+	Payload    string          // The json payload
+	Data       []byte          // The binary data sent with the payload
+}
+
+// HandlerFunc handles the request and returns the response
+// Returning nil means an internal error, and panics are handled the same way as in the actions
+// 处理请求并返回结果，返回nil视为内部错误，panic的处理与action中相同
+type HandlerFunc func(request *Request) *ResponseBody
+
+// Middleware wraps the next handler, it can inspect the request, time or recover the call,
+// or return its own response without calling next to short-circuit the request
+// 中间件，包装下一个处理函数，可以检查请求、计时、捕获错误，或不调用next直接返回结果
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Use add middlewares for all the requests of the app
+// They run in the order they are added, and before the ones added by UseController
+// 添加作用于所有请求的中间件，按添加顺序执行，先于UseController添加的中间件
+func (app *App) Use(middlewares ...Middleware) {
+	app.middlewares = append(app.middlewares, middlewares...)
+}
+
+// UseController add middlewares for the requests of the controller registered by controllerName
+// 添加仅作用于指定Controller的中间件
+func (app *App) UseController(controllerName string, middlewares ...Middleware) {
+	controllerName = strings.ToLower(controllerName)
+	app.controllerMiddlewares[controllerName] = append(app.controllerMiddlewares[controllerName], middlewares...)
+}
+
+// Wrap the handler with the middlewares of the app and the controller
+// The first middleware added is the outermost one
+// 用App及Controller的中间件包装处理函数，最先添加的在最外层
+func (app *App) wrapMiddlewares(controllerName string, handler HandlerFunc) HandlerFunc {
+	controllerMiddlewares := app.controllerMiddlewares[controllerName]
+	for i := len(controllerMiddlewares) - 1; i >= 0; i-- {
+		handler = controllerMiddlewares[i](handler)
+	}
+	for i := len(app.middlewares) - 1; i >= 0; i-- {
+		handler = app.middlewares[i](handler)
+	}
+	return handler
+}
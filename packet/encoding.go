@@ -7,18 +7,31 @@ import (
 	"io/ioutil"
 )
 
+// readByte reads one byte, without the overhead of io.ReadFull if the reader is buffered
+// 读取一个字节，reader带缓冲时无需io.ReadFull
+func readByte(r io.Reader) (byte, error) {
+	if byteReader, ok := r.(io.ByteReader); ok {
+		return byteReader.ReadByte()
+	}
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
 func getUint8(r io.Reader, packetRemaining *int32) uint8 {
 	if *packetRemaining < 1 {
 		panic(dataExceedsPacketError)
 	}
 
-	var b [1]byte
-	if _, err := io.ReadFull(r, b[:]); err != nil {
+	b, err := readByte(r)
+	if err != nil {
 		panic(err)
 	}
 	*packetRemaining--
 
-	return b[0]
+	return b
 }
 
 func getUint16(r io.Reader, packetRemaining *int32) uint16 {
@@ -168,14 +181,13 @@ func boolToByte(val bool) byte {
 // 返回解析出来的长度，以及长度所占的字节数
 func decodeLength(r io.Reader) (int32, int) {
 	var v int32
-	var buf [1]byte
 	var shift uint
 	for i := 0; i < 4; i++ {
-		if _, err := io.ReadFull(r, buf[:]); err != nil {
+		b, err := readByte(r)
+		if err != nil {
 			panic(err)
 		}
 
-		b := buf[0]
 		v |= int32(b&0x7f) << shift
 
 		if b&0x80 == 0 {
@@ -11,6 +11,7 @@ var (
 	invalidFlagError       = "flag is invalid"
 	invalidProNameError    = "protocol name is invalid"
 	invalidProVersionError = "protocol version is invalid"
+	preparedDecodeError    = "prepared message can't be decoded"
 )
 
 // MessageErr wraps an error that caused a problem that needs to bail out of the
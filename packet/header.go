@@ -41,15 +41,15 @@ func (header *FixHeader) Decode(reader io.Reader) (err error) {
 			err = GetRecoverError(e)
 		}
 	}()
-	var buf [1]byte
-	if _, err = io.ReadFull(reader, buf[:]); err != nil {
+	b, err := readByte(reader)
+	if err != nil {
 		return
 	}
 	//消息类型
-	msgType := MessageType(buf[0] & 0xF0 >> 4)
+	msgType := MessageType(b & 0xF0 >> 4)
 	//Get the flags
 	//标志位
-	flags := buf[0] & 0x0F
+	flags := b & 0x0F
 	//The 4th bit is reserved, it should only be 0
 	//第四位是保留位，不为0则报错
 	if (flags & 0x01) != 0 {
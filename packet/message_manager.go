@@ -93,3 +93,37 @@ func (manager *MessageManager) EncodeMessage(writer io.Writer, msg IMessage) err
 	}
 	return err
 }
+
+// FrameLength returns the length of the first message in the buffer, including the fixed header
+// It returns 0 if the buffer doesn't contain a whole message yet, used to decode from non-blocking reads
+// 返回缓冲区中第一条消息的长度（包括固定头部），消息不完整时返回0，用于非阻塞读取时解码
+func FrameLength(buf []byte) (int, error) {
+	length, err := DeclaredFrameLength(buf)
+	if err != nil || len(buf) < length {
+		return 0, err
+	}
+	return length, nil
+}
+
+// DeclaredFrameLength returns the length of the first message declared by its fixed header, including the header
+// The message may not be whole yet, it returns 0 if the buffer doesn't contain the whole fixed header
+// It's used to reject the oversized messages before reading them
+// 返回固定头部声明的第一条消息的长度（包括固定头部），消息可能还不完整，头部不完整时返回0，用于在读取前拒绝过长的消息
+func DeclaredFrameLength(buf []byte) (int, error) {
+	var remainLen int
+	var shift uint
+	//The first byte is the type and the flags, followed by at most 4 bytes of the remaining length
+	//第一个字节是类型和标志位，之后最多4个字节是剩余长度
+	for i := 1; i < 5; i++ {
+		if i >= len(buf) {
+			return 0, nil
+		}
+		b := buf[i]
+		remainLen |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return i + 1 + remainLen, nil
+		}
+		shift += 7
+	}
+	return 0, NewMessageError(badLengthEncodingError)
+}
@@ -0,0 +1,62 @@
+package packet
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// PreparedMessage is a message encoded once and written to many connections as it is, such as the broadcasts
+// The bytes are cached for the connections with and without gzip, so the message is encoded at most twice
+// 预先编码的消息，原样写入多个连接，如广播
+// 分别缓存启用和未启用gzip的连接的编码结果，所以消息最多编码两次
+type PreparedMessage struct {
+	message IMessage
+	lock    sync.Mutex
+	encoded [2][]byte // The bytes without and with gzip
+	errs    [2]error  // The errors of encoding without and with gzip
+}
+
+// NewPreparedMessage wraps the message, it mustn't be modified after that
+// 包装消息，之后不能再修改该消息
+func NewPreparedMessage(message IMessage) *PreparedMessage {
+	return &PreparedMessage{
+		message: message,
+	}
+}
+
+// Message returns the message wrapped
+// 返回被包装的消息
+func (msg *PreparedMessage) Message() IMessage {
+	return msg.message
+}
+
+func (msg *PreparedMessage) Encode(writer io.Writer, proCommon *ProtocolCommon) error {
+	data, err := msg.bytes(proCommon != nil && proCommon.EnablePayloadGzip)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(data)
+	return err
+}
+
+func (msg *PreparedMessage) Decode(reader io.Reader, header FixHeader, proCommon *ProtocolCommon) error {
+	return NewMessageError(preparedDecodeError)
+}
+
+// Encode the message once for each gzip option
+// 每种gzip选项只编码一次
+func (msg *PreparedMessage) bytes(enableGzip bool) ([]byte, error) {
+	index := 0
+	if enableGzip {
+		index = 1
+	}
+	msg.lock.Lock()
+	defer msg.lock.Unlock()
+	if msg.encoded[index] == nil && msg.errs[index] == nil {
+		buf := new(bytes.Buffer)
+		msg.errs[index] = msg.message.Encode(buf, &ProtocolCommon{EnablePayloadGzip: enableGzip})
+		msg.encoded[index] = buf.Bytes()
+	}
+	return msg.encoded[index], msg.errs[index]
+}
@@ -0,0 +1,134 @@
+package gosocket
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrMessageDropped is the error of the receipts whose messages are dropped by the outbound policy
+	ErrMessageDropped = errors.New("message dropped")
+	// ErrMessageCoalesced is the error of the receipts whose messages are replaced by later ones, see PolicyCoalesce
+	ErrMessageCoalesced = errors.New("message coalesced")
+	// ErrConnClosed is the error of the receipts whose messages are not sent before the connection is closed
+	ErrConnClosed = errors.New("connection closed")
+	// ErrReceiptTimeout is returned by Receipt.WaitTimeout if the message isn't sent in time
+	ErrReceiptTimeout = errors.New("receipt wait timeout")
+)
+
+// SubmitStatus is the result of queueing a message, see MessageHandler.Submit
+// 消息加入发出队列的结果
+type SubmitStatus uint8
+
+const (
+	// SubmitQueued means the message is queued, the receipt resolves once it's sent
+	// The engines writing the messages directly, such as EngineEpoll, have sent it already
+	//消息已加入队列，发送后回执完成，直接写入消息的引擎（如EngineEpoll）此时已发送
+	SubmitQueued SubmitStatus = iota
+	// SubmitCoalesced means the message replaces the queued one with the same key, see PolicyCoalesce
+	//消息替换了队列中键相同的消息
+	SubmitCoalesced
+	// SubmitDropped means the message is dropped by the outbound policy, there is no receipt
+	//消息被发出策略丢弃，没有回执
+	SubmitDropped
+	// SubmitClosed means the connection is closed, there is no receipt
+	//连接已关闭，没有回执
+	SubmitClosed
+	// SubmitOffline means the user isn't online, it's only returned by the pushes of ClientPool and App.PushToUid
+	//用户不在线，仅由ClientPool及App.PushToUid的推送返回
+	SubmitOffline
+	// SubmitUnreachable means the node where the user is online can't be reached, it's only returned by App.PushToUid
+	//用户所在的节点无法访问，仅由App.PushToUid返回
+	SubmitUnreachable
+)
+
+func (status SubmitStatus) String() string {
+	switch status {
+	case SubmitQueued:
+		return "queued"
+	case SubmitCoalesced:
+		return "coalesced"
+	case SubmitDropped:
+		return "dropped"
+	case SubmitClosed:
+		return "closed"
+	case SubmitOffline:
+		return "offline"
+	case SubmitUnreachable:
+		return "unreachable"
+	}
+	return "unknown"
+}
+
+// Receipt is used to get notified when the message is sent, or fails to be sent
+// 用于获取消息发送完成或失败的通知
+type Receipt struct {
+	done chan struct{}
+	err  error
+}
+
+func newReceipt() *Receipt {
+	return &Receipt{
+		done: make(chan struct{}),
+	}
+}
+
+// Resolve the receipt with the result, it must be called only once by the owner of the job
+// 设置回执的结果，只能由任务的持有者调用一次
+func (receipt *Receipt) resolve(err error) {
+	receipt.err = err
+	close(receipt.done)
+}
+
+// Done returns a channel closed once the message is sent or fails to be sent, it replaces receiving from the former Receipt channel
+//
+//	<-job.Receipt.Done()
+//
+// 返回消息发送完成或失败后关闭的channel，代替之前直接从Receipt channel接收
+func (receipt *Receipt) Done() <-chan struct{} {
+	return receipt.done
+}
+
+// Err returns the error of sending the message, nil if it's sent or not done yet
+// 发送消息的错误，发送成功或未完成时为nil
+func (receipt *Receipt) Err() error {
+	select {
+	case <-receipt.done:
+		return receipt.err
+	default:
+		return nil
+	}
+}
+
+// Wait blocks until the message is sent, and returns the error if it fails
+// The bytes are flushed to the socket once it returns nil, it doesn't mean the client has received them
+// 阻塞直到消息发送完成，返回nil表示已写入socket，不代表客户端已收到
+func (receipt *Receipt) Wait() error {
+	<-receipt.done
+	return receipt.err
+}
+
+// WaitContext is the same as Wait except that it returns the error of the ctx once it's done
+// 与Wait相同，但ctx结束时返回ctx的错误
+func (receipt *Receipt) WaitContext(ctx context.Context) error {
+	select {
+	case <-receipt.done:
+		return receipt.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WaitTimeout is the same as Wait except that it returns ErrReceiptTimeout after the timeout
+// 与Wait相同，但超时后返回ErrReceiptTimeout
+func (receipt *Receipt) WaitTimeout(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-receipt.done:
+		return receipt.err
+	case <-timer.C:
+		return ErrReceiptTimeout
+	}
+}
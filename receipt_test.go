@@ -0,0 +1,59 @@
+package gosocket_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	gosocket "github.com/yankawayu/go-socket"
+	"github.com/yankawayu/go-socket/gosockettest"
+)
+
+func TestReceiptResolvesOnceSent(t *testing.T) {
+	server := gosockettest.NewAppServer(gosocket.NewApp(), nil)
+	defer server.Close()
+	client, err := server.Connect(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, receipt := server.App.ClientPool().GetClientByUid(1).PushNotify("test.Push", 1)
+	if status != gosocket.SubmitQueued || receipt == nil {
+		t.Fatalf("status %v, receipt %v", status, receipt)
+	}
+	if err := receipt.WaitTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-receipt.Done():
+	default:
+		t.Fatal("Done isn't closed after Wait returns")
+	}
+	if _, err := client.WaitPush("test.Push", time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReceiptFailsWhenConnectionCloses(t *testing.T) {
+	server := gosockettest.NewAppServerWithConfig(gosocket.NewApp(), nil, &gosocket.AppConfig{QueueLength: 2})
+	defer server.Close()
+	conn, handler, blocked := connectStalled(t, server, 1)
+	_, queued := handler.PushNotify("test.Push", 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := queued.WaitContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("wait on the stalled connection: %v", err)
+	}
+	if err := queued.WaitTimeout(time.Millisecond); err != gosocket.ErrReceiptTimeout {
+		t.Fatalf("wait timeout on the stalled connection: %v", err)
+	}
+	_ = conn.Close()
+	for _, receipt := range []*gosocket.Receipt{blocked, queued} {
+		err := receipt.WaitTimeout(time.Second)
+		if err == nil || err == gosocket.ErrReceiptTimeout {
+			t.Fatalf("receipt after closing: %v", err)
+		}
+		if receipt.Err() != err {
+			t.Fatalf("Err %v, Wait %v", receipt.Err(), err)
+		}
+	}
+}
@@ -24,8 +24,6 @@ const (
 // SIGUSR1时为子进程启动成功的回调，SIGUSR2时为当前进程停止的回调，此函数应用于资源的释放
 type OnRestartSuccess func()
 
-var restartManager *RestartManager
-
 // RestartManager is the class to manage the server's restart process
 // It will listen to two types of signals:
 // syscall.SIGUSR1 the server will shut down gracefully
@@ -38,20 +36,15 @@ type RestartManager struct {
 
 // InitGracefulRestart is used to initialize graceful restart
 // If you don't call this function, the server won't be able to restart itself through signal
-func InitGracefulRestart() {
-	restartManager = &RestartManager{
+// Only one App in a process should call it since the listening fd is inherited by a fixed number
+func (app *App) InitGracefulRestart() {
+	app.restartManager = &RestartManager{
 		listenerMap:     make(map[int]*net.TCPListener),
 		restartHandlers: make([]OnRestartSuccess, 0),
 	}
 	//Start listening to signals
 	//初始化的时候就开始监听
-	go restartManager.handleSignals()
-}
-
-// GetRestartManager get the single instance RestartManager
-// 获取重启管理器
-func GetRestartManager() *RestartManager {
-	return restartManager
+	go app.restartManager.handleSignals()
 }
 
 // RegisterHandler call this function to receive a notification once the server is about to restart
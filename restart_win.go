@@ -3,14 +3,19 @@
 
 package gosocket
 
+import "net"
+
+const (
+	GracefulEnvironKey = "IS_GRACEFUL"
+)
+
 type RestartManager struct{}
 
-// 注册信号，windows系统不支持平滑重启，故留空
-func GetRestartManager() *RestartManager {
-	return &RestartManager{}
-}
+// InitGracefulRestart 注册信号，windows系统不支持平滑重启，故留空
+func (app *App) InitGracefulRestart() {}
 
 type OnRestartSuccess func()
 
 func (manager *RestartManager) RegisterHandler(restartSuccess OnRestartSuccess) {}
 func (manager *RestartManager) MarkFd(key int, listener *net.TCPListener)       {}
+func (manager *RestartManager) IsStop() bool                                    { return false }
@@ -0,0 +1,190 @@
+package gosocket
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Route describes a payload type that can be handled by the app
+// 路由信息
+type Route struct {
+	Type             string        // The payload type in the format of `controller.action`
+	Controller       string        // The controller name in lower case
+	Action           string        // The action name
+	ParamType        reflect.Type  // The struct type of the param
+	ResponseType     reflect.Type  // The type of the response data, nil if it's not declared, see IActionResponse
+	Roles            []string      // The roles required, see IActionRole and WithRoles
+	Timeout          time.Duration // The deadline of the action declared by IActionTimeout or WithTimeout, 0 means the default one
+	MaxPayloadLength int           // The max payload length declared by IActionPayloadLimit or WithMaxPayloadLength, 0 means the default one
+	Typed            bool          // Whether the route is registered by Handle
+}
+
+// Routes returns all the routes of the app sorted by the payload type
+// 获取所有路由，按payload type排序
+func (app *App) Routes() []*Route {
+	routeMap := make(map[string]*Route)
+	for _, routes := range app.controllerRoutes {
+		for _, route := range routes {
+			routeMap[route.Type] = route
+		}
+	}
+	//The typed handlers take priority over the controllers
+	//typed handler优先于Controller
+	for payloadType, handler := range app.handlerMap {
+		strs := strings.Split(payloadType, ".")
+		routeMap[payloadType] = &Route{
+			Type:             payloadType,
+			Controller:       strs[0],
+			Action:           strs[1],
+			ParamType:        handler.paramType,
+			ResponseType:     handler.responseType,
+			Roles:            handler.roles,
+			Timeout:          handler.timeout,
+			MaxPayloadLength: handler.maxPayloadLength,
+			Typed:            true,
+		}
+	}
+	routes := make([]*Route, 0, len(routeMap))
+	for _, route := range routeMap {
+		routes = append(routes, route)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Type < routes[j].Type
+	})
+	return routes
+}
+
+// The type of the second param of the actions
+var responseBodyPtrType = reflect.TypeOf((*ResponseBody)(nil))
+
+// Check the controller and build its routes, it panics if there is anything wrong
+// Every action in GetActionParamMap must have a method in the format of `func(param *ParamStruct, response *ResponseBody)`
+// 检查Controller并生成路由，有错误时panic
+// GetActionParamMap中的每个action都必须有对应的方法，格式为`func(param *ParamStruct, response *ResponseBody)`
+func buildControllerRoutes(controllerName string, controller IController) []*Route {
+	if controllerName == "" || strings.Contains(controllerName, ".") {
+		panic("invalid controller name `" + controllerName + "`")
+	}
+	if controller == nil {
+		panic("controller:" + controllerName + " is nil")
+	}
+	controllerType := reflect.Indirect(reflect.ValueOf(controller)).Type()
+	if controllerType.Kind() != reflect.Struct {
+		panic("controller:" + controllerName + " should be a pointer to struct")
+	}
+	//Create an instance the same way as dispatching the requests
+	//与处理请求时相同的方式创建实例
+	vc := reflect.New(controllerType)
+	execController := vc.Interface().(IController)
+	paramMap := execController.GetActionParamMap()
+	if len(paramMap) == 0 {
+		panic("controller:" + controllerName + " has no action in GetActionParamMap")
+	}
+	var roleMap map[string][]string
+	if roleController, ok := execController.(IActionRole); ok {
+		roleMap = roleController.GetActionRoleMap()
+	}
+	var timeoutMap map[string]time.Duration
+	if timeoutController, ok := execController.(IActionTimeout); ok {
+		timeoutMap = timeoutController.GetActionTimeoutMap()
+	}
+	var responseMap map[string]interface{}
+	if responseController, ok := execController.(IActionResponse); ok {
+		responseMap = responseController.GetActionResponseMap()
+	}
+	var payloadLimitMap map[string]int
+	if limitController, ok := execController.(IActionPayloadLimit); ok {
+		payloadLimitMap = limitController.GetActionPayloadLimitMap()
+	}
+	routes := make([]*Route, 0, len(paramMap))
+	for actionName, paramPtr := range paramMap {
+		actionPath := "action:" + actionName + " in controller:" + controllerName
+		if paramPtr == nil {
+			panic("param of " + actionPath + " is nil")
+		}
+		paramType := reflect.Indirect(reflect.ValueOf(paramPtr)).Type()
+		method, ok := vc.Type().MethodByName(actionName)
+		if !ok {
+			panic(actionPath + " not found, it should be a method of *" + controllerType.Name())
+		}
+		//The receiver is the first param
+		//第一个参数是接收者
+		methodType := method.Type
+		if methodType.NumIn() != 3 || methodType.NumOut() != 0 ||
+			methodType.In(1) != reflect.PtrTo(paramType) || methodType.In(2) != responseBodyPtrType {
+			panic(fmt.Sprintf("%s should be in the format of `func(*%s, *gosocket.ResponseBody)`, got `%s`",
+				actionPath, paramType, vc.MethodByName(actionName).Type()))
+		}
+		checkValidateTags(paramType, actionPath)
+		route := &Route{
+			Type:       controllerName + "." + actionName,
+			Controller: controllerName,
+			Action:     actionName,
+			ParamType:  paramType,
+			Roles:      roleMap[actionName],
+			Timeout:    timeoutMap[actionName],
+			//The non-positive limits mean the default one
+			//非正数表示使用默认值
+			MaxPayloadLength: payloadLimitMap[actionName],
+		}
+		if responsePtr := responseMap[actionName]; responsePtr != nil {
+			route.ResponseType = reflect.TypeOf(responsePtr)
+		}
+		routes = append(routes, route)
+	}
+	//Catch the typos in the other maps
+	//检查其他map中的action是否存在
+	for actionName := range roleMap {
+		if _, ok := paramMap[actionName]; !ok {
+			panic("action:" + actionName + " in GetActionRoleMap of controller:" + controllerName + " not found in GetActionParamMap")
+		}
+	}
+	for actionName := range timeoutMap {
+		if _, ok := paramMap[actionName]; !ok {
+			panic("action:" + actionName + " in GetActionTimeoutMap of controller:" + controllerName + " not found in GetActionParamMap")
+		}
+	}
+	for actionName := range payloadLimitMap {
+		if _, ok := paramMap[actionName]; !ok {
+			panic("action:" + actionName + " in GetActionPayloadLimitMap of controller:" + controllerName + " not found in GetActionParamMap")
+		}
+	}
+	for actionName := range responseMap {
+		if _, ok := paramMap[actionName]; !ok {
+			panic("action:" + actionName + " in GetActionResponseMap of controller:" + controllerName + " not found in GetActionParamMap")
+		}
+	}
+	return routes
+}
+
+// Check the `validate` tags of the param type recursively, it panics if there is an unknown rule or an invalid param
+// 递归检查参数的validate tag，有未知规则或参数错误时panic
+func checkValidateTags(paramType reflect.Type, actionPath string) {
+	//Tell which action the wrong tag belongs to
+	//指明错误的tag属于哪个action
+	defer func() {
+		if r := recover(); r != nil {
+			panic(fmt.Sprintf("%v in %s", r, actionPath))
+		}
+	}()
+	checked := make(map[reflect.Type]bool)
+	var check func(t reflect.Type)
+	check = func(t reflect.Type) {
+		for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct || checked[t] {
+			return
+		}
+		checked[t] = true
+		//The tags are checked while being parsed
+		//解析tag时会检查
+		for _, field := range getValidateFields(t) {
+			check(t.Field(field.index).Type)
+		}
+	}
+	check(paramType)
+}
@@ -15,16 +15,24 @@ const (
 
 // Server is the class to handle incoming requests by serving and listening
 type Server struct {
+	app  *App   //所属的App the app which the server belongs to
 	addr string //监听地址 listen address
 
 	signalChan chan os.Signal //接收重启信号的通道 the channel to receive restart signal
 	listener   *Listener
 }
 
-// NewServer Create a new server
+// NewServer Create a new server of the default TcpApp
 // 创建一个新的服务器
 func NewServer(addr string) *Server {
+	return newAppServer(TcpApp, addr)
+}
+
+// newAppServer Create a new server of the app
+// 创建一个属于app的服务器
+func newAppServer(app *App, addr string) *Server {
 	server := &Server{
+		app:        app,
 		addr:       addr,
 		signalChan: make(chan os.Signal),
 	}
@@ -35,10 +43,10 @@ func NewServer(addr string) *Server {
 // if the config isn't nil, then the tls will be enabled
 // 启动服务器并开始监听，如果config不为nil，则启用TLS
 func (server *Server) ListenAndServe(config *tls.Config) {
-	listener := server.getTCPListener(TcpApp.Config.TcpPort)
+	listener := server.getTCPListener(server.app.Config.TcpPort)
 	server.listener = NewListener(listener)
 
-	restartManager := GetRestartManager()
+	restartManager := server.app.RestartManager()
 	if restartManager != nil {
 		//记录文件描述符 record the fds
 		restartManager.MarkFd(kListenFd, listener)
@@ -51,29 +59,56 @@ func (server *Server) ListenAndServe(config *tls.Config) {
 	}
 	//开始处理请求
 	//Start handling requests
-	server.serve(config)
+	if err := server.serve(config); err != nil {
+		panic(err)
+	}
+}
+
+// Serve start serving on the listener, which can be any net.Listener including in-memory ones
+// It returns once the listener is closed and all the connections have finished
+// if the config isn't nil, then the tls will be enabled
+// 在指定的listener上开始服务，listener关闭且所有连接结束后返回
+func (server *Server) Serve(listener net.Listener, config *tls.Config) error {
+	server.listener = NewListener(listener)
+	return server.serve(config)
+}
+
+// Close stop accepting new connections, the existing ones are not affected
+// 停止接受新连接
+func (server *Server) Close() error {
+	if server.listener == nil {
+		return nil
+	}
+	return server.listener.Close()
 }
 
 // serve Start serving
 // `config` pass nil to disable tls
-func (server *Server) serve(config *tls.Config) {
+func (server *Server) serve(config *tls.Config) error {
 	pid := os.Getpid()
+	server.listener.keepAlivePeriod = server.app.tcpKeepAlivePeriod()
+	//The engine selected by the config, nil means serving the connections by ClientConn
+	//配置选择的引擎，nil表示使用ClientConn
+	engine := server.app.newConnEngine(config != nil)
 	//Start to handle the connections
 	for {
 		acceptConn, err := server.listener.Accept()
-		if config != nil {
-			acceptConn = tls.Server(acceptConn, config)
-		}
 		if err != nil {
 			//if the listener is closed, then it could be the child process has started
 			if strings.HasSuffix(err.Error(), "use of closed network connection") {
 				//stop the loop
 				break
 			}
-			panic(err)
+			return err
+		}
+		if config != nil {
+			acceptConn = tls.Server(acceptConn, config)
+		}
+		if engine != nil && engine.serve(acceptConn) {
+			continue
 		}
 		//For each connection, create a corresponding ClientConn instance to handle it
-		client := NewClientConn(acceptConn)
+		client := server.app.newClientConn(acceptConn)
 		if client != nil {
 			//开始读和写队列
 			//Start the read and write queue
@@ -87,8 +122,15 @@ func (server *Server) serve(config *tls.Config) {
 	//等待所有连接都结束后再结束进程
 	//Wait until all connections have closed
 	server.listener.WaitAllFinished()
+	if engine != nil {
+		engine.shutdown()
+	}
+	//The users are all offline on the current node
+	//当前节点上的用户都已下线
+	server.app.leaveCluster()
 	fmt.Printf("All connection were closed, process %d is shutting down...\n", pid)
 	close(server.signalChan)
+	return nil
 }
 
 // get tcp listener from a fd or a certain address
@@ -0,0 +1,107 @@
+package gosocket
+
+// SessionPolicy decides what to do when a user logs in on a server where the user is online already
+// 用户在已在线的服务器上再次登陆时的策略
+type SessionPolicy uint8
+
+const (
+	// SessionKickOld allows one session per user, the new one kicks the old one out, it's the default one
+	//每个用户只允许一个会话，新会话踢出旧会话，默认策略
+	SessionKickOld SessionPolicy = iota
+	// SessionRejectNew allows one session per user, the new one is rejected with RetCodeConcurrentLogin
+	//每个用户只允许一个会话，拒绝新会话，返回RetCodeConcurrentLogin
+	SessionRejectNew
+	// SessionAllowMulti allows AppConfig.MaxSessionsPerUser sessions per user, the oldest one is kicked out if there are too many.
+	// A device can only have one session, the reconnecting one kicks the old one of the same device out
+	//每个用户允许AppConfig.MaxSessionsPerUser个会话，超出时踢出最早的会话
+	//每个设备只能有一个会话，重连时踢出同一设备的旧会话
+	SessionAllowMulti
+	// SessionPerDeviceClass allows one session per device class, e.g. a phone and a desktop,
+	// the new one kicks the old one of the same class or the same device out
+	//每类设备允许一个会话，如手机和电脑，新会话踢出同类或同一设备的旧会话
+	SessionPerDeviceClass
+)
+
+func (policy SessionPolicy) String() string {
+	switch policy {
+	case SessionKickOld:
+		return "kick_old"
+	case SessionRejectNew:
+		return "reject_new"
+	case SessionAllowMulti:
+		return "allow_multi"
+	case SessionPerDeviceClass:
+		return "per_device_class"
+	}
+	return "unknown"
+}
+
+// Device describes the device of a session, see IDeviceUser
+// 会话的设备
+type Device struct {
+	Id    string // The unique id of the device, e.g. generated by the client once installed
+	Class string // The class of the device, e.g. "phone", "desktop" or "web"
+}
+
+// IDeviceUser can be implemented by the auth class to report the device of the connection, normally parsed from
+// the Connect payload in Auth. It's called after Auth, the connections without it or without a device id
+// use their connection ids as the device ids, so each connection is a different device
+// 实现此接口以提供连接的设备，通常在Auth中从Connect的payload解析，Auth之后调用，未实现或设备id为空时以连接id作为设备id
+type IDeviceUser interface {
+	// GetDevice get the device of the current connection
+	// 当前连接的设备
+	GetDevice() Device
+}
+
+func (app *App) sessionPolicy() SessionPolicy {
+	if app.Config == nil {
+		return SessionKickOld
+	}
+	return app.Config.SessionPolicy
+}
+
+// The max sessions of a user with SessionAllowMulti, 0 means unlimited
+// SessionAllowMulti下每个用户的最大会话数，0表示不限
+func (app *App) maxSessionsPerUser() int {
+	if app.Config == nil {
+		return 0
+	}
+	return app.Config.MaxSessionsPerUser
+}
+
+// Decide which sessions are kicked out by the new one of the device, the sessions are in the order of logging in
+// 决定新设备的会话踢出哪些旧会话，sessions按登陆顺序排列
+func sessionsToKick(policy SessionPolicy, maxSessions int, sessions []*MessageHandler, device Device) []*MessageHandler {
+	var kicked []*MessageHandler
+	switch policy {
+	case SessionAllowMulti:
+		remained := 0
+		for _, session := range sessions {
+			if session.device.Id == device.Id {
+				kicked = append(kicked, session)
+			} else {
+				remained++
+			}
+		}
+		//Kick the oldest ones out to make room for the new one
+		//踢出最早的会话，为新会话腾出位置
+		for _, session := range sessions {
+			if maxSessions <= 0 || remained < maxSessions {
+				break
+			}
+			if session.device.Id != device.Id {
+				kicked = append(kicked, session)
+				remained--
+			}
+		}
+	case SessionPerDeviceClass:
+		for _, session := range sessions {
+			if session.device.Id == device.Id || session.device.Class == device.Class {
+				kicked = append(kicked, session)
+			}
+		}
+	default:
+		kicked = append(kicked, sessions...)
+	}
+	return kicked
+}
@@ -0,0 +1,157 @@
+package gosocket_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	gosocket "github.com/yankawayu/go-socket"
+	"github.com/yankawayu/go-socket/gosockettest"
+	"github.com/yankawayu/go-socket/packet"
+)
+
+// deviceUser reports the device in the connect info
+type deviceUser struct {
+	gosockettest.FakeUser
+	device gosocket.Device
+}
+
+type deviceLoginInfo struct {
+	Uid    int64  `json:"uid"`
+	Device string `json:"device"`
+	Class  string `json:"class"`
+}
+
+func (user *deviceUser) Auth(payload string, ip string) (uid int64, code packet.ReturnCode) {
+	loginInfo := deviceLoginInfo{}
+	if err := json.Unmarshal([]byte(payload), &loginInfo); err != nil || loginInfo.Uid <= 0 {
+		return -1, packet.RetCodeBadLoginInfo
+	}
+	user.device = gosocket.Device{Id: loginInfo.Device, Class: loginInfo.Class}
+	return loginInfo.Uid, packet.RetCodeAccepted
+}
+
+func (user *deviceUser) GetDevice() gosocket.Device {
+	return user.device
+}
+
+func connectDevice(t *testing.T, server *gosockettest.Server, uid int64, device string, class string) *gosockettest.Client {
+	client, err := server.NewClient(gosocket.JSONEncode(&deviceLoginInfo{Uid: uid, Device: device, Class: class}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func assertKicked(t *testing.T, client *gosockettest.Client) {
+	t.Helper()
+	if err := client.WaitDisconnect(time.Second); err != gosocket.ErrKickedOut {
+		t.Fatalf("disconnect reason %v, want kicked out", err)
+	}
+}
+
+func assertOnline(t *testing.T, client *gosockettest.Client) {
+	t.Helper()
+	if client.IsClosed() {
+		t.Fatal("the session is kicked out")
+	}
+}
+
+func TestSessionKickOld(t *testing.T) {
+	server := gosockettest.NewAppServer(gosocket.NewApp(), nil)
+	defer server.Close()
+	first, err := server.Connect(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Connect(1); err != nil {
+		t.Fatal(err)
+	}
+	assertKicked(t, first)
+}
+
+func TestSessionRejectNew(t *testing.T) {
+	server := gosockettest.NewAppServerWithConfig(gosocket.NewApp(), nil, &gosocket.AppConfig{SessionPolicy: gosocket.SessionRejectNew})
+	defer server.Close()
+	first, err := server.Connect(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Connect(1); err == nil {
+		t.Fatal("the second session is accepted")
+	}
+	assertOnline(t, first)
+}
+
+func TestSessionAllowMultiWithoutDevice(t *testing.T) {
+	server := gosockettest.NewAppServerWithConfig(gosocket.NewApp(), nil, &gosocket.AppConfig{
+		SessionPolicy:      gosocket.SessionAllowMulti,
+		MaxSessionsPerUser: 2,
+	})
+	defer server.Close()
+	var clients []*gosockettest.Client
+	for i := 0; i < 2; i++ {
+		client, err := server.Connect(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, client)
+	}
+	time.Sleep(50 * time.Millisecond)
+	assertOnline(t, clients[0])
+	if sessions := server.App.ClientPool().GetClientsByUid(1); len(sessions) != 2 {
+		t.Fatalf("%d sessions, want 2", len(sessions))
+	}
+	//The third one kicks the oldest one out
+	if _, err := server.Connect(1); err != nil {
+		t.Fatal(err)
+	}
+	assertKicked(t, clients[0])
+	assertOnline(t, clients[1])
+}
+
+func TestSessionAllowMultiSameDevice(t *testing.T) {
+	server := gosockettest.NewAppServerWithConfig(gosocket.NewApp(), &deviceUser{}, &gosocket.AppConfig{SessionPolicy: gosocket.SessionAllowMulti})
+	defer server.Close()
+	phone := connectDevice(t, server, 1, "p1", "phone")
+	desktop := connectDevice(t, server, 1, "d1", "desktop")
+	connectDevice(t, server, 1, "p1", "phone")
+	assertKicked(t, phone)
+	assertOnline(t, desktop)
+}
+
+func TestSessionPerDeviceClass(t *testing.T) {
+	server := gosockettest.NewAppServerWithConfig(gosocket.NewApp(), &deviceUser{}, &gosocket.AppConfig{SessionPolicy: gosocket.SessionPerDeviceClass})
+	defer server.Close()
+	phone := connectDevice(t, server, 1, "p1", "phone")
+	desktop := connectDevice(t, server, 1, "d1", "desktop")
+	connectDevice(t, server, 1, "p2", "phone")
+	assertKicked(t, phone)
+	assertOnline(t, desktop)
+}
+
+func TestPushToSessions(t *testing.T) {
+	server := gosockettest.NewAppServerWithConfig(gosocket.NewApp(), &deviceUser{}, &gosocket.AppConfig{SessionPolicy: gosocket.SessionAllowMulti})
+	defer server.Close()
+	phone := connectDevice(t, server, 1, "p1", "phone")
+	desktop := connectDevice(t, server, 1, "d1", "desktop")
+	pool := server.App.ClientPool()
+	results := pool.PushToUids([]int64{1, 2}, "test.All", 1)
+	if len(results) != 3 || results[2].Uid != 2 || results[2].Status != gosocket.SubmitOffline {
+		t.Fatalf("results %+v", results)
+	}
+	for _, client := range []*gosockettest.Client{phone, desktop} {
+		if _, err := client.WaitPush("test.All", time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if result := pool.PushToDevice(1, "d1", "test.Desktop", 1); result.Status != gosocket.SubmitQueued || result.Device.Class != "desktop" {
+		t.Fatalf("result %+v", result)
+	}
+	if _, err := desktop.WaitPush("test.Desktop", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := phone.WaitPush("test.Desktop", 50*time.Millisecond); err != gosockettest.ErrWaitTimeout {
+		t.Fatalf("the phone got the push of the desktop: %v", err)
+	}
+}
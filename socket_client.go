@@ -1,15 +1,64 @@
 package gosocket
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
+	"math/rand"
 	"net"
 	"strconv"
 	"sync"
 	"time"
+
+	"github.com/yankawayu/go-socket/packet"
+)
+
+var (
+	ErrConnectRequired = errors.New("connect required")
+	ErrConnectionLost  = errors.New("connection lost")
+	ErrClientClosed    = errors.New("client closed")
+	ErrKickedOut       = errors.New("kicked out by server")
+	ErrRequestTimeout  = errors.New("timeout")
+
+	ErrKeepAliveTimeout = errors.New("keepalive timeout")
 )
 
+// kConnectTimeout is the max time to wait for ConnAck when Connect is called without context
+// 默认连接超时时间
+const kConnectTimeout = 10 * time.Second
+
+// ResponseError is returned when the status of the server response is not StatusSuccess
+// It carries the Status, the Message and the Data sent by the server
+// 服务器返回的错误
+type ResponseError struct {
+	Status  Status
+	Message string
+	Data    *json.RawMessage
+}
+
+// FieldErrors returns the fields that failed the validation of the server, nil if there isn't any
+// 参数校验失败的字段
+func (e *ResponseError) FieldErrors() ValidationErrors {
+	if e.Status != StatusError || e.Data == nil {
+		return nil
+	}
+	var errs ValidationErrors
+	if err := json.Unmarshal(*e.Data, &errs); err != nil {
+		return nil
+	}
+	return errs
+}
+
+func (e *ResponseError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("response status error: %d", e.Status)
+	}
+	return fmt.Sprintf("response status error: %d %s", e.Status, e.Message)
+}
+
 // IConnectProvider is used to provide connect info
 type IConnectProvider interface {
 	// GetConnectInfo Implement this function to provide connect info string to the server
@@ -17,70 +66,464 @@ type IConnectProvider interface {
 	GetConnectInfo() string
 }
 
+// ClientState is the state of the connection between Client and the server
+// 客户端连接状态
+type ClientState uint8
+
+const (
+	ClientStateIdle = ClientState(iota)
+	ClientStateConnecting
+	ClientStateConnected
+	ClientStateReconnecting
+	ClientStateClosed
+)
+
+func (state ClientState) String() string {
+	switch state {
+	case ClientStateIdle:
+		return "idle"
+	case ClientStateConnecting:
+		return "connecting"
+	case ClientStateConnected:
+		return "connected"
+	case ClientStateReconnecting:
+		return "reconnecting"
+	case ClientStateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// StateCallback is called every time the state of Client changes
+// The err is the reason of the change if there is one
+type StateCallback func(state ClientState, err error)
+
+// PendingPolicy decides what happens to the requests without response when the connection drops
+// 连接断开时未收到回复的请求的处理策略
+type PendingPolicy uint8
+
+const (
+	// PendingPolicyFail fails all the pending requests with ErrConnectionLost immediately
+	// 立即返回失败
+	PendingPolicyFail = PendingPolicy(iota)
+	// PendingPolicyReplay sends all the pending requests again once reconnected
+	// Only use it if the actions are idempotent, since the server might have processed them already
+	// 重连成功后重发，仅适用于幂等的接口
+	PendingPolicyReplay
+)
+
+// ClientOptions is used to customize Client
+// 客户端配置
+type ClientOptions struct {
+	// Servers are extra addresses in the format of "ip:port" to fail over to
+	// The address passed to NewClient is always the first one
+	// 备用服务器地址
+	Servers []string
+	// Dialer creates the connection to the address instead of dialing tcp, e.g. in-memory connections in tests
+	// Tls isn't applied to the connections it creates
+	// 自定义建立连接的方法，如测试中使用内存连接
+	Dialer func(ctx context.Context, addr string) (net.Conn, error)
+
+	// Reconnect enables reconnecting automatically once the connection drops
+	// 是否自动重连
+	Reconnect bool
+	// ReconnectInterval is the waiting time before the first reconnect attempt
+	// 首次重连等待时间
+	ReconnectInterval time.Duration
+	// ReconnectMaxInterval is the upper bound of the waiting time
+	// 最大重连等待时间
+	ReconnectMaxInterval time.Duration
+	// ReconnectMultiplier is how many times the waiting time grows after each failed attempt
+	// 每次失败后等待时间的增长倍数
+	ReconnectMultiplier float64
+	// ReconnectJitter randomizes the waiting time by ±(ReconnectJitter*100)% to avoid reconnect storms
+	// 随机抖动比例，避免所有客户端同时重连
+	ReconnectJitter float64
+	// ReconnectMaxAttempts is the max attempts for each drop, 0 means retrying forever
+	// 最大重连次数，0为无限
+	ReconnectMaxAttempts int
+	// PendingPolicy decides what to do with the requests without response
+	PendingPolicy PendingPolicy
+
+	// KeepAliveTime is the interval of the ping pong messages in seconds
+	// It's sent to the server in Connect message, the server closes the connection after 1.5 times of it without any data
+	// 心跳间隔（秒），服务器会据此判断连接超时
+	KeepAliveTime uint16
+	// MaxMissedPings is the max amount of PingReq without PingResp, the connection is closed once it's reached
+	// 允许连续未收到回复的心跳数量，超过后断开连接
+	MaxMissedPings int
+	// ReadTimeout closes the connection if nothing arrives in this period, the default is (MaxMissedPings+1.5) times of KeepAliveTime
+	// The ping check closes a silent connection after MaxMissedPings+1 intervals, at the tick after the last missed ping,
+	// so the default leaves it half an interval to detect the missed pings before the read timeout
+	// 读超时时间，默认为心跳间隔的(MaxMissedPings+1.5)倍，心跳检查在MaxMissedPings+1个间隔后断开无数据的连接，
+	// 默认值为其留出半个间隔，以便先由MaxMissedPings检测到未回复的心跳
+	ReadTimeout time.Duration
+
+	// QueueLength is the max amount of messages waiting to be sent, the default is QueueLength
+	// 等待发送的消息队列长度，默认为QueueLength
+	QueueLength int
+	// RequestTimeout fails the requests with ErrRequestTimeout if no response arrives in this period
+	// It applies to GetData, and to Call unless the ctx has a deadline
+	// 请求超时时间，超时未收到响应时返回ErrRequestTimeout
+	RequestTimeout time.Duration
+
+	// OnStateChange is called every time the state changes
+	// 连接状态变化回调
+	OnStateChange StateCallback
+}
+
+// DefaultClientOptions the options used by NewClient
+// 默认配置
+func DefaultClientOptions() *ClientOptions {
+	return &ClientOptions{
+		Reconnect:            false,
+		ReconnectInterval:    time.Second,
+		ReconnectMaxInterval: 30 * time.Second,
+		ReconnectMultiplier:  2,
+		ReconnectJitter:      0.2,
+		PendingPolicy:        PendingPolicyFail,
+		KeepAliveTime:        60,
+		MaxMissedPings:       2,
+		QueueLength:          QueueLength,
+		RequestTimeout:       10 * time.Second,
+	}
+}
+
+// Fill the zero values with the default ones
+// 未设置的值使用默认值
+func (options *ClientOptions) normalize() {
+	defaultOptions := DefaultClientOptions()
+	if options.ReconnectInterval <= 0 {
+		options.ReconnectInterval = defaultOptions.ReconnectInterval
+	}
+	if options.ReconnectMaxInterval < options.ReconnectInterval {
+		options.ReconnectMaxInterval = options.ReconnectInterval
+	}
+	if options.ReconnectMultiplier < 1 {
+		options.ReconnectMultiplier = defaultOptions.ReconnectMultiplier
+	}
+	if options.ReconnectJitter < 0 || options.ReconnectJitter > 1 {
+		options.ReconnectJitter = defaultOptions.ReconnectJitter
+	}
+	if options.KeepAliveTime == 0 {
+		options.KeepAliveTime = defaultOptions.KeepAliveTime
+	}
+	if options.MaxMissedPings <= 0 {
+		options.MaxMissedPings = defaultOptions.MaxMissedPings
+	}
+	if options.ReadTimeout <= 0 {
+		options.ReadTimeout = time.Duration((float64(options.MaxMissedPings) + 1.5) * float64(options.KeepAliveTime) * float64(time.Second))
+	}
+	if options.QueueLength <= 0 {
+		options.QueueLength = defaultOptions.QueueLength
+	}
+	if options.RequestTimeout <= 0 {
+		options.RequestTimeout = defaultOptions.RequestTimeout
+	}
+}
+
+// Get the waiting time before the attempt, it grows exponentially with jitter
+// 指数退避，并加入随机抖动
+func (options *ClientOptions) backoff(attempt int) time.Duration {
+	interval := float64(options.ReconnectInterval) * math.Pow(options.ReconnectMultiplier, float64(attempt))
+	if maxInterval := float64(options.ReconnectMaxInterval); interval > maxInterval {
+		interval = maxInterval
+	}
+	if options.ReconnectJitter > 0 {
+		delta := interval * options.ReconnectJitter
+		interval = interval - delta + rand.Float64()*2*delta
+	}
+	return time.Duration(interval)
+}
+
+// Push is a push notification sent by the server
+// 服务器推送
+type Push struct {
+	Type    string //The type passed to PushNotify on the server
+	Payload string //JSON
+	Data    []byte //Binary data, nil if there isn't any
+}
+
+// Decode the json payload into v
+// 将推送内容解析到v中
+func (push *Push) Decode(v interface{}) error {
+	if push.Payload == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(push.Payload), v)
+}
+
+// PushHandler is used to handle push notifications
+// It's called by the reading thread, so it should return as soon as possible
+// 推送处理函数，在读线程中调用，应尽快返回
+type PushHandler func(push *Push)
+
+// A request waiting for its response
+// 等待回复的请求
+type pendingRequest struct {
+	payloadType string
+	payload     string
+	data        []byte
+	callback    func(err error, payloadBody string)
+
+	//The connection and the message id the request was sent with, so that its callback can be removed on timeout
+	//请求发送时的连接及消息id，超时后用于移除回调
+	conn  *SocketClientConn
+	msgId uint16
+}
+
 // Client is a class responsible for connecting to the server by socket
 // Make sure the port and the isTls value are the same as the ones on server
 type Client struct {
-	ip     string
-	port   int
-	isTls  bool
-	logger ILogger
+	ip      string
+	port    int
+	isTls   bool
+	logger  ILogger
+	options *ClientOptions
+	servers []string //All the server addresses, the first one is ip:port
 
-	conn     *SocketClientConn
-	provider IConnectProvider
+	lock        sync.Mutex
+	conn        *SocketClientConn
+	provider    IConnectProvider
+	state       ClientState
+	serverIndex int           //The index of the server currently used
+	stopChan    chan struct{} //Closed by Disconnect to stop reconnecting
 
 	pingTimer *Timer
+
+	pendingLock sync.Mutex
+	pendingId   uint64
+	pendingMap  map[uint64]*pendingRequest
+
+	pushLock       sync.RWMutex
+	pushHandlers   map[string]PushHandler //Handlers for certain types
+	anyPushHandler PushHandler            //Handler for the types without handlers
+	pushStreams    []chan *Push           //Every push is delivered to all the streams
 }
 
 // NewClient create a new client by providing the ip, port of the server and whether to use tls
 // 创建一个新的客户端连接
 func NewClient(ip string, port int, isTls bool, log ILogger, provider IConnectProvider) *Client {
+	return NewClientWithOptions(ip, port, isTls, log, provider, nil)
+}
+
+// NewClientWithOptions create a new client with options, pass nil to use DefaultClientOptions
+// 使用自定义配置创建客户端
+func NewClientWithOptions(ip string, port int, isTls bool, log ILogger, provider IConnectProvider, options *ClientOptions) *Client {
+	if options == nil {
+		options = DefaultClientOptions()
+	}
+	options.normalize()
+	servers := []string{ip + ":" + strconv.Itoa(port)}
+	servers = append(servers, options.Servers...)
 	c := &Client{
-		ip:       ip,
-		port:     port,
-		isTls:    isTls,
-		logger:   log,
-		provider: provider,
+		ip:         ip,
+		port:       port,
+		isTls:      isTls,
+		logger:     log,
+		options:    options,
+		servers:    servers,
+		provider:   provider,
+		pendingMap: make(map[uint64]*pendingRequest),
+
+		pushHandlers: make(map[string]PushHandler),
 	}
 	return c
 }
 
+// State returns the current state of the client
+// 当前连接状态
+func (client *Client) State() ClientState {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	return client.state
+}
+
+func (client *Client) setState(state ClientState, err error) {
+	client.lock.Lock()
+	changed := client.state != state
+	client.state = state
+	client.lock.Unlock()
+	if changed && client.options.OnStateChange != nil {
+		client.options.OnStateChange(state, err)
+	}
+}
+
 // Connect start to connect the server
+// If there are several servers, they will be tried one by one until one of them succeeds
+// It gives up if the connection isn't accepted within 10 seconds
 // 连接聊天服务器
-func (client *Client) Connect() (err error) {
+func (client *Client) Connect() error {
+	ctx, cancel := context.WithTimeout(context.Background(), kConnectTimeout)
+	defer cancel()
+	return client.ConnectContext(ctx)
+}
+
+// ConnectContext start to connect the server, it gives up once the ctx is done
+// 连接聊天服务器，ctx结束时放弃
+func (client *Client) ConnectContext(ctx context.Context) (err error) {
 	defer func() {
 		if recoverObj := recover(); recoverObj != nil {
 			client.logger.Error(recoverObj)
+			err = getRecoverError(recoverObj)
 		}
 	}()
 	if client.port <= 0 {
 		panic("port needs to be above 0")
 	}
-	//连接服务器
+	client.lock.Lock()
+	client.stopChan = make(chan struct{})
+	stopChan := client.stopChan
+	client.lock.Unlock()
+	client.setState(ClientStateConnecting, nil)
+	err = client.connectServers(ctx, stopChan)
+	if err != nil {
+		client.setState(ClientStateClosed, err)
+	}
+	return
+}
+
+// Try every server once, starting from the one used last time
+// 从上次使用的服务器开始，逐个尝试连接
+func (client *Client) connectServers(ctx context.Context, stopChan chan struct{}) (err error) {
+	client.lock.Lock()
+	startIndex := client.serverIndex
+	client.lock.Unlock()
+	for i := 0; i < len(client.servers); i++ {
+		index := (startIndex + i) % len(client.servers)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var conn *SocketClientConn
+		conn, err = client.dial(ctx, client.servers[index])
+		if err != nil {
+			client.logger.Debugf("connect %s failed: %v", client.servers[index], err)
+			continue
+		}
+		client.lock.Lock()
+		//Disconnect was called while connecting
+		//连接过程中被主动断开
+		select {
+		case <-stopChan:
+			client.lock.Unlock()
+			conn.Disconnect()
+			return ErrClientClosed
+		default:
+		}
+		client.conn = conn
+		client.serverIndex = index
+		client.lock.Unlock()
+		//每隔一段时间发送心跳包
+		client.startAutoPing()
+		client.setState(ClientStateConnected, nil)
+		return nil
+	}
+	return
+}
+
+// Dial the address and authenticate through the provider
+// 连接指定地址并登陆
+func (client *Client) dial(ctx context.Context, addr string) (*SocketClientConn, error) {
 	var connection net.Conn
-	addr := client.ip + ":" + strconv.Itoa(client.port)
-	if client.isTls {
-		config := &tls.Config{
-			InsecureSkipVerify: true,
+	var err error
+	if client.options.Dialer != nil {
+		connection, err = client.options.Dialer(ctx, addr)
+	} else if client.isTls {
+		dialer := &tls.Dialer{
+			Config: &tls.Config{
+				InsecureSkipVerify: true,
+			},
 		}
-		connection, err = tls.Dial("tcp", addr, config)
+		connection, err = dialer.DialContext(ctx, "tcp", addr)
 	} else {
-		connection, err = net.Dial("tcp", addr)
+		dialer := &net.Dialer{}
+		connection, err = dialer.DialContext(ctx, "tcp", addr)
 	}
 	if err != nil {
-		return
+		return nil, err
 	}
-	client.conn = NewSocketClientConn(connection, client.logger)
+	conn := newSocketClientConn(connection, client.logger, client, client.options.QueueLength)
+	conn.msgManager.ProCommon.KeepAliveTime = client.options.KeepAliveTime
+	conn.readTimeout = client.options.ReadTimeout
+	conn.start()
 	connectInfo := "{}"
 	if client.provider != nil {
 		connectInfo = client.provider.GetConnectInfo()
 	}
-	err = client.conn.Connect(connectInfo)
-	//如果连接成功
-	if err == nil {
-		//每隔一段时间发送心跳包
-		client.startAutoPing()
+	err = conn.ConnectContext(ctx, connectInfo)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
 	}
-	return
+	return conn, nil
+}
+
+// Called by the reading thread of each connection once it's off
+// 连接断开后的处理，决定是否重连
+func (client *Client) onConnLost(conn *SocketClientConn) {
+	client.lock.Lock()
+	//Ignore the connections that are not in use, e.g. the one closed by Disconnect
+	//忽略已不再使用的连接
+	if client.conn != conn {
+		client.lock.Unlock()
+		return
+	}
+	client.conn = nil
+	stopChan := client.stopChan
+	client.lock.Unlock()
+	client.stopAutoPing()
+
+	err := ErrConnectionLost
+	if closeErr := conn.CloseError(); closeErr != nil {
+		err = closeErr
+	}
+	//Reconnecting after being kicked out will kick the other connection out again
+	//被踢出后不能重连，否则会把新的连接踢掉
+	if conn.DisconnectType() == packet.DiscTypeKickout {
+		err = ErrKickedOut
+	} else if client.options.Reconnect {
+		if client.options.PendingPolicy == PendingPolicyFail {
+			client.failPending(err)
+		}
+		client.setState(ClientStateReconnecting, err)
+		go client.reconnect(stopChan)
+		return
+	}
+	client.setState(ClientStateClosed, err)
+	client.failPending(err)
+	client.closePushStreams()
+}
+
+// Keep reconnecting with exponential backoff until it succeeds or runs out of attempts
+// 指数退避重连
+func (client *Client) reconnect(stopChan chan struct{}) {
+	defer func() {
+		if recoverObj := recover(); recoverObj != nil {
+			client.logger.Error(recoverObj)
+		}
+	}()
+	var err error
+	for attempt := 0; client.options.ReconnectMaxAttempts <= 0 || attempt < client.options.ReconnectMaxAttempts; attempt++ {
+		select {
+		case <-stopChan:
+			return
+		case <-time.After(client.options.backoff(attempt)):
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), kConnectTimeout)
+		err = client.connectServers(ctx, stopChan)
+		cancel()
+		if err == ErrClientClosed {
+			return
+		}
+		if err == nil {
+			client.replayPending()
+			return
+		}
+		client.logger.Debugf("reconnect attempt %d failed: %v", attempt+1, err)
+	}
+	client.setState(ClientStateClosed, err)
+	client.failPending(err)
+	client.closePushStreams()
 }
 
 // GetDataCallback is the callback used by GetData function
@@ -100,50 +543,234 @@ func (client *Client) GetData(payloadType string, payload interface{}, callback
 	if payload != nil {
 		payloadStr = JSONEncode(payload)
 	}
-	if client.conn == nil {
-		if callback != nil {
-			callback(errors.New("connect required"), "")
-		}
-		return
-	}
-	//加锁，确保计时器结束和接口返回不会出现并发
-	timeOutLock := &sync.RWMutex{}
-	isCallback := false
-	//启动计时器，如果一段时间没有收到服务器响应，则返回超时错误
-	timer := NewTimer(time.Second*10, func() {
-		timeOutLock.Lock()
-		defer timeOutLock.Unlock()
-		//如果已经收到服务器响应了，直接返回
-		if isCallback {
-			return
-		} else {
-			isCallback = true
-		}
-		if callback != nil {
-			callback(errors.New("timeout"), "")
+	var timer *Timer
+	timerLock := &sync.Mutex{}
+	requestId := client.request(payloadType, payloadStr, data, func(err error, payloadBody string) {
+		timerLock.Lock()
+		//停止计时器
+		if timer != nil {
+			timer.Stop()
+			timer = nil
 		}
-	})
-	client.conn.SendRequest(payloadType, payloadStr, func(payloadBody string) {
-		timeOutLock.Lock()
-		defer timeOutLock.Unlock()
+		timerLock.Unlock()
 		defer func() {
 			if r := recover(); r != nil {
 				client.logger.Error(r)
 			}
 		}()
-		//如果已超时，直接返回
-		if isCallback {
-			return
-		} else {
-			isCallback = true
-			//停止计时器
-			timer.Stop()
+		ret := ""
+		if err == nil {
+			err, ret = client.DecodeResponse(payloadBody)
 		}
-		err, ret := client.DecodeResponse(payloadBody)
 		if callback != nil {
 			callback(err, ret)
 		}
-	}, data)
+	})
+	//启动计时器，如果一段时间没有收到服务器响应，则返回超时错误
+	timerLock.Lock()
+	defer timerLock.Unlock()
+	if client.hasPending(requestId) {
+		timer = NewTimer(client.options.RequestTimeout, func() {
+			go client.resolvePending(requestId, ErrRequestTimeout, "")
+		})
+	}
+}
+
+// Call the api of the server and block until the response arrives or the ctx is done
+// If the ctx has no deadline, ErrRequestTimeout is returned after ClientOptions.RequestTimeout
+// The `data` field of the response is decoded into out, pass nil to ignore it
+// If the status of the response isn't StatusSuccess, a *ResponseError is returned
+// 同步调用服务器接口，将返回的data解析到out中，ctx没有截止时间时RequestTimeout后超时
+func (client *Client) Call(ctx context.Context, payloadType string, payload interface{}, out interface{}) error {
+	return client.CallWithData(ctx, payloadType, payload, nil, out)
+}
+
+// CallWithData is the same as Call, except that the binary data is sent along with the payload
+// 同步调用服务器接口，同时发送二进制数据
+func (client *Client) CallWithData(ctx context.Context, payloadType string, payload interface{}, data []byte, out interface{}) error {
+	payloadStr := ""
+	if payload != nil {
+		payloadBytes, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		payloadStr = string(payloadBytes)
+	}
+	type callResult struct {
+		err         error
+		payloadBody string
+	}
+	//Without a deadline of the ctx, the request times out after RequestTimeout the same as GetData
+	//ctx没有截止时间时，与GetData相同，RequestTimeout后超时
+	var timeout <-chan time.Time
+	if _, ok := ctx.Deadline(); !ok {
+		timer := time.NewTimer(client.options.RequestTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	//Buffered, so that the callback won't block after the ctx is done
+	//带缓冲，确保ctx结束后回调不会阻塞
+	resultChan := make(chan callResult, 1)
+	requestId := client.request(payloadType, payloadStr, data, func(err error, payloadBody string) {
+		resultChan <- callResult{err: err, payloadBody: payloadBody}
+	})
+	select {
+	case result := <-resultChan:
+		if result.err != nil {
+			return result.err
+		}
+		return decodeResponseInto(result.payloadBody, out)
+	case <-ctx.Done():
+		client.cancelPending(requestId)
+		return ctx.Err()
+	case <-timeout:
+		client.cancelPending(requestId)
+		return ErrRequestTimeout
+	}
+}
+
+// Decode the server response, and decode its data into out
+// 解析服务器返回，并将data解析到out中
+func decodeResponseInto(payloadBody string, out interface{}) error {
+	respBody := ClientResponseBody{}
+	if err := json.Unmarshal([]byte(payloadBody), &respBody); err != nil {
+		return err
+	}
+	if respBody.Status != StatusSuccess {
+		return &ResponseError{
+			Status:  respBody.Status,
+			Message: respBody.Message,
+			Data:    respBody.Data,
+		}
+	}
+	if out == nil || respBody.Data == nil {
+		return nil
+	}
+	return json.Unmarshal(*respBody.Data, out)
+}
+
+// Send a request and register its callback
+// The callback is called exactly once, with either the response or an error
+// 发送请求并记录回调，回调只会被调用一次
+func (client *Client) request(payloadType string, payload string, data []byte, callback func(err error, payloadBody string)) uint64 {
+	pending := &pendingRequest{
+		payloadType: payloadType,
+		payload:     payload,
+		data:        data,
+		callback:    callback,
+	}
+	client.pendingLock.Lock()
+	client.pendingId++
+	requestId := client.pendingId
+	client.pendingMap[requestId] = pending
+	client.pendingLock.Unlock()
+
+	client.lock.Lock()
+	conn := client.conn
+	state := client.state
+	client.lock.Unlock()
+	if conn == nil {
+		//Keep the request until reconnected
+		//等待重连成功后发送
+		if state == ClientStateReconnecting && client.options.PendingPolicy == PendingPolicyReplay {
+			return requestId
+		}
+		client.resolvePending(requestId, ErrConnectRequired, "")
+		return requestId
+	}
+	client.send(conn, requestId, pending)
+	return requestId
+}
+
+func (client *Client) send(conn *SocketClientConn, requestId uint64, pending *pendingRequest) {
+	msgId := conn.addRequest(func(payloadBody string) {
+		client.resolvePending(requestId, nil, payloadBody)
+	})
+	client.pendingLock.Lock()
+	_, ok := client.pendingMap[requestId]
+	if ok {
+		pending.conn = conn
+		pending.msgId = msgId
+	}
+	client.pendingLock.Unlock()
+	//It has timed out or been cancelled already
+	//请求已超时或被取消
+	if !ok {
+		conn.removeRequest(msgId)
+		return
+	}
+	conn.writeRequest(msgId, pending.payloadType, pending.payload, true, pending.data)
+}
+
+func (client *Client) hasPending(requestId uint64) bool {
+	client.pendingLock.Lock()
+	defer client.pendingLock.Unlock()
+	_, ok := client.pendingMap[requestId]
+	return ok
+}
+
+// Remove the request without calling its callback
+// 移除请求，不回调
+func (client *Client) cancelPending(requestId uint64) {
+	client.removePending(requestId)
+}
+
+// Remove the request and call its callback, do nothing if it has been resolved already
+// 移除请求并回调，已处理过的请求直接忽略
+func (client *Client) resolvePending(requestId uint64, err error, payloadBody string) {
+	if pending := client.removePending(requestId); pending != nil {
+		pending.callback(err, payloadBody)
+	}
+}
+
+// Remove the request, and its callback waiting on the connection if the response hasn't arrived
+// 移除请求，如果还没收到回复，同时移除连接上等待的回调
+func (client *Client) removePending(requestId uint64) *pendingRequest {
+	client.pendingLock.Lock()
+	pending := client.pendingMap[requestId]
+	delete(client.pendingMap, requestId)
+	var conn *SocketClientConn
+	var msgId uint16
+	if pending != nil {
+		conn, msgId = pending.conn, pending.msgId
+	}
+	client.pendingLock.Unlock()
+	if conn != nil {
+		conn.removeRequest(msgId)
+	}
+	return pending
+}
+
+// Fail all the pending requests
+// 所有等待中的请求返回失败
+func (client *Client) failPending(err error) {
+	client.pendingLock.Lock()
+	pendingMap := client.pendingMap
+	client.pendingMap = make(map[uint64]*pendingRequest)
+	client.pendingLock.Unlock()
+	for _, pending := range pendingMap {
+		pending.callback(err, "")
+	}
+}
+
+// Send all the pending requests again through the new connection
+// 通过新连接重发所有等待中的请求
+func (client *Client) replayPending() {
+	client.lock.Lock()
+	conn := client.conn
+	client.lock.Unlock()
+	if conn == nil {
+		return
+	}
+	client.pendingLock.Lock()
+	pendingMap := make(map[uint64]*pendingRequest, len(client.pendingMap))
+	for requestId, pending := range client.pendingMap {
+		pendingMap[requestId] = pending
+	}
+	client.pendingLock.Unlock()
+	for requestId, pending := range pendingMap {
+		client.send(conn, requestId, pending)
+	}
 }
 
 type ClientResponseBody struct {
@@ -168,43 +795,151 @@ func (client *Client) DecodeResponse(payloadBody string) (error, string) {
 			}
 		}
 	} else {
-		return errors.New("response status error"), ""
+		return &ResponseError{
+			Status:  respBody.Status,
+			Message: respBody.Message,
+			Data:    respBody.Data,
+		}, ""
 	}
 	return nil, result
 }
 
 // Disconnect from server
+// It also stops reconnecting and fails all the pending requests
 // 断开与服务器的连接
 func (client *Client) Disconnect() {
+	client.lock.Lock()
+	if client.stopChan != nil {
+		select {
+		case <-client.stopChan:
+		default:
+			close(client.stopChan)
+		}
+	}
+	conn := client.conn
+	client.conn = nil
+	client.lock.Unlock()
 	//停止心跳包
 	client.stopAutoPing()
 	//断开连接
-	client.conn.Disconnect()
-	client.conn = nil
+	if conn != nil {
+		conn.Disconnect()
+	}
+	client.setState(ClientStateClosed, nil)
+	client.failPending(ErrClientClosed)
+	client.closePushStreams()
 }
 
-// Start ping pong
-// 开始心跳
+// Start ping pong, the interval is the KeepAliveTime sent to the server
+// If too many pings are missed, the connection is regarded as dead and closed
+// 开始心跳，间隔与发送给服务器的一致，连续多次未收到回复则断开连接
 func (client *Client) startAutoPing() {
-	client.pingTimer = NewTimer(60*time.Second, func() {
-		if client.conn != nil {
-			client.conn.SendPing()
+	client.stopAutoPing()
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	client.pingTimer = NewTimer(time.Duration(client.options.KeepAliveTime)*time.Second, func() {
+		client.lock.Lock()
+		conn := client.conn
+		client.lock.Unlock()
+		if conn == nil {
+			return
 		}
+		if conn.MissedPings() >= client.options.MaxMissedPings {
+			client.logger.Warningf("%d pings missed, close the connection", conn.MissedPings())
+			//The reading thread will stop and notify the client
+			//读线程会结束并通知客户端
+			conn.closeWithError(ErrKeepAliveTimeout)
+			return
+		}
+		conn.SendPing()
 	})
 }
 
 // Stop ping pong
 // 结束心跳
 func (client *Client) stopAutoPing() {
+	client.lock.Lock()
+	pingTimer := client.pingTimer
+	client.pingTimer = nil
+	client.lock.Unlock()
 	//防止重复调用
-	if client.pingTimer != nil {
-		client.pingTimer.Stop()
-		client.pingTimer = nil
+	if pingTimer != nil {
+		pingTimer.Stop()
+	}
+}
+
+// OnPush register a handler for the push notifications of pushType
+// Registering again replaces the old handler, pass nil to remove it
+// 注册某种类型推送的处理函数
+func (client *Client) OnPush(pushType string, handler PushHandler) {
+	client.pushLock.Lock()
+	defer client.pushLock.Unlock()
+	if handler == nil {
+		delete(client.pushHandlers, pushType)
+	} else {
+		client.pushHandlers[pushType] = handler
+	}
+}
+
+// OnAnyPush register a handler for all the push notifications that don't have a handler registered by OnPush
+// 注册未单独注册类型的推送的处理函数
+func (client *Client) OnAnyPush(handler PushHandler) {
+	client.pushLock.Lock()
+	defer client.pushLock.Unlock()
+	client.anyPushHandler = handler
+}
+
+// Pushes returns a channel that receives every push notification
+// If the channel is full, the push is dropped instead of blocking the reading thread
+// The channel is closed once the client is closed
+// 返回接收所有推送的通道，通道满时丢弃推送，客户端关闭后通道关闭
+func (client *Client) Pushes(bufferSize int) <-chan *Push {
+	stream := make(chan *Push, bufferSize)
+	client.pushLock.Lock()
+	client.pushStreams = append(client.pushStreams, stream)
+	client.pushLock.Unlock()
+	return stream
+}
+
+func (client *Client) closePushStreams() {
+	client.pushLock.Lock()
+	pushStreams := client.pushStreams
+	client.pushStreams = nil
+	client.pushLock.Unlock()
+	for _, stream := range pushStreams {
+		close(stream)
+	}
+}
+
+// Dispatch the push notification to the handlers and the streams
+// 分发推送
+func (client *Client) onPush(conn *SocketClientConn, msg *packet.SendReq) {
+	push := &Push{
+		Type:    msg.Type,
+		Payload: msg.Payload,
+		Data:    msg.Data,
+	}
+	client.pushLock.RLock()
+	handler, ok := client.pushHandlers[push.Type]
+	if !ok {
+		handler = client.anyPushHandler
+	}
+	for _, stream := range client.pushStreams {
+		select {
+		case stream <- push:
+		default:
+			client.logger.Warningf("push stream full, drop push %s", push.Type)
+		}
+	}
+	client.pushLock.RUnlock()
+	if handler != nil {
+		handler(push)
 	}
 }
 
-// OnSendReqReceived Override this function to handle the push notification from server
-// 收到服务器推送
+// OnSendReqReceived is kept for compatibility, overriding it has no effect since Go doesn't support virtual methods
+// Use OnPush, OnAnyPush or Pushes to handle the push notification from server
+// 收到服务器推送，请使用OnPush
 // ClientConnInterface
 func (client *Client) OnSendReqReceived(reqType string, reqBody string) {}
 
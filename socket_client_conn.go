@@ -1,14 +1,20 @@
 package gosocket
 
 import (
+	"bufio"
+	"context"
 	"github.com/yankawayu/go-socket/packet"
 	"io"
 	"log"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// QueueLength is the default max amount of messages waiting to be sent by the client, see ClientOptions.QueueLength
+// 客户端默认的发送队列长度
 const QueueLength = 50
 
 type SendReqCallback func(payloadBody string)
@@ -20,6 +26,16 @@ type ClientConnInterface interface {
 	OnDisconnect()
 }
 
+// connOwner is implemented by Client to receive the events of its connections
+// The events carry the connection itself, so that Client can tell an old connection from the current one
+// Client内部使用的连接事件回调
+type connOwner interface {
+	// onConnLost called by the reading thread once the connection is off
+	onConnLost(conn *SocketClientConn)
+	// onPush called by the reading thread once there is a push notification from the server
+	onPush(conn *SocketClientConn, msg *packet.SendReq)
+}
+
 // SocketClientConn is a class inside Client responsible for connecting to the server
 type SocketClientConn struct {
 	cInterface ClientConnInterface
@@ -42,13 +58,44 @@ type SocketClientConn struct {
 
 	msgManager *packet.MessageManager //协议层的包管理器
 	log        ILogger                //输出日志用
+
+	// owner is used to notify Client, it's nil if the connection is used alone
+	//所属的客户端
+	owner connOwner
+	// closeChan is closed once the reading thread has stopped
+	//读线程结束后关闭
+	closeChan chan struct{}
+	// discType is the type of the Disconnect message received from the server
+	//服务器发来的断开类型
+	discType packet.DiscType
+
+	// readTimeout is the max idle time of reading, 0 means no limit
+	// The connection will be closed if nothing arrives in this period
+	//读超时时间，超时后断开连接
+	readTimeout time.Duration
+	// missedPings is the amount of PingReq sent since the last PingResp
+	//未收到回复的心跳包数量
+	missedPings int32
+	// closeErr is the reason why the connection is off
+	//断开原因
+	closeErr  error
+	closeLock sync.Mutex
 }
 
 func NewSocketClientConn(connection net.Conn, log ILogger) *SocketClientConn {
+	cli := newSocketClientConn(connection, log, nil, QueueLength)
+	cli.start()
+	return cli
+}
+
+// newSocketClientConn create a connection owned by a Client without starting it
+// The fields must be set before the threads start, call start after that
+// 创建属于某个客户端的连接，设置完成后再调用start启动读写线程
+func newSocketClientConn(connection net.Conn, log ILogger, owner connOwner, queueLength int) *SocketClientConn {
 	cli := &SocketClientConn{
 		conn:        connection,
-		jobChan:     make(chan Job, QueueLength),
-		connAckChan: make(chan *packet.ConnAck),
+		jobChan:     make(chan Job, queueLength),
+		connAckChan: make(chan *packet.ConnAck, 1),
 		reqMsgId:    1,
 		msgIdLock:   &sync.RWMutex{},
 		reqMsgMap:   make(map[uint16]SendReqCallback),
@@ -62,13 +109,20 @@ func NewSocketClientConn(connection net.Conn, log ILogger) *SocketClientConn {
 				EnablePayloadGzip: true,                   //是否开启gzip
 			},
 		},
-		log: log,
+		log:       log,
+		owner:     owner,
+		closeChan: make(chan struct{}),
 	}
-	go cli.startReader()
-	go cli.startWriter()
 	return cli
 }
 
+// Start the reading and writing threads
+// 启动读写线程
+func (client *SocketClientConn) start() {
+	go client.startReader()
+	go client.startWriter()
+}
+
 func (client *SocketClientConn) SetConnInterface(connInterface ClientConnInterface) {
 	client.cInterface = connInterface
 }
@@ -79,18 +133,35 @@ func (client *SocketClientConn) GetConnInterface() ClientConnInterface {
 
 func (client *SocketClientConn) startReader() {
 	defer func() {
+		close(client.closeChan)
 		close(client.jobChan)
 		client.conn.Close()
 		if client.cInterface != nil {
 			client.cInterface.OnDisconnect()
 		}
+		if client.owner != nil {
+			client.owner.onConnLost(client)
+		}
 		//log.Println("reader stopped")
 	}()
+	//Decode from the buffer instead of reading the connection for each field
+	//从缓冲区解码，而不是每个字段都读取连接
+	reader := bufio.NewReader(client.conn)
 	for {
+		//Without any data in readTimeout, including ping pong messages, the connection is regarded as dead
+		//超时未收到任何数据（包括心跳回复），认为连接已失效
+		if client.readTimeout > 0 {
+			_ = client.conn.SetReadDeadline(time.Now().Add(client.readTimeout))
+		}
 		//log.Println("start waiting to read")
 		//获取消息
-		msg, err := client.msgManager.DecodeMessage(client.conn)
+		msg, err := client.msgManager.DecodeMessage(reader)
 		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				log.Println("read timeout")
+				client.setCloseErr(ErrKeepAliveTimeout)
+				return
+			}
 			//Whether the server close the connection
 			//捕获到服务器关闭连接的信号
 			if err == io.EOF {
@@ -111,13 +182,15 @@ func (client *SocketClientConn) startReader() {
 			client.connAckChan <- msg
 		case *packet.PingResp:
 			//log.Println("got ping response")
+			atomic.StoreInt32(&client.missedPings, 0)
 		case *packet.SendResp:
 			client.handleSendResp(msg.MessageId, msg.Payload)
 		case *packet.SendReq:
 			//收到服务器推送的SyncKey变化
-			client.handleSendReq(msg.Type, msg.Payload)
+			client.handleSendReq(msg)
 		case *packet.Disconnect:
 			log.Println("receive disconnect")
+			client.discType = msg.Type
 			return
 		default:
 			log.Printf("unknown message type %T", msg)
@@ -134,10 +207,13 @@ func (client *SocketClientConn) startWriter() {
 		err := client.msgManager.EncodeMessage(client.conn, job.Message)
 		//通知消息发送完成
 		if job.Receipt != nil {
-			close(job.Receipt)
+			job.Receipt.resolve(err)
 		}
 		if err != nil {
 			log.Println("write error", err)
+			//Make sure the reading thread stops as well
+			//确保读线程也结束
+			client.conn.Close()
 			return
 		}
 		//确保发完Disconnect消息马上结束
@@ -149,6 +225,12 @@ func (client *SocketClientConn) startWriter() {
 }
 
 func (client *SocketClientConn) Connect(loginInfo string) error {
+	return client.ConnectContext(context.Background(), loginInfo)
+}
+
+// ConnectContext send the Connect message and wait for the ConnAck message until the ctx is done
+// 发送连接消息，等待连接回复直到ctx结束
+func (client *SocketClientConn) ConnectContext(ctx context.Context, loginInfo string) error {
 	connectMsg := &packet.Connect{
 		Payload: loginInfo,
 	}
@@ -158,8 +240,14 @@ func (client *SocketClientConn) Connect(loginInfo string) error {
 	//Block again until there is a ConnAck message
 	//This is how the connect message works
 	//阻塞等待连接回复
-	ack := <-client.connAckChan
-	return packet.ConnectionErrors[ack.ReturnCode]
+	select {
+	case ack := <-client.connAckChan:
+		return packet.ConnectionErrors[ack.ReturnCode]
+	case <-client.closeChan:
+		return ErrConnectionLost
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (client *SocketClientConn) Disconnect() {
@@ -167,11 +255,27 @@ func (client *SocketClientConn) Disconnect() {
 	client.submit(disconnectMsg)
 }
 
+// Close the underlying connection without sending Disconnect message
+// 直接关闭底层连接
+func (client *SocketClientConn) Close() error {
+	return client.conn.Close()
+}
+
+// DisconnectType returns the type of the Disconnect message sent by the server
+// Only valid after the connection is off
+// 服务器断开连接的类型，仅在连接断开后有效
+func (client *SocketClientConn) DisconnectType() packet.DiscType {
+	return client.discType
+}
+
 func (client *SocketClientConn) SendRequest(payloadType string, payload string, callback SendReqCallback, data []byte) {
-	replyLevel := packet.RLevelReplyLater
-	if callback == nil {
-		replyLevel = packet.RLevelNoReply
-	}
+	msgId := client.addRequest(callback)
+	client.writeRequest(msgId, payloadType, payload, callback != nil, data)
+}
+
+// Allocate the message id of a request, and save the callback waiting for its response if it isn't nil
+// 分配请求的消息id，如果回调不为空，加入等待回复的消息map
+func (client *SocketClientConn) addRequest(callback SendReqCallback) uint16 {
 	client.msgIdLock.Lock()
 	msgId := client.reqMsgId
 	client.reqMsgId++
@@ -183,6 +287,24 @@ func (client *SocketClientConn) SendRequest(payloadType string, payload string,
 		client.reqMsgMap[msgId] = callback
 		client.mapLock.Unlock()
 	}
+	return msgId
+}
+
+// Forget the callback of the request which has timed out or been cancelled, its response will be ignored
+// 移除已超时或取消的请求的回调，之后的回复会被忽略
+func (client *SocketClientConn) removeRequest(msgId uint16) {
+	client.mapLock.Lock()
+	delete(client.reqMsgMap, msgId)
+	client.mapLock.Unlock()
+}
+
+// Send the request with the message id allocated by addRequest
+// 发送已分配消息id的请求
+func (client *SocketClientConn) writeRequest(msgId uint16, payloadType string, payload string, needReply bool, data []byte) {
+	replyLevel := packet.RLevelReplyLater
+	if !needReply {
+		replyLevel = packet.RLevelNoReply
+	}
 	hasData := false
 	if len(data) > 0 {
 		hasData = true
@@ -200,18 +322,51 @@ func (client *SocketClientConn) SendRequest(payloadType string, payload string,
 
 func (client *SocketClientConn) SendPing() {
 	pingMsg := &packet.PingReq{}
+	atomic.AddInt32(&client.missedPings, 1)
 	client.sync(pingMsg)
 	//log.Println("ping sent")
 }
 
-func (client *SocketClientConn) handleSendReq(msgType string, msgPayload string) {
+// MissedPings returns the amount of PingReq sent without receiving PingResp
+// 未收到回复的心跳包数量
+func (client *SocketClientConn) MissedPings() int {
+	return int(atomic.LoadInt32(&client.missedPings))
+}
+
+// Close the connection and record the reason
+// 关闭连接并记录原因
+func (client *SocketClientConn) closeWithError(err error) {
+	client.setCloseErr(err)
+	_ = client.conn.Close()
+}
+
+func (client *SocketClientConn) setCloseErr(err error) {
+	client.closeLock.Lock()
+	if client.closeErr == nil {
+		client.closeErr = err
+	}
+	client.closeLock.Unlock()
+}
+
+// CloseError returns the reason why the connection is off, nil if it's unknown
+// 连接断开的原因
+func (client *SocketClientConn) CloseError() error {
+	client.closeLock.Lock()
+	defer client.closeLock.Unlock()
+	return client.closeErr
+}
+
+func (client *SocketClientConn) handleSendReq(msg *packet.SendReq) {
 	defer func() {
 		if err := recover(); err != nil {
 			client.log.Error(err)
 		}
 	}()
 	if client.cInterface != nil {
-		client.cInterface.OnSendReqReceived(msgType, msgPayload)
+		client.cInterface.OnSendReqReceived(msg.Type, msg.Payload)
+	}
+	if client.owner != nil {
+		client.owner.onPush(client, msg)
 	}
 }
 
@@ -242,17 +397,29 @@ func (client *SocketClientConn) sync(message packet.IMessage) {
 	}()
 	job := Job{
 		Message: message,
-		Receipt: make(Receipt),
+		Receipt: newReceipt(),
+	}
+	select {
+	case client.jobChan <- job:
+	case <-client.closeChan:
+		return
+	}
+	//Block until the message is sent or the connection is off
+	//阻塞直到消息发送完成或连接断开
+	select {
+	case <-job.Receipt.Done():
+	case <-client.closeChan:
 	}
-	client.jobChan <- job
-	//Block until the message is sent
-	//阻塞直到消息发送完成
-	job.Receipt.Wait()
 }
 
 // Add the message into sending queue and return immediately
 // 将消息异步加入任务队列
 func (client *SocketClientConn) submit(message packet.IMessage) {
+	defer func() {
+		if err := recover(); err != nil {
+			client.log.Error(err)
+		}
+	}()
 	job := Job{
 		Message: message,
 	}
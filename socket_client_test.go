@@ -0,0 +1,87 @@
+package gosocket_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	gosocket "github.com/yankawayu/go-socket"
+	"github.com/yankawayu/go-socket/gosockettest"
+	"github.com/yankawayu/go-socket/packet"
+)
+
+func TestDefaultReadTimeoutAfterPingCheck(t *testing.T) {
+	cases := []struct {
+		keepAlive      uint16
+		maxMissedPings int
+	}{
+		{60, 2},
+		{1, 1},
+		{10, 5},
+	}
+	for _, c := range cases {
+		options := &gosocket.ClientOptions{KeepAliveTime: c.keepAlive, MaxMissedPings: c.maxMissedPings}
+		gosocket.NewClientWithOptions("127.0.0.1", 1, false, gosockettest.NewRecorder(), nil, options)
+		interval := time.Duration(c.keepAlive) * time.Second
+		//The ping check closes a silent connection at the tick after the last missed ping
+		pingCheck := time.Duration(c.maxMissedPings+1) * interval
+		if options.ReadTimeout <= pingCheck || options.ReadTimeout >= pingCheck+interval {
+			t.Fatalf("read timeout %v with %d missed pings of %v, want it between %v and the next ping", options.ReadTimeout, c.maxMissedPings, interval, pingCheck)
+		}
+	}
+}
+
+// Accept the connection, but never respond to the pings
+func serveWithoutPong(conn net.Conn) {
+	manager := &packet.MessageManager{}
+	reader := bufio.NewReader(conn)
+	for {
+		msg, err := manager.DecodeMessage(reader)
+		if err != nil {
+			return
+		}
+		if _, ok := msg.(*packet.Connect); ok {
+			if err := manager.EncodeMessage(conn, &packet.ConnAck{ReturnCode: packet.RetCodeAccepted}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestMissedPingsCloseBeforeReadTimeout(t *testing.T) {
+	recorder := gosockettest.NewRecorder()
+	closed := make(chan error, 1)
+	options := &gosocket.ClientOptions{
+		KeepAliveTime:  1,
+		MaxMissedPings: 1,
+		Dialer: func(ctx context.Context, addr string) (net.Conn, error) {
+			clientConn, serverConn := net.Pipe()
+			go serveWithoutPong(serverConn)
+			return clientConn, nil
+		},
+		OnStateChange: func(state gosocket.ClientState, err error) {
+			if state == gosocket.ClientStateClosed {
+				closed <- err
+			}
+		},
+	}
+	client := gosocket.NewClientWithOptions("127.0.0.1", 1, false, recorder, nil, options)
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+	select {
+	case err := <-closed:
+		if err != gosocket.ErrKeepAliveTimeout {
+			t.Fatalf("closed with %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection without pong isn't closed")
+	}
+	//The read timeout also closes with ErrKeepAliveTimeout, only the ping check logs the missed pings
+	if !recorder.Contains("pings missed") {
+		t.Fatal("closed by the read timeout before the missed pings are detected")
+	}
+}
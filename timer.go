@@ -1,19 +1,23 @@
 package gosocket
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
 // Timer is a utility class for the framework
 type Timer struct {
 	ticker     *time.Ticker
 	tickerDone chan bool
+	stopOnce   sync.Once
 }
 
 func (timer *Timer) Stop() {
-	//Validate timer.ticker first to avoid accessing a closed channel
-	//防止channel已关闭
-	if timer.ticker != nil {
-		timer.tickerDone <- true
-	}
+	//Closing the channel only once makes it safe to call Stop multiple times and from different threads
+	//只关闭一次，确保多次或并发调用都是安全的
+	timer.stopOnce.Do(func() {
+		close(timer.tickerDone)
+	})
 }
 
 type Callback func()
@@ -27,11 +31,7 @@ func NewTimer(interval time.Duration, callback Callback) *Timer {
 		defer func() {
 			//Stop the timer once the loop is over
 			//循环停止后再停止计时器
-			if timer.ticker != nil {
-				timer.ticker.Stop()
-			}
-			timer.ticker = nil
-			close(timer.tickerDone)
+			timer.ticker.Stop()
 		}()
 		//This label is essential here. Or else the break can only break out of select block
 		//必须通过Label，否则break只会跳出select
@@ -0,0 +1,332 @@
+package gosocket
+
+import (
+	"fmt"
+	"math"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// The tag used to declare the validation rules of the param fields
+// 声明参数校验规则的tag
+const kValidateTag = "validate"
+
+// FieldError describes a field that failed the validation
+// 校验失败的字段
+type FieldError struct {
+	Field   string `json:"field"`           // The json path of the field, e.g. `items[0].name`
+	Rule    string `json:"rule"`            // The rule that failed, e.g. `max`
+	Param   string `json:"param,omitempty"` // The param of the rule, e.g. `200`
+	Message string `json:"message"`         // The readable error message
+}
+
+// ValidationErrors is the list of the fields that failed the validation
+// It's sent as the data of the response with StatusError if the param of an action is invalid
+// 校验失败的字段列表，请求参数校验失败时作为返回数据
+type ValidationErrors []*FieldError
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ShowError implements IUserError, so that the errors can be panicked in the actions
+func (errs ValidationErrors) ShowError() string {
+	return errs.Error()
+}
+
+// Validate check the struct v against the rules in the `validate` tags of its fields
+// The rules are separated by comma, e.g. `validate:"required,max=200"`
+//
+//	required      the field can't be zero value
+//	omitempty     skip the other rules if the field is zero value
+//	min=n, max=n  the min/max value of numbers, or the min/max length of strings, slices and maps
+//	len=n         the exact value of numbers, or the exact length of strings, slices and maps
+//	oneof=a b c   the field must be one of the values separated by space
+//	email         the field must be an email address
+//
+// The struct fields and the slices of structs are validated recursively
+// The tags of a struct type are checked once it's first seen, it panics if there is an unknown rule,
+// a malformed number or a rule that can't be used on the field, the params of the actions are checked once they're registered
+// 根据字段的validate tag校验结构体，结构体及结构体切片会被递归校验
+// 结构体类型的tag在首次使用时检查，有未知规则、数字格式错误或规则不适用于字段时panic，action的参数在注册时检查
+func Validate(v interface{}) ValidationErrors {
+	var errs ValidationErrors
+	validateValue(reflect.ValueOf(v), "", &errs)
+	return errs
+}
+
+// validateRule is a parsed rule in the tag
+type validateRule struct {
+	name  string
+	param string
+	limit float64 // the number parsed from the param of min, max and len
+}
+
+// validateField is a field with its rules
+type validateField struct {
+	index int
+	name  string
+	rules []validateRule
+}
+
+// The parsed fields of the struct types
+// 已解析的结构体字段，避免重复解析tag
+var validateFieldCache sync.Map
+
+func validateValue(value reflect.Value, path string, errs *ValidationErrors) {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+	switch value.Kind() {
+	case reflect.Struct:
+		for _, field := range getValidateFields(value.Type()) {
+			fieldValue := value.Field(field.index)
+			fieldPath := joinFieldPath(path, field.name)
+			if validateRules(fieldValue, fieldPath, field.rules, errs) {
+				validateValue(fieldValue, fieldPath, errs)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			validateValue(value.Index(i), path+"["+strconv.Itoa(i)+"]", errs)
+		}
+	}
+}
+
+// Run the rules on the field, returns whether the field should be validated recursively
+// 校验字段，返回是否需要继续递归校验
+func validateRules(value reflect.Value, path string, rules []validateRule, errs *ValidationErrors) bool {
+	for _, rule := range rules {
+		switch rule.name {
+		case "required":
+			if value.IsZero() {
+				*errs = append(*errs, newFieldError(path, rule, path+" is required"))
+				return false
+			}
+		case "omitempty":
+			if value.IsZero() {
+				return false
+			}
+		default:
+			//Only non-nil pointers are checked, nil ones should be caught by required
+			//nil指针不检查，需要时用required
+			checkValue := reflect.Indirect(value)
+			if !checkValue.IsValid() {
+				return false
+			}
+			if message := checkRule(checkValue, path, rule); message != "" {
+				*errs = append(*errs, newFieldError(path, rule, message))
+			}
+		}
+	}
+	return true
+}
+
+// Check the value against a rule with param, returns the error message if it failed
+// 校验带参数的规则，失败时返回错误信息
+func checkRule(value reflect.Value, path string, rule validateRule) string {
+	switch rule.name {
+	case "min", "max", "len":
+		//Only the values of interfaces can't be measured, the other types are checked with the tags
+		//只有接口的值可能无法度量，其他类型在检查tag时已确认
+		size, unit, ok := measureValue(value)
+		if !ok {
+			panic("validate rule " + rule.name + " can't be used on " + path)
+		}
+		switch {
+		case rule.name == "min" && size < rule.limit:
+			return fmt.Sprintf("%s must be at least %s%s", path, rule.param, unit)
+		case rule.name == "max" && size > rule.limit:
+			return fmt.Sprintf("%s must be at most %s%s", path, rule.param, unit)
+		case rule.name == "len" && size != rule.limit:
+			return fmt.Sprintf("%s must be exactly %s%s", path, rule.param, unit)
+		}
+	case "oneof":
+		//The fields of the unexported embedded structs can't be turned into interfaces, so print the value itself
+		//非导出的嵌入结构体的字段不能转为interface，直接打印reflect.Value
+		str := fmt.Sprint(value)
+		for _, option := range strings.Fields(rule.param) {
+			if str == option {
+				return ""
+			}
+		}
+		return fmt.Sprintf("%s must be one of [%s]", path, rule.param)
+	case "email":
+		if value.Kind() != reflect.String {
+			panic("validate rule email can't be used on " + path)
+		}
+		address, err := mail.ParseAddress(value.String())
+		if err != nil || address.Address != value.String() {
+			return path + " must be an email address"
+		}
+	}
+	return ""
+}
+
+// Get the number to compare with the param of min, max and len
+// Returns the value of numbers, or the length of strings, slices and maps with the unit
+// 获取用于比较的数值，数字取值，字符串、切片和map取长度
+func measureValue(value reflect.Value) (size float64, unit string, ok bool) {
+	switch value.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(value.String())), " characters", true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(value.Len()), " items", true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), "", true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), "", true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), "", true
+	}
+	return 0, "", false
+}
+
+// Whether the rule can be used on the field type, it's checked once the param type is registered
+// The values of interfaces are only known while validating, so they're accepted
+// 规则能否用于该字段类型，在注册时检查，接口类型的值只有校验时才知道，因此不检查
+func ruleFitsType(rule string, fieldType reflect.Type) bool {
+	for fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	if fieldType.Kind() == reflect.Interface {
+		return true
+	}
+	switch rule {
+	case "min", "max", "len":
+		switch fieldType.Kind() {
+		case reflect.String, reflect.Slice, reflect.Array, reflect.Map,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			return true
+		}
+		return false
+	case "email":
+		return fieldType.Kind() == reflect.String
+	}
+	return true
+}
+
+// Parse and check the tags of the struct type, the result is cached
+// It panics if a rule is unknown, has a malformed param, or can't be used on the field, so that nothing is cached
+// 解析并检查结构体的tag，结果会被缓存，规则未知、参数错误或不适用于字段时panic，不会缓存
+func getValidateFields(structType reflect.Type) []validateField {
+	if fields, ok := validateFieldCache.Load(structType); ok {
+		return fields.([]validateField)
+	}
+	fields := make([]validateField, 0)
+	for i := 0; i < structType.NumField(); i++ {
+		structField := structType.Field(i)
+		//Unexported fields are never decoded from the payload, except the fields of the embedded structs
+		//非导出字段不会被解码，跳过，但匿名嵌入结构体的字段会被解码
+		if structField.PkgPath != "" && !(structField.Anonymous && indirectType(structField.Type).Kind() == reflect.Struct) {
+			continue
+		}
+		name := structField.Name
+		if jsonName := strings.Split(structField.Tag.Get("json"), ",")[0]; jsonName == "-" {
+			continue
+		} else if jsonName != "" {
+			name = jsonName
+		}
+		field := validateField{
+			index: i,
+			name:  name,
+			rules: parseValidateRules(structField.Tag.Get(kValidateTag)),
+		}
+		if err := checkFieldRules(structField, field.rules); err != "" {
+			panic(err + " on field " + structField.Name + " of " + structType.String())
+		}
+		//Embedded structs are validated as if their fields are in the outer one
+		//匿名嵌入的结构体与外层共用路径
+		if structField.Anonymous && structField.Tag.Get("json") == "" {
+			field.name = ""
+		}
+		fields = append(fields, field)
+	}
+	validateFieldCache.Store(structType, fields)
+	return fields
+}
+
+func parseValidateRules(tag string) []validateRule {
+	rules := make([]validateRule, 0)
+	if tag == "" {
+		return rules
+	}
+	for _, ruleStr := range strings.Split(tag, ",") {
+		ruleStr = strings.TrimSpace(ruleStr)
+		if ruleStr == "" {
+			continue
+		}
+		rule := validateRule{name: ruleStr}
+		if index := strings.Index(ruleStr, "="); index >= 0 {
+			rule.name = ruleStr[:index]
+			rule.param = ruleStr[index+1:]
+		}
+		if rule.name == "min" || rule.name == "max" || rule.name == "len" {
+			//NaN never fails the comparisons, but a malformed param is rejected by checkFieldRules before it's used
+			//NaN不会使比较失败，但格式错误的参数在使用前会被checkFieldRules拒绝
+			limit, err := strconv.ParseFloat(rule.param, 64)
+			if err != nil {
+				limit = math.NaN()
+			}
+			rule.limit = limit
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// Check the rules of the field, returns the reason if any of them is wrong
+// 检查字段的规则，有错误时返回原因
+func checkFieldRules(structField reflect.StructField, rules []validateRule) string {
+	for _, rule := range rules {
+		switch rule.name {
+		case "required", "omitempty", "email", "oneof":
+		case "min", "max", "len":
+			if math.IsNaN(rule.limit) {
+				return "invalid param " + rule.param + " of validate rule " + rule.name
+			}
+		default:
+			return "unknown validate rule " + rule.name
+		}
+		if !ruleFitsType(rule.name, structField.Type) {
+			return "validate rule " + rule.name + " can't be used on type " + structField.Type.String()
+		}
+	}
+	return ""
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func joinFieldPath(path string, name string) string {
+	if path == "" || name == "" {
+		return path + name
+	}
+	return path + "." + name
+}
+
+func newFieldError(path string, rule validateRule, message string) *FieldError {
+	return &FieldError{
+		Field:   path,
+		Rule:    rule.name,
+		Param:   rule.param,
+		Message: message,
+	}
+}
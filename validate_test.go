@@ -0,0 +1,272 @@
+package gosocket_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	gosocket "github.com/yankawayu/go-socket"
+	"github.com/yankawayu/go-socket/gosockettest"
+)
+
+type validateRules struct {
+	Name   string            `json:"name" validate:"required,min=2,max=5"`
+	Nick   string            `json:"nick" validate:"omitempty,min=3"`
+	Age    int               `json:"age" validate:"min=18,max=99"`
+	Score  float64           `json:"score" validate:"max=1.5"`
+	Code   string            `json:"code" validate:"omitempty,len=4"`
+	Tags   []string          `json:"tags" validate:"max=2"`
+	Extras map[string]string `json:"extras" validate:"omitempty,len=1"`
+	Kind   string            `json:"kind" validate:"oneof=text image"`
+	Level  int               `json:"level" validate:"omitempty,oneof=1 2 3"`
+	Email  string            `json:"email" validate:"omitempty,email"`
+	Count  *int              `json:"count" validate:"omitempty,min=1"`
+	Owner  *string           `json:"owner" validate:"required"`
+	Ignore string            `json:"-" validate:"required"`
+	Plain  string            `validate:"omitempty,max=1"`
+}
+
+// A valid value of validateRules, each case breaks one field
+func validRules() *validateRules {
+	owner := "bob"
+	return &validateRules{Name: "bob", Age: 20, Kind: "text", Owner: &owner}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+// The field paths and the rules of the errors
+func fieldErrors(errs gosocket.ValidationErrors) []string {
+	fields := make([]string, 0, len(errs))
+	for _, err := range errs {
+		fields = append(fields, err.Field+":"+err.Rule)
+	}
+	return fields
+}
+
+func TestValidateRules(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(v *validateRules)
+		want   []string
+	}{
+		{"valid", func(v *validateRules) {}, nil},
+		{"required string", func(v *validateRules) { v.Name = "" }, []string{"name:required"}},
+		{"required nil pointer", func(v *validateRules) { v.Owner = nil }, []string{"owner:required"}},
+		{"required pointer to zero value", func(v *validateRules) { v.Owner = new(string) }, nil},
+		{"min of string", func(v *validateRules) { v.Name = "b" }, []string{"name:min"}},
+		{"max of string counts runes", func(v *validateRules) { v.Name = "张三李四王" }, nil},
+		{"max of string", func(v *validateRules) { v.Name = "robert" }, []string{"name:max"}},
+		{"omitempty skips empty", func(v *validateRules) { v.Nick = "" }, nil},
+		{"omitempty checks the rest", func(v *validateRules) { v.Nick = "bo" }, []string{"nick:min"}},
+		{"min of int", func(v *validateRules) { v.Age = 17 }, []string{"age:min"}},
+		{"max of int", func(v *validateRules) { v.Age = 100 }, []string{"age:max"}},
+		{"max of float", func(v *validateRules) { v.Score = 1.6 }, []string{"score:max"}},
+		{"len of string", func(v *validateRules) { v.Code = "abc" }, []string{"code:len"}},
+		{"max of slice", func(v *validateRules) { v.Tags = []string{"a", "b", "c"} }, []string{"tags:max"}},
+		{"len of map", func(v *validateRules) { v.Extras = map[string]string{"a": "1", "b": "2"} }, []string{"extras:len"}},
+		{"oneof of string", func(v *validateRules) { v.Kind = "video" }, []string{"kind:oneof"}},
+		{"oneof of int", func(v *validateRules) { v.Level = 4 }, []string{"level:oneof"}},
+		{"oneof of int valid", func(v *validateRules) { v.Level = 2 }, nil},
+		{"email", func(v *validateRules) { v.Email = "bob" }, []string{"email:email"}},
+		{"email with name", func(v *validateRules) { v.Email = "Bob <bob@example.com>" }, []string{"email:email"}},
+		{"email valid", func(v *validateRules) { v.Email = "bob@example.com" }, nil},
+		{"nil pointer skips the rules", func(v *validateRules) { v.Count = nil }, nil},
+		{"pointer is checked by its value", func(v *validateRules) { v.Count = intPtr(0) }, []string{"count:min"}},
+		{"field without json tag", func(v *validateRules) { v.Plain = "ab" }, []string{"Plain:max"}},
+		{"several fields", func(v *validateRules) { v.Name, v.Age = "", 0 }, []string{"name:required", "age:min"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			v := validRules()
+			c.modify(v)
+			if got := fieldErrors(gosocket.Validate(v)); !reflect.DeepEqual(got, c.want) && (len(got) > 0 || len(c.want) > 0) {
+				t.Fatalf("errors %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestValidateNil(t *testing.T) {
+	var v *validateRules
+	if errs := gosocket.Validate(v); len(errs) != 0 {
+		t.Fatalf("errors of a nil pointer %v", errs)
+	}
+	if errs := gosocket.Validate(nil); len(errs) != 0 {
+		t.Fatalf("errors of nil %v", errs)
+	}
+}
+
+type validateItem struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type validateBase struct {
+	Id int `json:"id" validate:"min=1"`
+}
+
+type validateNamedBase struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type validateNested struct {
+	validateBase
+	validateNamedBase `json:"base"`
+	Item              validateItem    `json:"item"`
+	ItemPtr           *validateItem   `json:"itemPtr"`
+	Items             []validateItem  `json:"items" validate:"max=3"`
+	ItemPtrs          []*validateItem `json:"itemPtrs"`
+	Matrix            [][]validateItem
+	Skipped           *validateItem `json:"skipped" validate:"omitempty"`
+}
+
+func TestValidateFieldPaths(t *testing.T) {
+	v := &validateNested{
+		validateNamedBase: validateNamedBase{Code: "c"},
+		Item:              validateItem{Name: "a"},
+		ItemPtr:           &validateItem{},
+		Items:             []validateItem{{Name: "a"}, {}},
+		ItemPtrs:          []*validateItem{nil, {}},
+		Matrix:            [][]validateItem{{{Name: "a"}}, {{Name: "a"}, {}}},
+	}
+	want := []string{
+		"id:min",
+		"itemPtr.name:required",
+		"items[1].name:required",
+		"itemPtrs[1].name:required",
+		"Matrix[1][1].name:required",
+	}
+	if got := fieldErrors(gosocket.Validate(v)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("errors %v, want %v", got, want)
+	}
+	//The embedded struct with a json tag has its own path
+	v.validateBase.Id, v.validateNamedBase.Code = 1, ""
+	v.ItemPtr, v.Items, v.ItemPtrs, v.Matrix = nil, nil, nil, nil
+	if got := fieldErrors(gosocket.Validate(v)); !reflect.DeepEqual(got, []string{"base.code:required"}) {
+		t.Fatalf("errors %v of the named embedded struct", got)
+	}
+}
+
+func TestValidationErrorsResponse(t *testing.T) {
+	app := gosocket.NewApp()
+	app.Handle("validate.Check", gosocket.NewTypedHandler(func(ctx context.Context, req *validateRules) (*struct{}, error) {
+		return nil, nil
+	}))
+	response := gosockettest.CallApp(app, gosockettest.NewFakeUser(1), "validate.Check", `{"name":"b","age":18,"kind":"text","owner":"bob"}`, nil)
+	body := gosocket.JSONEncode(response)
+	want := `{"status":4,"message":"name must be at least 2 characters","data":[{"field":"name","rule":"min","param":"2","message":"name must be at least 2 characters"}]}`
+	if body != want {
+		t.Fatalf("response %s, want %s", body, want)
+	}
+	var decoded gosocket.ValidationErrors
+	data, _ := json.Marshal(response.Data)
+	if err := json.Unmarshal(data, &decoded); err != nil || len(decoded) != 1 || decoded[0].Param != "2" {
+		t.Fatalf("decoded %v, %v", decoded, err)
+	}
+}
+
+type badUnknownRule struct {
+	Name string `validate:"required,short"`
+}
+
+type badMalformedParam struct {
+	Name string `validate:"max=ten"`
+}
+
+type badEmptyParam struct {
+	Age int `validate:"min="`
+}
+
+type badEmailOnInt struct {
+	Age int `validate:"email"`
+}
+
+type badMinOnStruct struct {
+	Item validateItem `validate:"min=1"`
+}
+
+type badNested struct {
+	Items []badMalformedParam `json:"items"`
+}
+
+type badController struct {
+	gosocket.Controller
+}
+
+func (controller *badController) GetActionParamMap() map[string]interface{} {
+	return map[string]interface{}{
+		"Do": &badNested{},
+	}
+}
+
+func (controller *badController) Do(param *badNested, response *gosocket.ResponseBody) {
+}
+
+// Build a typed handler of the param type, so that each bad param can be registered
+func typedHandlerOf(param interface{}) *gosocket.TypedHandler {
+	ctxType := reflect.TypeOf((*context.Context)(nil)).Elem()
+	errType := reflect.TypeOf((*error)(nil)).Elem()
+	respType := reflect.TypeOf(&struct{}{})
+	fnType := reflect.FuncOf([]reflect.Type{ctxType, reflect.TypeOf(param)}, []reflect.Type{respType, errType}, false)
+	fn := reflect.MakeFunc(fnType, func(args []reflect.Value) []reflect.Value {
+		return []reflect.Value{reflect.Zero(respType), reflect.Zero(errType)}
+	})
+	return gosocket.NewTypedHandler(fn.Interface())
+}
+
+func TestValidateTagsRejectedAtRegistration(t *testing.T) {
+	cases := []struct {
+		name    string
+		param   interface{}
+		message string
+	}{
+		{"unknown rule", &badUnknownRule{}, "unknown validate rule short"},
+		{"malformed param", &badMalformedParam{}, "invalid param ten of validate rule max"},
+		{"empty param", &badEmptyParam{}, "invalid param  of validate rule min"},
+		{"email on int", &badEmailOnInt{}, "validate rule email can't be used on type int"},
+		{"min on struct", &badMinOnStruct{}, "validate rule min can't be used on type gosocket_test.validateItem"},
+		{"nested", &badNested{}, "invalid param ten of validate rule max"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			message := registerPanic(func() {
+				gosocket.NewApp().Handle("bad.Do", typedHandlerOf(c.param))
+			})
+			if !strings.Contains(message, c.message) || !strings.Contains(message, "handler:bad.Do") {
+				t.Fatalf("panic %q of the handler, want %q", message, c.message)
+			}
+		})
+	}
+}
+
+func TestValidateTagsRejectedByRouter(t *testing.T) {
+	message := registerPanic(func() {
+		gosocket.NewApp().Router("bad", &badController{})
+	})
+	if !strings.Contains(message, "invalid param ten of validate rule max") || !strings.Contains(message, "action:Do") {
+		t.Fatalf("panic %q", message)
+	}
+}
+
+func TestValidatePanicsOnWrongTags(t *testing.T) {
+	message := registerPanic(func() {
+		gosocket.Validate(&badMalformedParam{Name: "a"})
+	})
+	if !strings.Contains(message, "invalid param ten of validate rule max") {
+		t.Fatalf("panic %q", message)
+	}
+}
+
+func registerPanic(register func()) (message string) {
+	defer func() {
+		if r := recover(); r != nil {
+			message = fmt.Sprint(r)
+		}
+	}()
+	register()
+	return ""
+}
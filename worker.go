@@ -0,0 +1,128 @@
+package gosocket
+
+import (
+	"strings"
+
+	"github.com/yankawayu/go-socket/packet"
+)
+
+// OrderingKeyFunc returns the ordering key of a request, see AppConfig.OrderingKey
+// The requests of a connection with the same key are processed one by one in the order they arrive,
+// an empty key means the request can be processed in parallel with any other one
+// 返回请求的排序键，同一连接中键相同的请求按到达顺序逐个处理，空字符串表示不限制顺序
+type OrderingKeyFunc func(payloadType string, payload string) string
+
+// OrderByType keeps the requests with the same payload type in order
+// 相同payload type的请求保持顺序
+func OrderByType(payloadType string, payload string) string {
+	return payloadType
+}
+
+// OrderByController keeps the requests to the same controller in order
+// 相同Controller的请求保持顺序
+func OrderByController(payloadType string, payload string) string {
+	return strings.ToLower(strings.Split(payloadType, ".")[0])
+}
+
+// The number of requests of a connection processed in parallel
+// 每个连接并行处理的请求数
+func (app *App) maxConcurrentRequests() int {
+	if app.Config == nil || app.Config.MaxConcurrentRequests < 1 {
+		return 1
+	}
+	return app.Config.MaxConcurrentRequests
+}
+
+func (app *App) orderingKey() OrderingKeyFunc {
+	if app.Config == nil {
+		return nil
+	}
+	return app.Config.OrderingKey
+}
+
+// Process the request by a worker, it blocks until a worker is free, so that the reading is slowed down
+// instead of queueing without bound
+// The responses are matched by MessageId, so they can be sent in any order
+// 交给工作协程处理请求，没有空闲的工作协程时阻塞，从而限制读取速度
+// 答复通过MessageId对应，可以乱序发送
+func (handler *MessageHandler) dispatchSendReq(msg *packet.SendReq) {
+	key := ""
+	if orderingKey := handler.app.orderingKey(); orderingKey != nil {
+		key = orderingKey(msg.Type, msg.Payload)
+	}
+	if key == "" {
+		if !handler.acquireWorker() {
+			return
+		}
+		go func() {
+			defer handler.releaseWorker()
+			handler.handleSendReq(msg)
+		}()
+		return
+	}
+	//The worker of the key is still running, queue the request to it
+	//The worker only takes the lock when its queue is empty, so sending with the lock held won't block forever
+	//该键的工作协程仍在运行，加入其队列
+	//工作协程仅在队列为空时获取锁，所以持有锁发送不会一直阻塞
+	handler.orderLock.Lock()
+	if queue, ok := handler.orderQueues[key]; ok {
+		queue <- msg
+		handler.orderLock.Unlock()
+		return
+	}
+	handler.orderLock.Unlock()
+	if !handler.acquireWorker() {
+		return
+	}
+	queue := make(chan *packet.SendReq, handler.app.queueLength())
+	queue <- msg
+	handler.orderLock.Lock()
+	handler.orderQueues[key] = queue
+	handler.orderLock.Unlock()
+	go handler.runOrdered(key, queue)
+}
+
+// Process the requests with the same key one by one, the worker exits once its queue is empty
+// 逐个处理键相同的请求，队列为空时退出
+func (handler *MessageHandler) runOrdered(key string, queue chan *packet.SendReq) {
+	defer handler.releaseWorker()
+	for {
+		select {
+		case msg := <-queue:
+			handler.handleSendReq(msg)
+		default:
+			handler.orderLock.Lock()
+			if len(queue) == 0 {
+				delete(handler.orderQueues, key)
+				handler.orderLock.Unlock()
+				return
+			}
+			handler.orderLock.Unlock()
+		}
+	}
+}
+
+// Wait for a free worker, returns false if the handler stops first
+// 等待空闲的工作协程，连接先停止时返回false
+func (handler *MessageHandler) acquireWorker() bool {
+	select {
+	case handler.workers <- struct{}{}:
+	case <-handler.ctx.Done():
+		return false
+	}
+	//Don't add to the group once it's stopped, the workers may be waited for already
+	//停止后不再加入，可能已经在等待工作协程
+	handler.stopLock.Lock()
+	defer handler.stopLock.Unlock()
+	if handler.isStop {
+		<-handler.workers
+		return false
+	}
+	handler.workerGroup.Add(1)
+	return true
+}
+
+func (handler *MessageHandler) releaseWorker() {
+	<-handler.workers
+	handler.workerGroup.Done()
+}
@@ -0,0 +1,195 @@
+package gosocket_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	gosocket "github.com/yankawayu/go-socket"
+	"github.com/yankawayu/go-socket/gosockettest"
+)
+
+// The controllers are created for each request, so their hooks are kept here
+var workerHooks struct {
+	lock    sync.Mutex
+	release chan struct{} // Slow blocks until it's closed
+	started chan struct{} // Slow sends to it once it's running, if it's set
+	running int           // The number of Ordered running
+	maxRun  int           // The max number of Ordered running at the same time
+	seqs    []int         // The sequences of Ordered in the order they are processed
+	logouts int           // The number of workerUser logged out
+}
+
+// workerUser counts the logouts, so that the test can tell whether it logs out before the workers exit
+type workerUser struct {
+	gosockettest.FakeUser
+}
+
+func (user *workerUser) Logout(isKickOut bool) {
+	workerHooks.lock.Lock()
+	workerHooks.logouts++
+	workerHooks.lock.Unlock()
+	user.FakeUser.Logout(isKickOut)
+}
+
+type workerController struct {
+	gosocket.Controller
+}
+
+type workerParam struct {
+	Seq int `json:"seq"`
+}
+
+func (controller *workerController) GetActionParamMap() map[string]interface{} {
+	return map[string]interface{}{
+		"Slow":    &workerParam{},
+		"Fast":    &workerParam{},
+		"Ordered": &workerParam{},
+	}
+}
+
+func (controller *workerController) Slow(param *workerParam, response *gosocket.ResponseBody) {
+	workerHooks.lock.Lock()
+	release, started := workerHooks.release, workerHooks.started
+	workerHooks.lock.Unlock()
+	if started != nil {
+		started <- struct{}{}
+	}
+	<-release
+	response.Status = gosocket.StatusSuccess
+}
+
+func (controller *workerController) Fast(param *workerParam, response *gosocket.ResponseBody) {
+	response.Status = gosocket.StatusSuccess
+}
+
+func (controller *workerController) Ordered(param *workerParam, response *gosocket.ResponseBody) {
+	workerHooks.lock.Lock()
+	workerHooks.running++
+	if workerHooks.running > workerHooks.maxRun {
+		workerHooks.maxRun = workerHooks.running
+	}
+	workerHooks.seqs = append(workerHooks.seqs, param.Seq)
+	workerHooks.lock.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	workerHooks.lock.Lock()
+	workerHooks.running--
+	workerHooks.lock.Unlock()
+	response.Status = gosocket.StatusSuccess
+}
+
+func newWorkerServer(appConfig *gosocket.AppConfig) *gosockettest.Server {
+	app := gosocket.NewApp()
+	app.Router("worker", &workerController{})
+	return gosockettest.NewAppServerWithConfig(app, &workerUser{}, appConfig)
+}
+
+func workerLogouts() int {
+	workerHooks.lock.Lock()
+	defer workerHooks.lock.Unlock()
+	return workerHooks.logouts
+}
+
+func TestSlowRequestDoesNotBlockOthers(t *testing.T) {
+	release := make(chan struct{})
+	workerHooks.lock.Lock()
+	workerHooks.release = release
+	workerHooks.lock.Unlock()
+	server := newWorkerServer(&gosocket.AppConfig{MaxConcurrentRequests: 4})
+	defer server.Close()
+	client, err := server.Connect(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slowDone := make(chan error, 1)
+	go func() {
+		slowDone <- client.Call(context.Background(), "worker.Slow", &workerParam{}, nil)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := client.Call(ctx, "worker.Fast", &workerParam{}, nil); err != nil {
+		t.Fatalf("fast request behind the slow one: %v", err)
+	}
+	select {
+	case err := <-slowDone:
+		t.Fatalf("slow request returned before being released: %v", err)
+	default:
+	}
+	close(release)
+	if err := <-slowDone; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOrderingKeyKeepsRequestsInOrder(t *testing.T) {
+	workerHooks.lock.Lock()
+	workerHooks.running, workerHooks.maxRun, workerHooks.seqs = 0, 0, nil
+	workerHooks.lock.Unlock()
+	server := newWorkerServer(&gosocket.AppConfig{
+		MaxConcurrentRequests: 4,
+		OrderingKey:           gosocket.OrderByType,
+	})
+	defer server.Close()
+	client, err := server.Connect(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const count = 5
+	var group sync.WaitGroup
+	group.Add(count)
+	for seq := 0; seq < count; seq++ {
+		//GetData returns once the request is written, so they arrive in order
+		client.GetData("worker.Ordered", &workerParam{Seq: seq}, func(err error, data string) {
+			if err != nil {
+				t.Error(err)
+			}
+			group.Done()
+		}, nil)
+	}
+	group.Wait()
+	workerHooks.lock.Lock()
+	defer workerHooks.lock.Unlock()
+	if workerHooks.maxRun != 1 {
+		t.Fatalf("%d requests of the same key ran at the same time", workerHooks.maxRun)
+	}
+	for i, seq := range workerHooks.seqs {
+		if seq != i {
+			t.Fatalf("processed in the order %v", workerHooks.seqs)
+		}
+	}
+}
+
+func TestLogoutWaitsForWorkers(t *testing.T) {
+	release, started := make(chan struct{}), make(chan struct{}, 1)
+	workerHooks.lock.Lock()
+	workerHooks.release, workerHooks.started, workerHooks.logouts = release, started, 0
+	workerHooks.lock.Unlock()
+	defer func() {
+		workerHooks.lock.Lock()
+		workerHooks.started = nil
+		workerHooks.lock.Unlock()
+	}()
+	server := newWorkerServer(&gosocket.AppConfig{MaxConcurrentRequests: 4})
+	defer server.Close()
+	client, err := server.Connect(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.GetData("worker.Slow", &workerParam{}, func(err error, data string) {}, nil)
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("the slow request isn't running")
+	}
+	client.Disconnect()
+	time.Sleep(50 * time.Millisecond)
+	if logouts := workerLogouts(); logouts != 0 {
+		t.Fatal("logged out before the slow request returns")
+	}
+	close(release)
+	waitFor(t, time.Second, func() bool { return workerLogouts() == 1 })
+	if server.App.ClientPool().GetClientByUid(1) != nil {
+		t.Fatal("the user is still in the pool after logging out")
+	}
+}